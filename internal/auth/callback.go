@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ManualCode desativa o servidor de callback: o código de autorização é sempre
+// colado manualmente.
+var ManualCode = false
+
+// CallbackTimeout limita a espera pelo redirecionamento do navegador.
+var CallbackTimeout = 5 * time.Minute
+
+// callbackServer recebe o redirecionamento OAuth em localhost.
+type callbackServer struct {
+	listener net.Listener
+	// redirectURL é o redirect_uri a usar na URL de autorização, com a porta
+	// efetivamente aberta.
+	redirectURL string
+	path        string
+}
+
+// listenForCallback abre a porta do redirect_uri (localhost). Quando o
+// redirect_uri não tem porta, uma porta livre é escolhida: o Google aceita
+// qualquer porta em redirects de loopback de apps instalados.
+func listenForCallback(redirectURL string) (*callbackServer, error) {
+	u, err := url.Parse(redirectURL)
+	if err != nil {
+		return nil, fmt.Errorf("redirect_uri inválido %q: %w", redirectURL, err)
+	}
+	host := u.Hostname()
+	if u.Scheme != "http" || (host != "localhost" && host != "127.0.0.1") {
+		return nil, fmt.Errorf("redirect_uri %q não aponta para localhost", redirectURL)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "0"
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		return nil, err
+	}
+	u.Host = net.JoinHostPort(host, fmt.Sprint(l.Addr().(*net.TCPAddr).Port))
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	return &callbackServer{listener: l, redirectURL: u.String(), path: path}, nil
+}
+
+// wait serve o callback até receber um código com o state esperado (ou um erro)
+// e encerra o servidor.
+func (s *callbackServer) wait(state string, timeout time.Duration) (string, error) {
+	type result struct {
+		code string
+		err  error
+	}
+	results := make(chan result, 1)
+	send := func(r result) {
+		select {
+		case results <- r:
+		default:
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(s.path, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Get("error") != "":
+			http.Error(w, "Autorização negada: "+q.Get("error"), http.StatusBadRequest)
+			send(result{err: fmt.Errorf("autorização negada: %s", q.Get("error"))})
+		case q.Get("state") != state:
+			http.Error(w, "State inválido; tente de novo.", http.StatusBadRequest)
+			send(result{err: errors.New("state do callback não confere (possível CSRF)")})
+		case q.Get("code") == "":
+			http.Error(w, "Parâmetro 'code' ausente.", http.StatusBadRequest)
+		default:
+			fmt.Fprintln(w, "✅ Autorização concluída. Pode fechar esta janela e voltar ao terminal.")
+			send(result{code: q.Get("code")})
+		}
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(s.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			send(result{err: err})
+		}
+	}()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}()
+
+	select {
+	case r := <-results:
+		return r.code, r.err
+	case <-time.After(timeout):
+		return "", fmt.Errorf("nenhum callback recebido em %s", timeout)
+	}
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"setup/shared/utils"
+)
+
+// DriveScope é o escopo pedido pelos fluxos de token quando GOOGLE_SCOPES não
+// está definido.
+const DriveScope = "https://www.googleapis.com/auth/drive"
+
+// clientSecretPattern casa os JSON de cliente OAuth baixados do Google Cloud.
+const clientSecretPattern = "client_secret_*.json"
+
+// CredentialsFile retorna o JSON do cliente OAuth usado pelos fluxos de token:
+// $GOOGLE_CREDENTIALS_FILE ou, sem ele, o único client_secret_*.json da raiz do
+// setup (ou do diretório atual).
+func CredentialsFile() (string, error) {
+	candidates := clientSecretCandidates()
+	if v := os.Getenv("GOOGLE_CREDENTIALS_FILE"); v != "" {
+		path, err := utils.ExpandHome(v)
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("GOOGLE_CREDENTIALS_FILE=%s não encontrado%s", v, describeCandidates(candidates))
+		}
+		return path, nil
+	}
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("nenhum %s encontrado em %s; defina GOOGLE_CREDENTIALS_FILE", clientSecretPattern, strings.Join(clientSecretDirs(), " ou "))
+	case 1:
+		return candidates[0], nil
+	default:
+		return "", fmt.Errorf("mais de um JSON de cliente OAuth encontrado; escolha um com GOOGLE_CREDENTIALS_FILE%s", describeCandidates(candidates))
+	}
+}
+
+// Scopes retorna os escopos de $GOOGLE_SCOPES (separados por vírgula) ou, sem
+// ele, DriveScope.
+func Scopes() []string {
+	var scopes []string
+	for _, s := range strings.Split(os.Getenv("GOOGLE_SCOPES"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			scopes = append(scopes, s)
+		}
+	}
+	if len(scopes) == 0 {
+		return []string{DriveScope}
+	}
+	return scopes
+}
+
+// clientSecretDirs retorna os diretórios onde os JSON de cliente são procurados.
+func clientSecretDirs() []string {
+	var dirs []string
+	if root, err := utils.SetupRoot(); err == nil {
+		dirs = append(dirs, root)
+	}
+	if wd, err := os.Getwd(); err == nil && (len(dirs) == 0 || wd != dirs[0]) {
+		dirs = append(dirs, wd)
+	}
+	return dirs
+}
+
+// clientSecretCandidates lista os client_secret_*.json de clientSecretDirs.
+func clientSecretCandidates() []string {
+	var candidates []string
+	for _, dir := range clientSecretDirs() {
+		matches, _ := filepath.Glob(filepath.Join(dir, clientSecretPattern))
+		candidates = append(candidates, matches...)
+	}
+	return candidates
+}
+
+// describeCandidates formata os candidatos encontrados para mensagens de erro.
+func describeCandidates(candidates []string) string {
+	if len(candidates) == 0 {
+		return ""
+	}
+	return "; candidatos encontrados:\n  " + strings.Join(candidates, "\n  ")
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"setup/internal/prompt"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// DeviceFlow faz RunRefreshTokenFlow usar o fluxo de dispositivo (veja
+// GetRefreshTokenDeviceFlow), para máquinas sem navegador.
+var DeviceFlow = false
+
+// GetRefreshTokenDeviceFlow obtém um refresh token pelo fluxo de autorização de
+// dispositivo do OAuth 2.0 (RFC 8628): mostra uma URL e um código para digitar
+// em qualquer outro aparelho e consulta o Google até a autorização, a recusa ou
+// a expiração do código. O cliente OAuth precisa ser do tipo "TV e dispositivos
+// de entrada limitada", e o Google só aceita alguns escopos nesse fluxo (o
+// drive.file, por exemplo, mas não o drive completo).
+func GetRefreshTokenDeviceFlow(credentialsFile string, scopes []string) (string, error) {
+	return GetRefreshTokenDeviceFlowWithPrompter(prompt.Stdio(), credentialsFile, scopes)
+}
+
+// GetRefreshTokenDeviceFlowWithPrompter é como GetRefreshTokenDeviceFlow, mas
+// exibe as instruções através de p.
+func GetRefreshTokenDeviceFlowWithPrompter(p prompt.Prompter, credentialsFile string, scopes []string) (string, error) {
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return "", fmt.Errorf("falha ao ler credenciais: %w", err)
+	}
+	config, err := google.ConfigFromJSON(data, scopes...)
+	if err != nil {
+		return "", fmt.Errorf("falha ao parsear credenciais: %w", err)
+	}
+
+	ctx := context.Background()
+	da, err := config.DeviceAuth(ctx)
+	if err != nil {
+		return "", fmt.Errorf("falha ao solicitar código de dispositivo: %w", deviceFlowError(err))
+	}
+
+	p.Println("🔐 OBTER REFRESH TOKEN DO GOOGLE DRIVE (dispositivo)")
+	p.Println(strings.Repeat("=", 50))
+	p.Println("1) Em qualquer aparelho com navegador, abra:")
+	p.Println("   " + da.VerificationURI)
+	p.Println("2) Digite o código: " + da.UserCode)
+	p.Println()
+	p.Println("⏳ Aguardando a autorização...")
+
+	// DeviceAccessToken consulta no intervalo pedido pelo Google, aumentando-o
+	// a cada slow_down e seguindo enquanto a resposta for authorization_pending.
+	tok, err := config.DeviceAccessToken(ctx, da)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return "", errors.New("o código expirou antes da autorização; tente de novo")
+		}
+		return "", fmt.Errorf("falha ao obter token: %w", deviceFlowError(err))
+	}
+	if tok.RefreshToken == "" {
+		return "", errors.New("nenhum refresh token retornado. Revogue o acesso em https://myaccount.google.com/permissions e tente de novo")
+	}
+	return tok.RefreshToken, nil
+}
+
+// deviceFlowError troca os erros OAuth do fluxo de dispositivo por mensagens
+// que dizem o que fazer.
+func deviceFlowError(err error) error {
+	var re *oauth2.RetrieveError
+	if !errors.As(err, &re) {
+		return err
+	}
+	switch re.ErrorCode {
+	case "access_denied":
+		return errors.New("autorização negada")
+	case "expired_token":
+		return errors.New("o código expirou antes da autorização; tente de novo")
+	case "invalid_client", "unauthorized_client":
+		return fmt.Errorf("%w (o cliente OAuth precisa ser do tipo \"TV e dispositivos de entrada limitada\")", err)
+	case "invalid_scope":
+		return fmt.Errorf("%w (o Google não permite este escopo no fluxo de dispositivo; defina GOOGLE_SCOPES, por exemplo, como https://www.googleapis.com/auth/drive.file)", err)
+	}
+	return err
+}
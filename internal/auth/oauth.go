@@ -73,9 +73,11 @@ func SaveTokenToFile(token *oauth2.Token, filePath string) error {
 
 // RunOAuthTokenFlow executa o fluxo completo para gerar token OAuth
 func RunOAuthTokenFlow() error {
-	const credentialsFile = "client_secret_2_601804493169-nh1uc56rqsuco7f2f7saplpjg21tijse.apps.googleusercontent.com.json"
 	const tokenFile = "token.json"
-	const scopes = "https://www.googleapis.com/auth/drive"
+	credentialsFile, err := CredentialsFile()
+	if err != nil {
+		return err
+	}
 
 	fmt.Println("🔑 GERAR TOKEN OAUTH DO GOOGLE DRIVE")
 	fmt.Println(strings.Repeat("=", 50))
@@ -90,7 +92,7 @@ func RunOAuthTokenFlow() error {
 
 	// Gera o token OAuth completo
 	fmt.Println("🔄 Gerando token OAuth...")
-	token, err := GenerateOAuthToken(credentialsFile, refreshToken, []string{scopes})
+	token, err := GenerateOAuthToken(credentialsFile, refreshToken, Scopes())
 	if err != nil {
 		return fmt.Errorf("erro ao gerar token OAuth: %w", err)
 	}
@@ -106,14 +108,7 @@ func RunOAuthTokenFlow() error {
 	// Exibe informações do token
 	fmt.Println("\n📋 INFORMAÇÕES DO TOKEN:")
 	fmt.Println(strings.Repeat("-", 30))
-	fmt.Printf("Access Token: %s...%s\n", token.AccessToken[:20], token.AccessToken[len(token.AccessToken)-10:])
-	fmt.Printf("Token Type: %s\n", token.TokenType)
-	fmt.Printf("Expira em: %s\n", token.Expiry.Format("2006-01-02 15:04:05"))
-	fmt.Printf("Válido por: %s\n", time.Until(token.Expiry).Round(time.Minute))
-
-	if token.RefreshToken != "" {
-		fmt.Printf("Refresh Token: %s...%s\n", token.RefreshToken[:20], token.RefreshToken[len(token.RefreshToken)-10:])
-	}
+	fmt.Print(DescribeToken(token))
 
 	fmt.Println("\n💡 PRÓXIMOS PASSOS:")
 	fmt.Printf("1. Use o arquivo %s em suas aplicações\n", tokenFile)
@@ -122,3 +117,41 @@ func RunOAuthTokenFlow() error {
 
 	return nil
 }
+
+// DescribeToken formata as informações de tok (tokens mascarados, tipo,
+// expiração e se há refresh token), uma por linha.
+func DescribeToken(tok *oauth2.Token) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Access Token: %s\n", maskSecret(tok.AccessToken))
+	fmt.Fprintf(&b, "Token Type: %s\n", tok.Type())
+	if tok.Expiry.IsZero() {
+		fmt.Fprintln(&b, "Expira em: (sem expiração registrada)")
+	} else {
+		fmt.Fprintf(&b, "Expira em: %s\n", tok.Expiry.Format("2006-01-02 15:04:05"))
+		if left := time.Until(tok.Expiry); left > 0 {
+			fmt.Fprintf(&b, "Válido por: %s\n", left.Round(time.Minute))
+		} else {
+			fmt.Fprintf(&b, "Expirado há: %s\n", (-left).Round(time.Minute))
+		}
+	}
+	if tok.RefreshToken != "" {
+		fmt.Fprintf(&b, "Refresh Token: %s\n", maskSecret(tok.RefreshToken))
+	} else {
+		fmt.Fprintln(&b, "Refresh Token: (ausente)")
+	}
+	return b.String()
+}
+
+// maskSecret mostra só o começo e o fim de um segredo, menos dele quanto mais
+// curto ele for; segredos curtos demais são mascarados por inteiro.
+func maskSecret(s string) string {
+	switch {
+	case s == "":
+		return "(ausente)"
+	case len(s) > 30:
+		return s[:20] + "..." + s[len(s)-10:]
+	case len(s) >= 12:
+		return s[:4] + "..." + s[len(s)-4:]
+	}
+	return strings.Repeat("*", len(s))
+}
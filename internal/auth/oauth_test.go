@@ -0,0 +1,16 @@
+package auth
+
+import "testing"
+
+func TestMaskSecret(t *testing.T) {
+	for s, want := range map[string]string{
+		"":              "(ausente)",
+		"abcde":         "*****",
+		"ya29.abcdefgh": "ya29...efgh",
+		"1//0gABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789": "1//0gABCDEFGHIJKLMNO...0123456789",
+	} {
+		if got := maskSecret(s); got != want {
+			t.Errorf("maskSecret(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"os"
 	"strings"
 
+	"setup/internal/prompt"
+
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
@@ -21,6 +23,14 @@ func randomState(n int) string {
 
 // GetRefreshToken executa o fluxo OAuth 2.0 para obter um refresh token
 func GetRefreshToken(credentialsFile string, scopes []string) (string, error) {
+	return GetRefreshTokenWithPrompter(prompt.Stdio(), credentialsFile, scopes)
+}
+
+// GetRefreshTokenWithPrompter é como GetRefreshToken, mas exibe as instruções
+// através de p. O código de autorização é recebido por um servidor local no
+// redirect_uri; se a porta não puder ser aberta (ou com ManualCode), ele é lido
+// de p.
+func GetRefreshTokenWithPrompter(p prompt.Prompter, credentialsFile string, scopes []string) (string, error) {
 	data, err := os.ReadFile(credentialsFile)
 	if err != nil {
 		return "", fmt.Errorf("falha ao ler credenciais: %w", err)
@@ -33,6 +43,17 @@ func GetRefreshToken(credentialsFile string, scopes []string) (string, error) {
 
 	state := randomState(12)
 
+	var callback *callbackServer
+	if !ManualCode {
+		callback, err = listenForCallback(config.RedirectURL)
+		if err != nil {
+			p.Println("⚠️  Não foi possível receber o callback em localhost (" + err.Error() + "); usando o modo manual.")
+			callback = nil
+		} else {
+			config.RedirectURL = callback.redirectURL
+		}
+	}
+
 	// prompt=consent força re-exibir consentimento e aumenta chance de vir refresh token
 	authURL := config.AuthCodeURL(
 		state,
@@ -40,17 +61,43 @@ func GetRefreshToken(credentialsFile string, scopes []string) (string, error) {
 		oauth2.SetAuthURLParam("prompt", "consent"),
 	)
 
-	fmt.Println("🔐 OBTER REFRESH TOKEN DO GOOGLE DRIVE")
-	fmt.Println(strings.Repeat("=", 50))
-	fmt.Println("1) Abra a URL abaixo no navegador e autorize o acesso:")
-	fmt.Println("   " + authURL)
-	fmt.Println()
-	fmt.Println("2) Depois da autorização aparecerá um erro em localhost (isso é esperado).")
-	fmt.Println("3) Copie o valor do parâmetro 'code' da URL (não inclua '&scope=...').")
-	fmt.Print("\n📝 Cole aqui o código de autorização: ")
+	p.Println("🔐 OBTER REFRESH TOKEN DO GOOGLE DRIVE")
+	p.Println(strings.Repeat("=", 50))
+	p.Println("1) Abra a URL abaixo no navegador e autorize o acesso:")
+	p.Println("   " + authURL)
+	p.Println()
+
+	var authCode string
+	if callback != nil {
+		p.Println("⏳ Aguardando a autorização em " + callback.redirectURL + " ...")
+		authCode, err = callback.wait(state, CallbackTimeout)
+		if err != nil {
+			return "", fmt.Errorf("falha ao receber código: %w", err)
+		}
+	} else if authCode, err = readAuthCode(p); err != nil {
+		return "", err
+	}
+
+	tok, err := config.Exchange(context.Background(), authCode)
+	if err != nil {
+		return "", fmt.Errorf("falha ao trocar código por token: %w", err)
+	}
+
+	if tok.RefreshToken == "" {
+		return "", fmt.Errorf("nenhum refresh token retornado. Revogue o acesso em https://myaccount.google.com/permissions e tente de novo (ou verifique se usou prompt=consent)")
+	}
 
-	var raw string
-	if _, err := fmt.Scan(&raw); err != nil {
+	return tok.RefreshToken, nil
+}
+
+// readAuthCode pede ao usuário o código de autorização copiado da URL de
+// redirecionamento.
+func readAuthCode(p prompt.Prompter) (string, error) {
+	p.Println("2) Depois da autorização aparecerá um erro em localhost (isso é esperado).")
+	p.Println("3) Copie o valor do parâmetro 'code' da URL (não inclua '&scope=...').")
+
+	raw, err := p.ReadLine("\n📝 Cole aqui o código de autorização: ")
+	if err != nil {
 		return "", fmt.Errorf("falha ao ler código: %w", err)
 	}
 
@@ -65,25 +112,21 @@ func GetRefreshToken(credentialsFile string, scopes []string) (string, error) {
 			}
 		}
 	}
-
-	tok, err := config.Exchange(context.Background(), authCode)
-	if err != nil {
-		return "", fmt.Errorf("falha ao trocar código por token: %w", err)
-	}
-
-	if tok.RefreshToken == "" {
-		return "", fmt.Errorf("nenhum refresh token retornado. Revogue o acesso em https://myaccount.google.com/permissions e tente de novo (ou verifique se usou prompt=consent)")
-	}
-
-	return tok.RefreshToken, nil
+	return authCode, nil
 }
 
 // RunRefreshTokenFlow executa o fluxo completo para obter refresh token
 func RunRefreshTokenFlow() error {
-	const credentialsFile = "client_secret_2_601804493169-nh1uc56rqsuco7f2f7saplpjg21tijse.apps.googleusercontent.com.json"
-	const scopes = "https://www.googleapis.com/auth/drive"
+	credentialsFile, err := CredentialsFile()
+	if err != nil {
+		return err
+	}
 
-	refreshToken, err := GetRefreshToken(credentialsFile, []string{scopes})
+	get := GetRefreshToken
+	if DeviceFlow {
+		get = GetRefreshTokenDeviceFlow
+	}
+	refreshToken, err := get(credentialsFile, Scopes())
 	if err != nil {
 		return fmt.Errorf("erro ao obter refresh token: %w", err)
 	}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"setup/internal/prompt"
+)
+
+func TestReadAuthCode(t *testing.T) {
+	for _, tc := range []struct {
+		name, pasted, want string
+	}{
+		{"bare code", "4/0AbCdEf", "4/0AbCdEf"},
+		{"full url", "http://localhost/?state=xyz&code=4/0AbCdEf&scope=https://www.googleapis.com/auth/drive", "4/0AbCdEf"},
+		{"code last", "http://localhost/?code=4/0AbCdEf", "4/0AbCdEf"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &prompt.Scripted{Answers: []string{tc.pasted}}
+			got, err := readAuthCode(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("readAuthCode(%q) = %q, want %q", tc.pasted, got, tc.want)
+			}
+		})
+	}
+
+	if _, err := readAuthCode(&prompt.Scripted{}); err == nil {
+		t.Error("readAuthCode without input succeeded")
+	}
+}
+
+func TestGetRefreshTokenManualCode(t *testing.T) {
+	var gotCode string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		gotCode = r.PostForm.Get("code")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  "ya29.test",
+			"refresh_token": "1//refresh",
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+		})
+	}))
+	defer server.Close()
+
+	creds, err := json.Marshal(map[string]any{"installed": map[string]any{
+		"client_id":     "id.apps.googleusercontent.com",
+		"client_secret": "secret",
+		"auth_uri":      server.URL + "/auth",
+		"token_uri":     server.URL + "/token",
+		"redirect_uris": []string{"http://localhost"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialsFile := filepath.Join(t.TempDir(), "credentials.json")
+	if err := os.WriteFile(credentialsFile, creds, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	old := ManualCode
+	ManualCode = true
+	t.Cleanup(func() { ManualCode = old })
+
+	p := &prompt.Scripted{Answers: []string{"http://localhost/?code=4/0AbCdEf&scope=drive"}}
+	token, err := GetRefreshTokenWithPrompter(p, credentialsFile, Scopes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "1//refresh" {
+		t.Errorf("refresh token = %q, want %q", token, "1//refresh")
+	}
+	if gotCode != "4/0AbCdEf" {
+		t.Errorf("exchanged code = %q, want %q", gotCode, "4/0AbCdEf")
+	}
+	if out := p.Output.String(); !strings.Contains(out, server.URL+"/auth?") {
+		t.Errorf("auth URL not shown:\n%s", out)
+	}
+}
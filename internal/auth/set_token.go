@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+
+	"setup/shared/utils"
+)
+
+// SetTokenOpts descreve a troca não interativa do refresh token.
+type SetTokenOpts struct {
+	// RefreshToken é o novo refresh token.
+	RefreshToken string
+	// CredentialsFile é o JSON do cliente OAuth baixado do Google Cloud.
+	CredentialsFile string
+	Scopes          []string
+	// EnvFile é o .env atualizado com as credenciais e o token.
+	EnvFile string
+	// TokenFile, se definido, também recebe o token em JSON.
+	TokenFile string
+}
+
+// SetToken troca o refresh token por um access token, testa o token com uma
+// chamada ao Drive e só então grava o .env (e o token.json, se pedido).
+func SetToken(opts SetTokenOpts) (*oauth2.Token, error) {
+	refreshToken := strings.TrimSpace(opts.RefreshToken)
+	if refreshToken == "" {
+		return nil, errors.New("refresh token vazio")
+	}
+	data, err := os.ReadFile(opts.CredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler credenciais: %w", err)
+	}
+	config, err := google.ConfigFromJSON(data, opts.Scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao parsear credenciais: %w", err)
+	}
+
+	token, err := GenerateOAuthToken(opts.CredentialsFile, refreshToken, opts.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = refreshToken
+	}
+
+	if err := testDriveAccess(config, token); err != nil {
+		return nil, err
+	}
+
+	if err := updateEnvFile(opts.EnvFile, config, token); err != nil {
+		return nil, err
+	}
+	if opts.TokenFile != "" {
+		if err := SaveTokenToFile(token, opts.TokenFile); err != nil {
+			return nil, err
+		}
+	}
+	return token, nil
+}
+
+// testDriveAccess faz uma chamada mínima ao Drive com o token.
+func testDriveAccess(config *oauth2.Config, token *oauth2.Token) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	srv, err := drive.NewService(ctx, option.WithHTTPClient(config.Client(ctx, token)))
+	if err != nil {
+		return fmt.Errorf("falha ao criar cliente do Drive: %w", err)
+	}
+	if _, err := srv.About.Get().Fields("user").Context(ctx).Do(); err != nil {
+		return fmt.Errorf("o token não funciona no Google Drive: %w", err)
+	}
+	return nil
+}
+
+// updateEnvFile grava as credenciais e o token em path, preservando as demais
+// variáveis e os comentários. O arquivo é substituído atomicamente, com permissão 0600.
+func updateEnvFile(path string, config *oauth2.Config, token *oauth2.Token) error {
+	values := map[string]string{
+		"GOOGLE_CLIENT_ID":     config.ClientID,
+		"GOOGLE_CLIENT_SECRET": config.ClientSecret,
+		"GOOGLE_AUTH_URI":      config.Endpoint.AuthURL,
+		"GOOGLE_TOKEN_URI":     config.Endpoint.TokenURL,
+		"GOOGLE_REDIRECT_URIS": config.RedirectURL,
+		"GOOGLE_ACCESS_TOKEN":  token.AccessToken,
+		"GOOGLE_REFRESH_TOKEN": token.RefreshToken,
+		"GOOGLE_TOKEN_TYPE":    token.TokenType,
+		"GOOGLE_TOKEN_EXPIRY":  token.Expiry.Format(time.RFC3339Nano),
+	}
+	if err := utils.UpdateEnvFile(path, values, 0o600); err != nil {
+		return fmt.Errorf("erro ao gravar %s: %w", path, err)
+	}
+	return nil
+}
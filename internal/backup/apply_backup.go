@@ -1,11 +1,13 @@
 package backup
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"setup/internal/clone"
 	"setup/shared/utils"
@@ -14,15 +16,21 @@ import (
 // BackupStep represents a phase of applying a backup. The Filter receives the
 // relative path (from the extracted tmp root) plus the file info and returns
 // true if that path should be applied in the given step.
+//
+// PreHook and PostHook, when set, run before and after the step (except on a dry
+// run); a failing PreHook stops the apply before the step runs. The built-in steps have none
+// (see ApplyBackupOpts.ConfigureStep).
 type BackupStep struct {
-	Name   string
-	Filter func(rel string, info os.FileInfo) bool
+	Name     string
+	Filter   func(rel string, info os.FileInfo) bool
+	PreHook  func() error
+	PostHook func() error
 }
 
 // buildBackupSteps builds the ordered list of steps. Currently supports:
-//  1. "before clone"  -> apply everything except git repos (github.com paths) and the ~/setup repo,
-//     but still include ~/.gitconfig
-//  2. "after clone"   -> apply only git-related content (github.com paths and ~/setup)
+//  1. "before clone"  -> apply everything except git repos (github.com paths) and the setup repo
+//     (utils.SetupRoot), but still include ~/.gitconfig
+//  2. "after clone"   -> apply only git-related content (github.com paths and the setup repo)
 //
 // This function derives path filters based on the current user's home directory
 // so that relative paths inside the extracted backup can be matched reliably.
@@ -33,7 +41,11 @@ func buildBackupSteps(home string) []BackupStep {
 	relHomePrefix = strings.TrimPrefix(relHomePrefix, "./")
 	relHomePrefix = filepath.ToSlash(relHomePrefix)
 
-	setupRelPath := filepath.ToSlash(filepath.Join(relHomePrefix, "setup"))
+	setupRoot, err := utils.SetupRoot()
+	if err != nil {
+		setupRoot = filepath.Join(home, "setup")
+	}
+	setupRelPath := filepath.ToSlash(trimLeadingSlash(filepath.Clean(setupRoot)))
 	userGitConfig := filepath.ToSlash(filepath.Join(relHomePrefix, ".gitconfig"))
 
 	return []BackupStep{
@@ -87,7 +99,7 @@ func buildBackupSteps(home string) []BackupStep {
 	}
 }
 
-// ApplyBackup extracts a .tar.xz backup into a temporary directory, then applies
+// ApplyBackup extracts a .tar.xz or .tar.zst backup into a temporary directory, then applies
 // it in ordered steps (e.g., before clone, after clone). After applying, the
 // temporary directory is removed. If backupFile is empty, it discovers the most
 // recent backup via Google Drive.
@@ -95,18 +107,106 @@ func ApplyBackup(backupFile string) error {
 	return ApplyBackupSelected(backupFile, nil)
 }
 
-// GetBackupStepNames returns the names of all available backup steps, in order.
+// GetBackupStepNames returns the names of all available backup steps, in order,
+// or nil when they cannot be determined (see BackupStepNames).
 func GetBackupStepNames() []string {
+	names, _ := BackupStepNames()
+	return names
+}
+
+// BackupStepNames returns the names of all available backup steps, in order.
+// The steps depend on the home directory, so it fails when that is unknown.
+func BackupStepNames() ([]string, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("could not get user home: %w", err)
 	}
 	steps := buildBackupSteps(home)
-	var names []string
+	names := make([]string, 0, len(steps))
 	for _, s := range steps {
 		names = append(names, s.Name)
 	}
-	return names
+	return names, nil
+}
+
+// ApplyBackupOpts controls optional behavior of ApplyBackupWithOpts.
+type ApplyBackupOpts struct {
+	// NoDownload never downloads from the backup store: a backup named without
+	// a path, and the bases of an incremental one, must already be in the local
+	// backups dir, and no name means the newest archive there.
+	NoDownload bool
+	// Steps restricts the run to the named steps (case-insensitive); empty runs all.
+	Steps []string
+	// ExcludeSteps runs every step but the named ones (case-insensitive). It
+	// cannot be combined with Steps.
+	ExcludeSteps []string
+	// ValidateJSON parses every restored file matching ValidatePatterns after the
+	// steps ran and warns about the ones that are not valid JSON.
+	ValidateJSON bool
+	// Strict turns validation warnings into an error.
+	Strict bool
+	// BackupSet, when set, restricts the restore to the paths declared by the
+	// named backup set.
+	BackupSet string
+	// Overwrite decides what happens to files that already exist on disk. With
+	// utils.OverwriteIfNewer the archived time is taken from the manifest.
+	Overwrite utils.OverwritePolicy
+	// Confirm is asked before overwriting a file under utils.OverwritePrompt.
+	Confirm func(target string) (bool, error)
+	// KeepArchivedHome restores files under the home directory recorded in the
+	// manifest instead of remapping them to the current user's home.
+	KeepArchivedHome bool
+	// KeepRemoved skips deleting the paths listed in the manifest's Remove.
+	KeepRemoved bool
+	// CloneReport receives the results of the "clone all" step. When nil they are
+	// printed as a table.
+	CloneReport func([]clone.CloneResult)
+	// DryRun prints what each step would create, overwrite or remove without
+	// writing anything. The "clone all" step and validation are skipped.
+	DryRun bool
+	// ConfigureStep, when set, is called with each selected step before the
+	// steps run, e.g. to set its PreHook or PostHook.
+	ConfigureStep func(step *BackupStep)
+	// ApplyConcurrency bounds how many files are restored in parallel; 0 uses
+	// DefaultApplyConcurrency. Files restored under utils.OverwritePrompt are
+	// still asked about one at a time.
+	ApplyConcurrency int
+	// NoHooks skips the executables in the pre-apply.d and post-apply.d
+	// directories of HooksDir, which otherwise run before and after the steps.
+	NoHooks bool
+}
+
+// applyConfig carries the per-run settings used while applying a step.
+type applyConfig struct {
+	// Manifest is nil for archives without one.
+	Manifest  *Manifest
+	Overwrite utils.OverwritePolicy
+	Confirm   func(target string) (bool, error)
+	// DryRun reports the changes instead of making them.
+	DryRun bool
+	// OriginalsDir receives a copy of every file apply replaces or removes; it
+	// is the rollback point of the apply (see Rollback).
+	OriginalsDir string
+	// Concurrency bounds the parallel file restores.
+	Concurrency int
+}
+
+// DefaultApplyConcurrency is the number of files restored in parallel when
+// ApplyBackupOpts.ApplyConcurrency is not set.
+const DefaultApplyConcurrency = 4
+
+// newApplyConfig returns the step settings for opts and the manifest of the
+// backup being applied.
+func newApplyConfig(opts ApplyBackupOpts, m *Manifest) applyConfig {
+	cfg := applyConfig{Manifest: m, Overwrite: opts.Overwrite, Confirm: opts.Confirm, DryRun: opts.DryRun, Concurrency: opts.ApplyConcurrency}
+	if cfg.Concurrency < 1 {
+		cfg.Concurrency = DefaultApplyConcurrency
+	}
+	if cfg.DryRun && cfg.Overwrite == utils.OverwritePrompt {
+		// Nothing is written, so report differing files as overwritten instead of asking.
+		cfg.Overwrite = utils.OverwriteIfDiffer
+	}
+	return cfg
 }
 
 // ApplyBackupSelected is like ApplyBackup, but allows specifying which steps to run.
@@ -114,110 +214,415 @@ func GetBackupStepNames() []string {
 // If selectedSteps is non-empty, only steps whose names match (case-insensitive) are run.
 // Unknown step names are warned about.
 func ApplyBackupSelected(backupFile string, selectedSteps []string) error {
+	return ApplyBackupWithOpts(backupFile, ApplyBackupOpts{Steps: selectedSteps})
+}
+
+// ApplyBackupWithOpts is like ApplyBackupSelected, but allows tuning the restore with opts.
+func ApplyBackupWithOpts(backupFile string, opts ApplyBackupOpts) error {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return fmt.Errorf("could not get user home: %w", err)
 	}
-	backupsDir := filepath.Join(home, "setup", "backups")
+	backupsDir, err := localBackupsDir()
+	if err != nil {
+		return err
+	}
+
+	setPaths, err := optsSetPaths(opts, home)
+	if err != nil {
+		return err
+	}
+	steps, err := opts.steps(home)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := acquireLock(backupsDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	prepared, err := prepareBackup(backupsDir, backupFile, remapTarget(opts, home), opts.NoDownload, !opts.DryRun)
+	if err != nil {
+		return err
+	}
+	tmpDir := prepared.TmpDir
+	// The extracted backup holds secrets; never leave it behind.
+	defer os.RemoveAll(tmpDir)
+	if prepared.Manifest != nil {
+		prepared.Manifest.printSummary(os.Stdout)
+	}
+	cfg := newApplyConfig(opts, prepared.Manifest)
+	cfg.OriginalsDir = newOriginalsDir(backupsDir)
+
+	runsHooks := !opts.NoHooks && !opts.DryRun
+	var hooksDir string
+	var hookEnv []string
+	if runsHooks {
+		if hooksDir, err = HooksDir(); err != nil {
+			return err
+		}
+		hookEnv = applyHookEnv(tmpDir, steps)
+		if err := runHooks(filepath.Join(hooksDir, preApplyHooks), hookEnv); err != nil {
+			return fmt.Errorf("pre-apply %w; nothing was applied", err)
+		}
+	}
+
+	var restored []string
+	for _, step := range steps {
+		fmt.Printf("Applying backup step: %s\n", step.Name)
+		if step.PreHook != nil && !opts.DryRun {
+			if err := step.PreHook(); err != nil {
+				return &StepError{Step: step.Name, Err: fmt.Errorf("pre-hook of backup step '%s' failed: %w", step.Name, err)}
+			}
+		}
+		applied, err := applyStep(step, opts, setPaths, tmpDir, cfg)
+		if err != nil {
+			return &StepError{Step: step.Name, Err: err}
+		}
+		restored = append(restored, applied...)
+		if step.PostHook != nil && !opts.DryRun {
+			if err := step.PostHook(); err != nil {
+				return &StepError{Step: step.Name, Err: fmt.Errorf("post-hook of backup step '%s' failed: %w", step.Name, err)}
+			}
+		}
+	}
+
+	if (opts.ValidateJSON || opts.Strict) && !opts.DryRun {
+		if err := validateRestoredFiles(restored, opts.Strict); err != nil {
+			return err
+		}
+	}
+
+	if _, err := os.Stat(cfg.OriginalsDir); err == nil {
+		fmt.Printf("Replaced files were saved to %s (undo with: setup rollback)\n", cfg.OriginalsDir)
+	}
+
+	if runsHooks {
+		if err := runHooks(filepath.Join(hooksDir, postApplyHooks), hookEnv); err != nil {
+			return fmt.Errorf("post-apply %w", err)
+		}
+	}
+	return nil
+}
+
+// applyStep runs step of the backup extracted in tmpDir: the "clone all" step
+// clones the repositories, the others restore the files their filter (restricted
+// to setPaths when non-nil) accepts and delete the listed removals. It returns
+// the target paths of the restored files.
+func applyStep(step BackupStep, opts ApplyBackupOpts, setPaths []string, tmpDir string, cfg applyConfig) ([]string, error) {
+	// Special logic for "clone all" step
+	if strings.EqualFold(step.Name, "clone all") {
+		if opts.DryRun {
+			fmt.Println("Would clone all configured repositories.")
+			return nil, nil
+		}
+		if err := runCloneAllStep(opts.CloneReport); err != nil {
+			return nil, fmt.Errorf("could not run 'clone all' step: %w", err)
+		}
+		return nil, nil
+	}
+	if step.Filter == nil {
+		return nil, nil
+	}
+	filter := stepFilter(step, setPaths)
+	applied, err := applyFromTmpWithFilter(tmpDir, filter, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not apply backup step '%s': %w", step.Name, err)
+	}
+	if opts.DryRun {
+		if !opts.KeepRemoved {
+			for _, target := range removalTargets(cfg.Manifest, filter) {
+				fmt.Printf("Would remove %s (a copy would be kept for rollback)\n", target)
+			}
+		}
+		return applied, nil
+	}
+	if !opts.KeepRemoved {
+		if _, err := removeListedFiles(cfg.OriginalsDir, cfg.Manifest, filter); err != nil {
+			return nil, fmt.Errorf("could not apply backup step '%s': %w", step.Name, err)
+		}
+	}
+	return applied, nil
+}
+
+// preparedBackup is a backup extracted into a tmp dir, ready to be applied.
+type preparedBackup struct {
+	// Archive is the local path of the archive.
+	Archive string
+	TmpDir  string
+	// Manifest is nil for archives created before manifests existed.
+	Manifest *Manifest
+}
+
+// prepareBackup resolves backupFile (see locateBackup), downloading it into
+// backupsDir unless it is a local file or noDownload is set, and extracts it into
+// backupsDir/tmp. When home is not empty the archived home directory is remapped
+// to it (see remapHome). runCommands runs the restore commands of pre-processed
+// files (see restorePreProcessed); read-only callers leave it unset.
+func prepareBackup(backupsDir, backupFile, home string, noDownload, runCommands bool) (*preparedBackup, error) {
 	tmpDir := filepath.Join(backupsDir, "tmp")
 
 	// Cleanup any previous tmp directory.
 	_ = os.RemoveAll(tmpDir)
 	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
-		return fmt.Errorf("could not create tmp dir: %w", err)
+		return nil, fmt.Errorf("could not create tmp dir: %w", err)
+	}
+
+	store, err := SelectStore()
+	if err != nil {
+		return nil, err
+	}
+	localPath, download, err := locateBackup(store, backupsDir, backupFile, noDownload)
+	if err != nil {
+		return nil, err
+	}
+
+	// Extract into tmpDir, downloading again once if the archive is damaged.
+	if err := extractWithRetry(backupsDir, localPath, tmpDir, download); err != nil {
+		return nil, withKind(ErrExtractFailed, fmt.Errorf("could not extract backup: %w", err))
 	}
 
-	// Determine backup file if not specified.
+	// The manifest is optional: archives created before it existed have none.
+	manifest, err := ReadManifest(tmpDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if manifest != nil {
+		if err := verifyExtracted(tmpDir, manifest); err != nil {
+			return nil, fmt.Errorf("backup %s failed verification: %w", filepath.Base(localPath), err)
+		}
+	}
+	if err := restoreInherited(store, backupsDir, tmpDir, manifest, noDownload, 0); err != nil {
+		return nil, err
+	}
+	if err := restorePreProcessed(tmpDir, manifest, runCommands); err != nil {
+		return nil, err
+	}
+	if home != "" {
+		remapped, err := remapHome(tmpDir, manifest, home)
+		if err != nil {
+			return nil, err
+		}
+		if remapped {
+			fmt.Printf("Restoring files archived under %s into %s.\n", manifest.Home, home)
+		}
+	}
+	return &preparedBackup{Archive: localPath, TmpDir: tmpDir, Manifest: manifest}, nil
+}
+
+// locateBackup returns the local path of the backup backupFile and a func
+// downloading it again, nil when it must not be downloaded. An existing file
+// (absolute or relative path) is used where it is. Any other name is taken from
+// the store into backupsDir (see fetchArchive), the latest backup when
+// backupFile is empty.
+func locateBackup(store BackupStore, backupsDir, backupFile string, noDownload bool) (string, func() error, error) {
+	if backupFile != "" {
+		if info, err := os.Stat(backupFile); err == nil && info.Mode().IsRegular() {
+			return backupFile, nil, nil
+		}
+	}
 	if backupFile == "" {
-		latest, err := GetLatestDriveBackup()
+		var err error
+		if noDownload {
+			backupFile, err = latestLocalArchive(backupsDir)
+		} else if backupFile, err = store.Latest(DriveBackupDir); err != nil {
+			err = fmt.Errorf("could not find latest backup in %s: %w", store, err)
+		}
 		if err != nil {
-			return fmt.Errorf("could not find latest backup in Google Drive: %w", err)
+			return "", nil, err
 		}
-		backupFile = latest
 	}
+	return fetchArchive(store, backupsDir, filepath.Base(backupFile), noDownload)
+}
 
-	// Download backup into backupsDir (if not already there or to refresh).
-	localPath := filepath.Join(backupsDir, filepath.Base(backupFile))
-	if err := DownloadFromDrive("linux/backups/"+filepath.Base(backupFile), localPath); err != nil {
-		return fmt.Errorf("failed to download backup from Google Drive: %w", err)
+// fetchArchive downloads the archive called name from the store into
+// backupsDir, returning its path and the func downloading it again. With
+// noDownload the copy already in backupsDir is used instead.
+func fetchArchive(store BackupStore, backupsDir, name string, noDownload bool) (string, func() error, error) {
+	localPath := filepath.Join(backupsDir, name)
+	if noDownload {
+		if _, err := os.Stat(localPath); err != nil {
+			return "", nil, withKind(ErrNoBackupFound, fmt.Errorf("backup %s is not in %s and downloads are disabled: %w", name, backupsDir, err))
+		}
+		return localPath, nil, nil
+	}
+	// A missing backupsDir would make the download fail with os.ErrNotExist too.
+	if err := os.MkdirAll(backupsDir, 0o755); err != nil {
+		return "", nil, withKind(ErrDownloadFailed, err)
 	}
+	download := func() error {
+		return store.Download(driveBackupPath(name), localPath)
+	}
+	if err := download(); errors.Is(err, os.ErrNotExist) {
+		return "", nil, withKind(ErrNoBackupFound, fmt.Errorf("backup %s is not in %s: %w", name, store, err))
+	} else if err != nil {
+		return "", nil, withKind(ErrDownloadFailed, fmt.Errorf("failed to download backup %s from %s: %w", name, store, err))
+	}
+	return localPath, download, nil
+}
 
-	// Extract into tmpDir.
-	if err := extractTarXz(localPath, tmpDir); err != nil {
-		return fmt.Errorf("could not extract backup: %w", err)
+// latestLocalArchive returns the name of the most recently modified backup
+// archive in backupsDir.
+func latestLocalArchive(backupsDir string) (string, error) {
+	entries, err := os.ReadDir(backupsDir)
+	if err != nil {
+		return "", err
 	}
+	var latest string
+	var latestTime time.Time
+	for _, e := range entries {
+		if _, ok := archiveFormat(e.Name()); !ok || !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return "", err
+		}
+		if latest == "" || info.ModTime().After(latestTime) {
+			latest, latestTime = e.Name(), info.ModTime()
+		}
+	}
+	if latest == "" {
+		return "", withKind(ErrNoBackupFound, fmt.Errorf("no backup archive in %s", backupsDir))
+	}
+	return latest, nil
+}
 
-	// Build steps and apply them.
-	steps := buildBackupSteps(home)
-	runAll := len(selectedSteps) == 0
-	stepNameMap := make(map[string]struct{})
-	for _, step := range steps {
-		stepNameMap[strings.ToLower(step.Name)] = struct{}{}
+// remapTarget returns the home the archived home is remapped to, or "" when
+// opts disable remapping.
+func remapTarget(opts ApplyBackupOpts, home string) string {
+	if opts.KeepArchivedHome {
+		return ""
 	}
-	// Validate selected steps
-	if !runAll {
-		for _, sel := range selectedSteps {
-			if _, ok := stepNameMap[strings.ToLower(sel)]; !ok {
-				fmt.Fprintf(os.Stderr, "Warning: unknown backup step '%s' (will be ignored)\n", sel)
-			}
+	return home
+}
+
+// selectSteps returns the steps of all, in order, whose names appear in include
+// or, with an empty include, do not appear in exclude. Names match
+// case-insensitively; the ones matching no step are returned as unknown.
+func selectSteps(all []BackupStep, include, exclude []string) ([]BackupStep, []string) {
+	known := make(map[string]bool)
+	for _, step := range all {
+		known[strings.ToLower(step.Name)] = true
+	}
+	var unknown []string
+	named := make(map[string]bool)
+	for _, name := range append(append([]string(nil), include...), exclude...) {
+		if !known[strings.ToLower(name)] {
+			unknown = append(unknown, name)
 		}
+		named[strings.ToLower(name)] = true
 	}
-	for _, step := range steps {
-		shouldRun := runAll
-		if !runAll {
-			for _, sel := range selectedSteps {
-				if strings.EqualFold(sel, step.Name) {
-					shouldRun = true
-					break
-				}
-			}
+
+	// With include a step must be named; otherwise it must not be.
+	var selected []BackupStep
+	for _, step := range all {
+		if named[strings.ToLower(step.Name)] == (len(include) > 0) {
+			selected = append(selected, step)
 		}
-		if shouldRun {
-			fmt.Printf("Applying backup step: %s\n", step.Name)
-			// Special logic for "clone all" step
-			if strings.EqualFold(step.Name, "clone all") {
-				if err := runCloneAllStep(); err != nil {
-					return fmt.Errorf("could not run 'clone all' step: %w", err)
-				}
-				continue
-			}
-			if step.Filter != nil {
-				if err := applyFromTmpWithFilter(tmpDir, step.Filter); err != nil {
-					return fmt.Errorf("could not apply backup step '%s': %w", step.Name, err)
-				}
-			}
+	}
+	return selected, unknown
+}
+
+// steps returns the steps selected by opts.Steps or opts.ExcludeSteps, passed
+// through opts.ConfigureStep, warning about unknown step names.
+func (opts ApplyBackupOpts) steps(home string) ([]BackupStep, error) {
+	if len(opts.Steps) > 0 && len(opts.ExcludeSteps) > 0 {
+		return nil, errors.New("steps and excluded steps cannot both be given")
+	}
+	steps, unknown := selectSteps(buildBackupSteps(home), opts.Steps, opts.ExcludeSteps)
+	for _, name := range unknown {
+		fmt.Fprintf(os.Stderr, "Warning: unknown backup step '%s' (will be ignored)\n", name)
+	}
+	if opts.ConfigureStep != nil {
+		for i := range steps {
+			opts.ConfigureStep(&steps[i])
 		}
 	}
+	return steps, nil
+}
 
-	// Final cleanup.
-	_ = os.RemoveAll(tmpDir)
-	return nil
+// optsSetPaths resolves the paths of opts.BackupSet, or nil when no set is given.
+func optsSetPaths(opts ApplyBackupOpts, home string) ([]string, error) {
+	if opts.BackupSet == "" {
+		return nil, nil
+	}
+	sets, err := resolveBackupSets([]string{opts.BackupSet})
+	if err != nil {
+		return nil, err
+	}
+	return backupSetPaths(sets[0], home), nil
+}
+
+// stepFilter returns the filter of step, restricted to setPaths when non-nil.
+func stepFilter(step BackupStep, setPaths []string) func(rel string, info os.FileInfo) bool {
+	if setPaths == nil {
+		return step.Filter
+	}
+	return restrictToPaths(step.Filter, setPaths)
+}
+
+// backupSetPaths returns the archive-relative (slash separated) paths declared by
+// set, resolving "~" against home.
+func backupSetPaths(set BackupSet, home string) []string {
+	var declared []string
+	for _, f := range set.FilesAdd {
+		declared = append(declared, f.forOS().paths()...)
+	}
+	for _, folder := range set.Folders {
+		declared = append(declared, folder.forOS().declaredPaths()...)
+	}
+
+	paths := make([]string, 0, len(declared))
+	for _, p := range declared {
+		if strings.HasPrefix(p, "~") {
+			p = filepath.Join(home, p[1:])
+		} else if expanded, err := utils.ExpandHome(p); err == nil {
+			p = expanded
+		}
+		paths = append(paths, filepath.ToSlash(trimLeadingSlash(filepath.Clean(p))))
+	}
+	return paths
 }
 
-// runCloneAllStep runs the clone all step by invoking clone.CloneAll.
-func runCloneAllStep() error {
+// restrictToPaths wraps filter so that only the given paths (and everything below
+// them) are accepted. Directories above a path are accepted so the walk reaches it.
+// Paths may be glob patterns (see matchesDeclared).
+func restrictToPaths(filter func(rel string, info os.FileInfo) bool, paths []string) func(rel string, info os.FileInfo) bool {
+	return func(rel string, info os.FileInfo) bool {
+		relSlash := filepath.ToSlash(rel)
+		for _, p := range paths {
+			if matchesDeclared(p, relSlash, info.IsDir()) {
+				return filter(rel, info)
+			}
+		}
+		return false
+	}
+}
+
+// runCloneAllStep runs the clone all step by invoking clone.CloneAll and hands the
+// results to report (printing a table when nil).
+func runCloneAllStep(report func([]clone.CloneResult)) error {
 	fmt.Println("Cloning all repositories (clone all step)...")
-	if err := clone.CloneAll(); err != nil {
+	results, err := clone.CloneAll()
+	if report != nil {
+		report(results)
+	} else {
+		clone.PrintResults(os.Stdout, results)
+	}
+	if err != nil {
 		return err
 	}
 	fmt.Println("All repositories cloned successfully (clone all step).")
 	return nil
 }
 
-// extractTarXz extracts a .tar.xz archive to the destination directory.
-func extractTarXz(archivePath, destDir string) error {
-	cmd := exec.Command("tar", "-xJf", archivePath, "-C", destDir)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
-
-// applyFromTmpWithFilter walks tmpDir and restores files/folders to their original locations,
-// treating tmpDir as root (/) and applies the provided filter to decide whether to
-// restore each path for the current step.
-func applyFromTmpWithFilter(tmpDir string, filter func(rel string, info os.FileInfo) bool) error {
-	originalsDir := filepath.Join(tmpDir, "originals")
-
+// walkBackupTree walks the extracted backup in tmpDir, skipping the tool's internal
+// entries (tmp/, originals/ and the manifest) and everything filter rejects, and
+// calls fn for each accepted path with its archive-relative path.
+func walkBackupTree(tmpDir string, filter func(rel string, info os.FileInfo) bool, fn func(rel, path string, info os.FileInfo) error) error {
 	return filepath.Walk(tmpDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -239,6 +644,10 @@ func applyFromTmpWithFilter(tmpDir string, filter func(rel string, info os.FileI
 			}
 			return nil
 		}
+		// The manifest describes the archive and is never restored.
+		if rel == manifestFileName {
+			return nil
+		}
 
 		// Filter decides inclusion for this step.
 		if !filter(rel, info) {
@@ -247,21 +656,190 @@ func applyFromTmpWithFilter(tmpDir string, filter func(rel string, info os.FileI
 			}
 			return nil
 		}
+		return fn(rel, path, info)
+	})
+}
 
+// applyFromTmpWithFilter walks tmpDir and restores files/folders to their original locations,
+// treating tmpDir as root (/) and applies the provided filter to decide whether to
+// restore each path for the current step. It returns the target paths of the restored files.
+// Existing files are handled according to cfg.Overwrite, and the special attributes
+// recorded in cfg.Manifest are reapplied.
+//
+// Directories and symlinks are restored during the walk; regular files are then
+// copied by up to cfg.Concurrency workers. A file that fails does not stop the
+// others: all failures are returned joined.
+func applyFromTmpWithFilter(tmpDir string, filter func(rel string, info os.FileInfo) bool, cfg applyConfig) ([]string, error) {
+	originalsDir := cfg.OriginalsDir
+
+	var applied []string
+	var jobs []restoreJob
+	err := walkBackupTree(tmpDir, filter, func(rel, path string, info os.FileInfo) error {
 		target := filepath.Join(string(os.PathSeparator), rel)
 
 		if info.IsDir() {
+			if cfg.DryRun {
+				return nil
+			}
 			return os.MkdirAll(target, info.Mode())
 		}
-
-		// Backup existing file before overwrite.
-		if _, err := os.Stat(target); err == nil {
-			backupPath := filepath.Join(originalsDir, rel)
-			if err := os.MkdirAll(filepath.Dir(backupPath), 0o755); err == nil {
-				_ = utils.CopyFile(target, backupPath, info.Mode())
+		if info.Mode()&os.ModeSymlink != 0 {
+			restored, err := cfg.restoreSymlink(path, target, originalsDir)
+			if restored {
+				applied = append(applied, target)
 			}
+			return err
 		}
 
-		return utils.CopyFile(path, target, info.Mode())
+		if cfg.keepsExisting(rel, target) {
+			if cfg.DryRun {
+				fmt.Printf("Would keep %s (exists; its entry is not updated)\n", target)
+			}
+			return nil
+		}
+		job := restoreJob{rel: rel, path: path, target: target, mode: info.Mode()}
+		if cfg.DryRun || cfg.Overwrite == utils.OverwritePrompt {
+			// Decide now: dry runs print in walk order and prompts must not overlap.
+			restore, err := cfg.shouldRestore(rel, path, target)
+			if err != nil {
+				return err
+			}
+			if cfg.DryRun {
+				return reportDryRun(path, target, restore)
+			}
+			if !restore {
+				return nil
+			}
+			job.decided = true
+		}
+		jobs = append(jobs, job)
+		return nil
 	})
+	if err != nil {
+		return applied, err
+	}
+
+	restored, err := cfg.runRestoreJobs(jobs)
+	return append(applied, restored...), err
+}
+
+// restoreJob is a regular file of the backup to restore over target.
+type restoreJob struct {
+	rel, path, target string
+	mode              os.FileMode
+	// decided is set when shouldRestore already accepted the file.
+	decided bool
+}
+
+// runRestoreJobs restores the files of jobs on at most cfg.Concurrency workers,
+// attempting every job. It returns the targets restored, in the order of jobs,
+// and the failures joined.
+func (cfg applyConfig) runRestoreJobs(jobs []restoreJob) ([]string, error) {
+	// The workers look files up in the manifest concurrently; with its index
+	// built here they only read it.
+	cfg.Manifest.buildIndex()
+	restored := make([]bool, len(jobs))
+	errs := make([]error, len(jobs))
+	var wg sync.WaitGroup
+	queue := make(chan int)
+	for i := 0; i < min(cfg.Concurrency, len(jobs)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				restored[j], errs[j] = cfg.restoreFile(jobs[j])
+			}
+		}()
+	}
+	for j := range jobs {
+		queue <- j
+	}
+	close(queue)
+	wg.Wait()
+
+	var targets []string
+	var failed []error
+	for j, job := range jobs {
+		if errs[j] != nil {
+			failed = append(failed, fmt.Errorf("restoring %s: %w", job.target, errs[j]))
+		} else if restored[j] {
+			targets = append(targets, job.target)
+		}
+	}
+	return targets, errors.Join(failed...)
+}
+
+// restoreFile copies job.path over job.target unless shouldRestore declines,
+// keeping a copy of an existing target under cfg.OriginalsDir first, and
+// reapplies the attributes recorded in the manifest. It reports whether the
+// file was restored.
+func (cfg applyConfig) restoreFile(job restoreJob) (bool, error) {
+	if !job.decided {
+		restore, err := cfg.shouldRestore(job.rel, job.path, job.target)
+		if err != nil || !restore {
+			return false, err
+		}
+	}
+
+	// Backup existing file before overwrite; without that copy there is nothing
+	// to roll back to, so the file is left alone.
+	if info, err := os.Stat(job.target); err == nil {
+		backupPath := filepath.Join(cfg.OriginalsDir, job.rel)
+		if err := utils.CopyFile(job.target, backupPath, info.Mode().Perm()); err != nil {
+			return false, fmt.Errorf("could not keep a copy of %s before overwriting it: %w", job.target, err)
+		}
+	}
+
+	if err := utils.CopyFile(job.path, job.target, job.mode); err != nil {
+		return false, err
+	}
+	if f, ok := cfg.Manifest.find(filepath.ToSlash(job.rel)); ok {
+		restoreSpecialAttributes(job.target, f)
+		removeConsumed(job.target, cfg.OriginalsDir, f)
+	}
+	return true, nil
+}
+
+// reportDryRun prints what restoring the extracted file path to target would do;
+// restore is the decision of the overwrite policy.
+func reportDryRun(path, target string, restore bool) error {
+	if _, err := os.Stat(target); os.IsNotExist(err) {
+		fmt.Printf("Would create %s\n", target)
+		return nil
+	}
+	same, err := utils.FilesAreEqual(path, target)
+	if err != nil {
+		return err
+	}
+	switch {
+	case same:
+		fmt.Printf("Unchanged %s\n", target)
+	case restore:
+		fmt.Printf("Would overwrite %s (differs; original kept for rollback)\n", target)
+	default:
+		fmt.Printf("Would keep %s (differs; kept by overwrite policy)\n", target)
+	}
+	return nil
+}
+
+// shouldRestore applies the overwrite policy to the archived file rel, extracted
+// at path, whose restore location is target.
+func (cfg applyConfig) shouldRestore(rel, path, target string) (bool, error) {
+	opts := utils.CopyOptions{Overwrite: cfg.Overwrite, Confirm: cfg.Confirm}
+	if f, ok := cfg.Manifest.find(filepath.ToSlash(rel)); ok {
+		opts.SrcModTime = f.ModTime
+	}
+	return utils.ShouldOverwrite(path, target, opts)
+}
+
+// keepsExisting reports whether the archived file rel belongs to a FileAdd entry
+// with Update false (see ManifestFile.NoUpdate) and target already exists, so it
+// must be left alone whatever the overwrite policy.
+func (cfg applyConfig) keepsExisting(rel, target string) bool {
+	f, ok := cfg.Manifest.find(filepath.ToSlash(rel))
+	if !ok || !f.NoUpdate {
+		return false
+	}
+	_, err := os.Lstat(target)
+	return err == nil
 }
@@ -0,0 +1,171 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"setup/shared/utils"
+)
+
+func stepNames(steps []BackupStep) []string {
+	var names []string
+	for _, step := range steps {
+		names = append(names, step.Name)
+	}
+	return names
+}
+
+func TestSelectSteps(t *testing.T) {
+	all := []BackupStep{{Name: "before clone"}, {Name: "clone all"}, {Name: "after clone"}}
+	for _, tt := range []struct {
+		name             string
+		include, exclude []string
+		want, unknown    []string
+	}{
+		{name: "all", want: []string{"before clone", "clone all", "after clone"}},
+		{name: "include", include: []string{"after clone", "Before Clone"}, want: []string{"before clone", "after clone"}},
+		{name: "exclude", exclude: []string{"CLONE ALL"}, want: []string{"before clone", "after clone"}},
+		{name: "unknown include", include: []string{"clone all", "nope"}, want: []string{"clone all"}, unknown: []string{"nope"}},
+		{name: "unknown exclude", exclude: []string{"nope"}, want: []string{"before clone", "clone all", "after clone"}, unknown: []string{"nope"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			steps, unknown := selectSteps(all, tt.include, tt.exclude)
+			if got := stepNames(steps); !slices.Equal(got, tt.want) {
+				t.Errorf("steps = %v, want %v", got, tt.want)
+			}
+			if !slices.Equal(unknown, tt.unknown) {
+				t.Errorf("unknown = %v, want %v", unknown, tt.unknown)
+			}
+		})
+	}
+}
+
+func TestStepsRejectsIncludeAndExclude(t *testing.T) {
+	opts := ApplyBackupOpts{Steps: []string{"before clone"}, ExcludeSteps: []string{"clone all"}}
+	if _, err := opts.steps(t.TempDir()); err == nil {
+		t.Error("steps accepted both Steps and ExcludeSteps")
+	}
+}
+
+// fakeStore is a BackupStore serving the archives in files, keyed by remote
+// path, and recording the downloads.
+type fakeStore struct {
+	files     map[string]string
+	downloads []string
+}
+
+func (s *fakeStore) Upload(localPath, remotePath string) error {
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+	s.files[remotePath] = string(data)
+	return nil
+}
+
+func (s *fakeStore) Download(remotePath, localPath string) error {
+	s.downloads = append(s.downloads, remotePath)
+	data, ok := s.files[remotePath]
+	if !ok {
+		return fmt.Errorf("%s: %w", remotePath, os.ErrNotExist)
+	}
+	return os.WriteFile(localPath, []byte(data), 0o644)
+}
+
+func (s *fakeStore) Latest(prefix string) (string, error) {
+	return "", errors.New("not implemented")
+}
+
+func (s *fakeStore) String() string { return "fake store" }
+
+func TestLocateBackup(t *testing.T) {
+	backupsDir := t.TempDir()
+	local := filepath.Join(t.TempDir(), "backup-20240102-150405.tar.xz")
+	writeTestFile(t, local, "local\n")
+	const remoteName = "backup-20240203-150405.tar.xz"
+
+	t.Run("local path", func(t *testing.T) {
+		store := &fakeStore{files: map[string]string{}}
+		path, download, err := locateBackup(store, backupsDir, local, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path != local || download != nil || len(store.downloads) > 0 {
+			t.Errorf("locateBackup = %s, downloaded %v; want %s used in place", path, store.downloads, local)
+		}
+	})
+
+	t.Run("store name", func(t *testing.T) {
+		store := &fakeStore{files: map[string]string{driveBackupPath(remoteName): "remote\n"}}
+		path, download, err := locateBackup(store, backupsDir, remoteName, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := filepath.Join(backupsDir, remoteName); path != want || download == nil {
+			t.Errorf("locateBackup = %s, want %s downloaded", path, want)
+		}
+		if data, _ := os.ReadFile(path); string(data) != "remote\n" {
+			t.Errorf("downloaded %q", data)
+		}
+	})
+
+	t.Run("no download", func(t *testing.T) {
+		store := &fakeStore{files: map[string]string{driveBackupPath("other.tar.xz"): "remote\n"}}
+		if _, _, err := locateBackup(store, backupsDir, "other.tar.xz", true); !errors.Is(err, ErrNoBackupFound) {
+			t.Errorf("err = %v, want ErrNoBackupFound", err)
+		}
+		if len(store.downloads) > 0 {
+			t.Errorf("downloaded %v with downloads disabled", store.downloads)
+		}
+	})
+}
+
+// TestRunRestoreJobsConcurrently restores files on several workers with a
+// manifest whose index is not built yet; run it with -race. The jobs are
+// decided, so the workers first look the manifest up after copying, when the
+// others are busy too.
+func TestRunRestoreJobsConcurrently(t *testing.T) {
+	dir := t.TempDir()
+	m := &Manifest{}
+	var jobs []restoreJob
+	for i := range 32 {
+		rel := fmt.Sprintf("home/u/file%02d", i)
+		path := filepath.Join(dir, "tmp", rel)
+		target := filepath.Join(dir, "root", rel)
+		writeTestFile(t, path, fmt.Sprintf("new %d\n", i))
+		if i%2 == 0 {
+			writeTestFile(t, target, "old\n")
+		}
+		m.Files = append(m.Files, ManifestFile{Path: rel})
+		jobs = append(jobs, restoreJob{rel: rel, path: path, target: target, mode: 0o644, decided: true})
+	}
+	// A job that fails must not hide the others.
+	jobs = append(jobs, restoreJob{rel: "home/u/missing", path: filepath.Join(dir, "tmp", "missing"), target: filepath.Join(dir, "root", "missing"), mode: 0o644, decided: true})
+
+	cfg := applyConfig{Manifest: m, Overwrite: utils.OverwriteAlways, OriginalsDir: filepath.Join(dir, "originals"), Concurrency: 8}
+	restored, err := cfg.runRestoreJobs(jobs)
+	if err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("err = %v, want the failure of the missing file", err)
+	}
+	if len(restored) != len(jobs)-1 {
+		t.Fatalf("restored %d files, want %d", len(restored), len(jobs)-1)
+	}
+	for i, job := range jobs[:len(jobs)-1] {
+		if restored[i] != job.target {
+			t.Errorf("restored[%d] = %s, want %s", i, restored[i], job.target)
+		}
+		if data, _ := os.ReadFile(job.target); string(data) != fmt.Sprintf("new %d\n", i) {
+			t.Errorf("%s = %q", job.target, data)
+		}
+		if i%2 == 0 {
+			if data, _ := os.ReadFile(filepath.Join(cfg.OriginalsDir, job.rel)); string(data) != "old\n" {
+				t.Errorf("original of %s = %q", job.target, data)
+			}
+		}
+	}
+}
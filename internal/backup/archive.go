@@ -0,0 +1,236 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"setup/shared/utils"
+
+	"filippo.io/age"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression formats of backup archives, selected with CreateBackupOpts.Format.
+const (
+	FormatXz   = "xz"
+	FormatZstd = "zstd"
+)
+
+// archiveExts maps each format to the extension of its archives.
+var archiveExts = map[string]string{
+	FormatXz:   ".tar.xz",
+	FormatZstd: ".tar.zst",
+}
+
+// archiveNameQuery is the Drive query term matching backup archives of any
+// format, encrypted ones included.
+const archiveNameQuery = "(name contains '.tar.xz' or name contains '.tar.zst')"
+
+// archiveExt returns the archive extension of format ("" meaning xz).
+func archiveExt(format string) (string, error) {
+	if format == "" {
+		format = FormatXz
+	}
+	ext, ok := archiveExts[format]
+	if !ok {
+		return "", fmt.Errorf("unknown archive format %q (want %s or %s)", format, FormatXz, FormatZstd)
+	}
+	return ext, nil
+}
+
+// archiveFormat returns the format of the archive called name, judging by its
+// extension (after any encryptedExt), and false when name is not a backup archive.
+func archiveFormat(name string) (string, bool) {
+	name = strings.TrimSuffix(name, encryptedExt)
+	for format, ext := range archiveExts {
+		if strings.HasSuffix(name, ext) {
+			return format, true
+		}
+	}
+	return "", false
+}
+
+// ValidateLevel checks level against the compression levels of format: 1-9 for
+// xz and 1-22 for zstd. 0 selects the default level.
+func ValidateLevel(format string, level int) error {
+	maxLevel := 9
+	if format == FormatZstd {
+		maxLevel = 22
+	}
+	if level < 0 || level > maxLevel {
+		return fmt.Errorf("compression level %d out of range for %s (1-%d)", level, format, maxLevel)
+	}
+	return nil
+}
+
+// xzDictCaps are the dictionary sizes of the xz presets 1-9; the dictionary
+// size is what the presets mostly trade speed and memory for.
+var xzDictCaps = [...]int{1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+// newCompressor returns a writer compressing into w with format at level (0
+// meaning the default level).
+func newCompressor(w io.Writer, format string, level int) (io.WriteCloser, error) {
+	if format == FormatZstd {
+		var opts []zstd.EOption
+		if level > 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(w, opts...)
+	}
+	if level > 0 {
+		return xz.WriterConfig{DictCap: xzDictCaps[level-1]}.NewWriter(w)
+	}
+	return xz.NewWriter(w)
+}
+
+// newDecompressor returns a reader decompressing r according to the extension of
+// the archive called name (xz unless it is a .tar.zst). The returned func
+// releases its resources.
+func newDecompressor(r io.Reader, name string) (io.Reader, func(), error) {
+	if format, _ := archiveFormat(name); format == FormatZstd {
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	}
+	xr, err := xz.NewReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return xr, func() {}, nil
+}
+
+// systemTarArgs returns the tar flag compressing with format and the environment
+// passing level to the compressor.
+func systemTarArgs(format string, level int) (flag string, env []string) {
+	if format == FormatZstd {
+		if level > 0 {
+			env = append(env, fmt.Sprintf("ZSTD_CLEVEL=%d", level))
+		}
+		return "--zstd", env
+	}
+	if level > 0 {
+		env = append(env, fmt.Sprintf("XZ_OPT=-%d", level))
+	}
+	return "-J", env
+}
+
+// fileID identifies a file by device and inode.
+type fileID struct{ dev, ino uint64 }
+
+// createArchive archives the contents of srcDir (not srcDir itself) at
+// archivePath, compressed with format at level, like "tar -C srcDir -cJf
+// archivePath .": entry names start with "./", and modes, mtimes, symlinks and
+// hard links are preserved. With recipients the compressed stream is encrypted
+// to them with age. A failed run removes the partial archive.
+func createArchive(srcDir, archivePath, format string, level int, recipients []age.Recipient) (err error) {
+	out, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(archivePath)
+		}
+	}()
+
+	bw := bufio.NewWriter(out)
+	var w io.Writer = bw
+	var ew io.WriteCloser
+	if len(recipients) > 0 {
+		if ew, err = age.Encrypt(bw, recipients...); err != nil {
+			return err
+		}
+		w = ew
+	}
+	cw, err := newCompressor(w, format, level)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(cw)
+
+	deadline := time.Now().Add(TarTimeout)
+	// Inodes already archived, so further links to them become hard links.
+	seen := map[fileID]string{}
+	err = filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if TarTimeout > 0 && time.Now().After(deadline) {
+			return fmt.Errorf("archiving %s: %w after %s", srcDir, utils.ErrCommandTimeout, TarTimeout)
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		name := "./"
+		if rel != "." {
+			name += filepath.ToSlash(rel)
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if info.IsDir() && rel != "." {
+			hdr.Name += "/"
+		}
+
+		if key, ok := hardLinkID(info); ok && info.Mode().IsRegular() {
+			if first, ok := seen[key]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = first
+				hdr.Size = 0
+			} else {
+				seen[key] = name
+			}
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not archive %s: %w", srcDir, err)
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+	if ew != nil {
+		if err := ew.Close(); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
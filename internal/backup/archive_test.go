@@ -0,0 +1,68 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCreateArchiveRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	writeTestFile(t, filepath.Join(src, "dir", "secret"), "secret\n")
+	if err := os.Chmod(filepath.Join(src, "dir", "secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2021, 6, 7, 8, 9, 10, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(src, "dir", "secret"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("dir/secret", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(src, "dir", "secret"), filepath.Join(src, "hard")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{FormatXz, FormatZstd} {
+		t.Run(format, func(t *testing.T) {
+			ext, err := archiveExt(format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			archive := filepath.Join(t.TempDir(), "backup"+ext)
+			if err := createArchive(src, archive, format, 0, nil); err != nil {
+				t.Fatal(err)
+			}
+			dest := t.TempDir()
+			if err := extractArchive(archive, dest); err != nil {
+				t.Fatal(err)
+			}
+
+			secret := filepath.Join(dest, "dir", "secret")
+			info, err := os.Stat(secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, _ := os.ReadFile(secret); string(data) != "secret\n" {
+				t.Errorf("contents = %q", data)
+			}
+			if info.Mode().Perm() != 0o600 {
+				t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+			}
+			if !info.ModTime().Equal(mtime) {
+				t.Errorf("mtime = %v, want %v", info.ModTime(), mtime)
+			}
+			if link, err := os.Readlink(filepath.Join(dest, "link")); err != nil || link != "dir/secret" {
+				t.Errorf("link = %q, %v; want dir/secret", link, err)
+			}
+			hard, err := os.Stat(filepath.Join(dest, "hard"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !os.SameFile(info, hard) {
+				t.Errorf("hard link extracted as a separate file")
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package backup
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+)
+
+// specialModeBits are the mode bits that plain file copies do not preserve.
+const specialModeBits = os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// FileOwner is the numeric owner and group of an archived file.
+type FileOwner struct {
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+}
+
+// captureSourceAttributes records the mode (including special bits), modification
+// time, owner and file capabilities of the live file at source into f.
+func captureSourceAttributes(source string, f *ManifestFile) {
+	info, err := os.Lstat(source)
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+	f.Mode = info.Mode()
+	f.ModTime = info.ModTime()
+	if owner, ok := fileOwner(info); ok {
+		f.Owner = &owner
+	}
+	if caps, err := getFileCapability(source); err == nil && len(caps) > 0 {
+		f.Capability = base64.StdEncoding.EncodeToString(caps)
+	}
+}
+
+// restoreSpecialAttributes reapplies the owner, special mode bits and file
+// capabilities recorded in f to target. Failures are reported as warnings since
+// they usually mean the tool is running unprivileged.
+func restoreSpecialAttributes(target string, f ManifestFile) {
+	// Before the mode: changing the owner clears the setuid and setgid bits.
+	if f.Owner != nil {
+		restoreOwner(target, *f.Owner)
+	}
+	if f.Mode&specialModeBits != 0 {
+		if err := os.Chmod(target, f.Mode); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not restore special mode bits on %s: %v\n", target, err)
+		}
+	}
+	if f.Capability == "" {
+		return
+	}
+	caps, err := base64.StdEncoding.DecodeString(f.Capability)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: invalid capabilities recorded for %s: %v\n", target, err)
+		return
+	}
+	if os.Geteuid() != 0 {
+		fmt.Fprintf(os.Stderr, "Warning: skipping file capabilities on %s (requires root)\n", target)
+		return
+	}
+	if err := setFileCapability(target, caps); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not restore file capabilities on %s: %v\n", target, err)
+	}
+}
+
+// restoreOwner gives target the owner recorded for it, when it has another one.
+func restoreOwner(target string, owner FileOwner) {
+	info, err := os.Lstat(target)
+	if err != nil {
+		return
+	}
+	if current, ok := fileOwner(info); !ok || current == owner {
+		return
+	}
+	if err := os.Lchown(target, owner.UID, owner.GID); err != nil {
+		if os.IsPermission(err) {
+			fmt.Fprintf(os.Stderr, "Warning: could not restore owner %d:%d of %s (requires root)\n", owner.UID, owner.GID, target)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Warning: could not restore owner of %s: %v\n", target, err)
+	}
+}
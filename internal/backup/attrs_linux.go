@@ -0,0 +1,55 @@
+//go:build linux
+
+package backup
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// capabilityXattr is the extended attribute Linux stores file capabilities in.
+const capabilityXattr = "security.capability"
+
+// getFileCapability returns the raw file capabilities of path, or nil if it has none.
+func getFileCapability(path string) ([]byte, error) {
+	size, err := unix.Lgetxattr(path, capabilityXattr, nil)
+	if errors.Is(err, unix.ENODATA) || errors.Is(err, unix.ENOTSUP) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	n, err := unix.Lgetxattr(path, capabilityXattr, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// setFileCapability sets the raw file capabilities of path. It requires privileges.
+func setFileCapability(path string, data []byte) error {
+	return unix.Lsetxattr(path, capabilityXattr, data, 0)
+}
+
+// hardLinkID returns the identity of the file behind info when it has several
+// hard links.
+func hardLinkID(info os.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: st.Ino}, true
+}
+
+// fileOwner returns the numeric owner and group of the file behind info.
+func fileOwner(info os.FileInfo) (FileOwner, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return FileOwner{}, false
+	}
+	return FileOwner{UID: int(st.Uid), GID: int(st.Gid)}, true
+}
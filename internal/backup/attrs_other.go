@@ -0,0 +1,30 @@
+//go:build !linux
+
+package backup
+
+import (
+	"errors"
+	"os"
+)
+
+// getFileCapability reports no capabilities: they are a Linux-only feature.
+func getFileCapability(path string) ([]byte, error) {
+	return nil, nil
+}
+
+// setFileCapability is unsupported outside Linux.
+func setFileCapability(path string, data []byte) error {
+	return errors.New("file capabilities are not supported on this platform")
+}
+
+// hardLinkID reports no hard links outside Linux; linked files are archived as
+// separate copies.
+func hardLinkID(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
+
+// fileOwner reports no owner outside Linux, so ownership is neither recorded
+// nor restored there.
+func fileOwner(info os.FileInfo) (FileOwner, bool) {
+	return FileOwner{}, false
+}
@@ -0,0 +1,117 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// isGlob reports whether the Contents entry content is a glob pattern.
+func isGlob(content string) bool {
+	return strings.ContainsAny(content, "*?[{")
+}
+
+// declaredPaths returns the paths f declares: its Contents (patterns included)
+// joined to Path, or Path itself when Contents is empty.
+func (f Folder) declaredPaths() []string {
+	if len(f.Contents) == 0 {
+		return []string{f.Path}
+	}
+	paths := make([]string, 0, len(f.Contents))
+	for _, content := range f.Contents {
+		paths = append(paths, filepath.Join(f.Path, content))
+	}
+	return paths
+}
+
+// resolveContents returns the entries of f to copy, relative to Path: literal
+// Contents as they are, glob patterns (doublestar syntax, so "**" crosses
+// directories) replaced by the files and directories they match, and "." (the
+// whole folder) when Contents is empty.
+func (f Folder) resolveContents() ([]string, error) {
+	if len(f.Contents) == 0 {
+		return []string{"."}, nil
+	}
+	root, err := expandHome(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	var contents []string
+	seen := map[string]bool{}
+	add := func(content string) {
+		if !seen[content] {
+			seen[content] = true
+			contents = append(contents, content)
+		}
+	}
+	for _, content := range f.Contents {
+		if !isGlob(content) {
+			add(content)
+			continue
+		}
+		matches, err := doublestar.Glob(os.DirFS(root), filepath.ToSlash(content))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q in %s: %w", content, f.Path, err)
+		}
+		for _, m := range matches {
+			if !f.excludes(m) {
+				add(filepath.FromSlash(m))
+			}
+		}
+	}
+	return contents, nil
+}
+
+// excludes reports whether rel, a slash separated path relative to Path,
+// matches one of Excludes.
+func (f Folder) excludes(rel string) bool {
+	for _, pattern := range f.Excludes {
+		if ok, _ := doublestar.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// excludeFunc returns a func reporting whether a path found while copying f
+// matches one of its Excludes, or nil when f (which may be nil) has none.
+func (f *Folder) excludeFunc() func(path string) bool {
+	if f == nil || len(f.Excludes) == 0 {
+		return nil
+	}
+	root, err := expandHome(f.Path)
+	if err != nil {
+		return nil
+	}
+	return func(path string) bool {
+		rel, err := filepath.Rel(root, path)
+		return err == nil && f.excludes(filepath.ToSlash(rel))
+	}
+}
+
+// matchesDeclared reports whether the archive-relative path rel (slash
+// separated) is accepted by the declared path p: rel is p or below it, or, for
+// a directory, above it so a walk can reach it. Glob patterns match rel or one
+// of its parents.
+func matchesDeclared(p, rel string, isDir bool) bool {
+	if !isGlob(p) {
+		return rel == p || strings.HasPrefix(rel, p+"/") || (isDir && strings.HasPrefix(p, rel+"/"))
+	}
+	base, _ := doublestar.SplitPattern(p)
+	if rel == base || strings.HasPrefix(base, rel+"/") {
+		return isDir
+	}
+	if !strings.HasPrefix(rel, base+"/") {
+		return false
+	}
+	for path := rel; path != base; path = filepath.ToSlash(filepath.Dir(path)) {
+		if ok, _ := doublestar.Match(p, path); ok {
+			return true
+		}
+	}
+	// Directories below the pattern's base may hold matches further down.
+	return isDir
+}
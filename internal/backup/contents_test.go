@@ -0,0 +1,83 @@
+package backup
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestResolveContents(t *testing.T) {
+	root := t.TempDir()
+	for _, p := range []string{"a.json", "b.json", "notes.txt", "sub/c.json", "sub/deep/d.json"} {
+		writeTestFile(t, filepath.Join(root, p), "x\n")
+	}
+
+	for _, tt := range []struct {
+		name     string
+		contents []string
+		want     []string
+	}{
+		{"whole folder", nil, []string{"."}},
+		{"literal", []string{"notes.txt", "missing"}, []string{"notes.txt", "missing"}},
+		{"glob", []string{"*.json"}, []string{"a.json", "b.json"}},
+		{"double star", []string{"**/*.json"}, []string{"a.json", "b.json", "sub/c.json", "sub/deep/d.json"}},
+		{"duplicates", []string{"a.json", "*.json"}, []string{"a.json", "b.json"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Folder{Path: root, Contents: tt.contents}.resolveContents()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := make([]string, len(tt.want))
+			for i, w := range tt.want {
+				want[i] = filepath.FromSlash(w)
+			}
+			slices.Sort(got)
+			slices.Sort(want)
+			if !slices.Equal(got, want) {
+				t.Errorf("resolveContents = %v, want %v", got, want)
+			}
+		})
+	}
+
+	if _, err := (Folder{Path: root, Contents: []string{"[a"}}).resolveContents(); err == nil {
+		t.Error("invalid pattern accepted")
+	}
+}
+
+func TestMatchesDeclared(t *testing.T) {
+	for _, tt := range []struct {
+		p, rel string
+		isDir  bool
+		want   bool
+	}{
+		{"home/u/.config/zed", "home/u/.config/zed", true, true},
+		{"home/u/.config/zed", "home/u/.config/zed/settings.json", false, true},
+		{"home/u/.config/zed", "home/u/.config", true, true},
+		{"home/u/.config/zed", "home/u/.config/zedx", false, false},
+		{"home/u/.config/zed/*.json", "home/u/.config/zed/keymap.json", false, true},
+		{"home/u/.config/zed/*.json", "home/u/.config/zed/notes.txt", false, false},
+		{"home/u/.config/zed/*.json", "home/u/.config", true, true},
+		{"home/u/.config/zed/*.json", "home/u/.config/other.json", false, false},
+		{"home/u/.config/zed/**/*.json", "home/u/.config/zed/themes/dark.json", false, true},
+		{"home/u/.config/zed/themes*", "home/u/.config/zed/themes/dark.json", false, true},
+	} {
+		if got := matchesDeclared(tt.p, tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("matchesDeclared(%q, %q, %v) = %v, want %v", tt.p, tt.rel, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestResolveContentsExcludes(t *testing.T) {
+	root := t.TempDir()
+	for _, p := range []string{"a.json", "cache.json", "sub/c.json"} {
+		writeTestFile(t, filepath.Join(root, p), "x\n")
+	}
+	got, err := Folder{Path: root, Contents: []string{"**/*.json"}, Excludes: []string{"cache.json", "sub/**"}}.resolveContents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, []string{"a.json"}) {
+		t.Errorf("resolveContents = %v, want [a.json]", got)
+	}
+}
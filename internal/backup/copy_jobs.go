@@ -0,0 +1,79 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// CopyConcurrency bounds how many source paths are copied in parallel when
+// staging a backup.
+var CopyConcurrency = 4
+
+// CopySummary reports the outcome of copying the sources of a backup.
+type CopySummary struct {
+	Copied int
+	Failed []CopyFailure
+
+	// preProcessed maps the staged files produced by PreProcess commands to
+	// the PreProcess that made them.
+	preProcessed map[string]PreProcess
+}
+
+// CopyFailure records a source path that could not be copied.
+type CopyFailure struct {
+	Path string
+	Err  error
+}
+
+// copyJob copies one declared source path.
+type copyJob struct {
+	path string
+	copy func() error
+}
+
+// runCopyJobs runs jobs on at most CopyConcurrency workers, attempting every job.
+// It returns a non-nil error joining all failures when any job failed.
+func runCopyJobs(jobs []copyJob) (*CopySummary, error) {
+	workers := CopyConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		summary CopySummary
+	)
+	queue := make(chan copyJob)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				err := job.copy()
+				mu.Lock()
+				if err != nil {
+					summary.Failed = append(summary.Failed, CopyFailure{Path: job.path, Err: err})
+				} else {
+					summary.Copied++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+
+	if len(summary.Failed) == 0 {
+		return &summary, nil
+	}
+	errs := make([]error, 0, len(summary.Failed))
+	for _, f := range summary.Failed {
+		errs = append(errs, fmt.Errorf("copying %s: %w", f.Path, f.Err))
+	}
+	return &summary, fmt.Errorf("%d of %d path(s) could not be copied: %w", len(summary.Failed), len(jobs), errors.Join(errs...))
+}
@@ -0,0 +1,32 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyAllToFilesZedUnderHome(t *testing.T) {
+	home := setTestHome(t)
+	zed := filepath.Join(home, ".config", "zed")
+	writeTestFile(t, filepath.Join(zed, "settings.json"), "{}\n")
+	writeTestFile(t, filepath.Join(zed, "keymap.json"), "[]\n")
+
+	folders, filesAdd := Folders, FilesAdd
+	t.Cleanup(func() { Folders, FilesAdd = folders, filesAdd })
+	Folders = []Folder{{Path: "~/.config/zed", Contents: []string{"settings.json", "keymap.json"}}}
+	FilesAdd = nil
+
+	if _, err := CopyAllToFiles(); err != nil {
+		t.Fatal(err)
+	}
+	filesDir, err := assetsFilesDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"settings.json", "keymap.json"} {
+		if _, err := os.Stat(filepath.Join(filesDir, trimLeadingSlash(zed), name)); err != nil {
+			t.Errorf("%s not copied below assets/files%s: %v", name, home, err)
+		}
+	}
+}
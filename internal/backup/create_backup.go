@@ -1,17 +1,105 @@
 package backup
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"setup/shared/utils"
+
+	"filippo.io/age"
 )
 
-// CreateBackup copies files/folders to assets/tmp, then archives as .tar.xz in assets with the naming convention,
+// CreateBackupOpts controls optional behavior of CreateBackupWithOpts.
+type CreateBackupOpts struct {
+	// SinceLast makes the backup incremental against the most recent backup:
+	// only files whose checksum changed are archived and the parent is recorded
+	// in the manifest. Without a previous backup a full backup is created.
+	SinceLast bool
+	// Base makes the backup incremental against the backup Base describes
+	// instead of the most recent one. Base.Archive must name its archive.
+	Base *Manifest
+	// Sets names the backup sets to back up for this call only. When empty the
+	// globally active sets (see UseBackupSets) are used.
+	Sets []string
+	// ExcludeSets names backup sets whose paths are subtracted from the sets
+	// being backed up.
+	ExcludeSets []string
+	// LocalOnly keeps the archive in the local backups dir without uploading it
+	// to the backup store.
+	LocalOnly bool
+	// DryRunUpload builds the archive but only reports where it would be uploaded
+	// (creating no Drive folders and transferring nothing).
+	DryRunUpload bool
+	// KeepEmptyDirs reproduces empty directories found under the declared folders
+	// in the archive, so they are recreated on apply.
+	KeepEmptyDirs bool
+	// RedactManifest stores only hashed path identifiers in the manifest sidecar
+	// uploaded next to the archive. The archive itself and the local sidecar keep
+	// the full manifest, which apply needs to remap the home and remove files.
+	RedactManifest bool
+	// VerifyUpload checks the uploaded archive against the local one using the
+	// checksum Drive computed, failing the backup on mismatch.
+	VerifyUpload bool
+	// VerifyUploadFull additionally downloads the whole archive again and
+	// compares it byte for byte. It implies VerifyUpload.
+	VerifyUploadFull bool
+	// OnlyNew skips the backup with ErrNoChanges when the staged files are
+	// identical to those of the most recent backup in the local index.
+	OnlyNew bool
+	// TargetHome backs up another account: "~" in the backup sets resolves to
+	// this directory instead of the home of the user running setup.
+	TargetHome string
+	// SkipJunk leaves files matching JunkPatterns (.DS_Store, swap files, ...)
+	// found inside backed-up directories out of the archive.
+	SkipJunk bool
+	// IgnoreMissing lets the backup go ahead when some declared files or folders
+	// do not exist, reporting them as warnings. Without it any path that could
+	// not be staged fails the backup; other errors always do.
+	IgnoreMissing bool
+	// ExcludeCredentials leaves the env file holding the Google credentials out of
+	// the archive, so the backup never contains the tokens needed to download it.
+	ExcludeCredentials bool
+	// Resume keeps the staging directory of an interrupted run and only copies
+	// the files that are not already staged with identical contents.
+	Resume bool
+	// Tags label the backup (see ValidateTag). They are recorded in the manifest,
+	// the local index and the appProperties of the uploaded archive.
+	Tags []string
+	// Progress, when set, is called during the upload of the archive to Google
+	// Drive with the bytes sent so far and the archive size.
+	Progress func(done, total int64)
+	// UseSystemTar creates the archive with the system tar and xz instead of the
+	// built-in archiver. Unlike the built-in one, it stores sparse files compactly.
+	UseSystemTar bool
+	// Name replaces the base name of the archive ("home-<user>-backup-<timestamp>";
+	// see ValidateBackupName). A short random suffix is still added when an
+	// archive of that name exists.
+	Name string
+	// Format is the compression format of the archive: FormatXz (the default,
+	// .tar.xz) or FormatZstd (.tar.zst, much faster to create).
+	Format string
+	// Level is the compression level (see ValidateLevel); 0 uses the default of
+	// the format.
+	Level int
+	// Encrypt encrypts the archive with age to the recipients listed in
+	// AgeRecipientsFile, appending ".age" to its name.
+	Encrypt bool
+}
+
+// ErrNoChanges is returned by CreateBackupWithOpts with OnlyNew when nothing
+// changed since the last backup; no archive is created or uploaded.
+var ErrNoChanges = errors.New("no changes since the last backup")
+
+// CreateBackup copies files/folders to assets/tmp, then archives as .tar.xz (or .tar.zst) in assets with the naming convention,
 // and cleans up the tmp folder.
 //
 // NOTE: This function operates on the merged legacy slices (Folders, FilesAdd, FilesRemove)
@@ -20,89 +108,504 @@ import (
 // BEFORE invoking CreateBackup. Folder lists are concatenated in the order provided;
 // FilesAdd and FilesRemove are de-duplicated case-insensitively by path.
 func CreateBackup() error {
-	// Get project root (assume this file is always run from ~/setup or similar)
-	home, err := os.UserHomeDir()
+	_, err := CreateBackupWithOpts(CreateBackupOpts{})
+	return err
+}
+
+// CreateIncrementalBackup creates a backup holding only the files that changed
+// since the backup baseManifest describes, recording that backup as its parent.
+// Apply fetches the unchanged files from the parent.
+func CreateIncrementalBackup(baseManifest *Manifest) error {
+	if baseManifest == nil {
+		return errors.New("no base manifest given")
+	}
+	_, err := CreateBackupWithOpts(CreateBackupOpts{Base: baseManifest})
+	return err
+}
+
+// CreateBackupWithSets creates a backup of the named sets without changing the
+// globally active sets, so it is safe to use for several sets in one process.
+func CreateBackupWithSets(sets ...string) error {
+	_, err := CreateBackupWithOpts(CreateBackupOpts{Sets: sets})
+	return err
+}
+
+// CreateBackupWithOpts is like CreateBackup, but allows tuning the backup with opts.
+// It returns the path of the created archive, also when a later step such as
+// the upload failed.
+func CreateBackupWithOpts(opts CreateBackupOpts) (string, error) {
+	for _, t := range opts.Tags {
+		if err := ValidateTag(t); err != nil {
+			return "", err
+		}
+	}
+	if opts.Name != "" {
+		if err := ValidateBackupName(opts.Name); err != nil {
+			return "", err
+		}
+	}
+	ext, err := archiveExt(opts.Format)
+	if err != nil {
+		return "", err
+	}
+	if err := ValidateLevel(opts.Format, opts.Level); err != nil {
+		return "", err
+	}
+	var recipients []age.Recipient
+	if opts.Encrypt {
+		if recipients, err = loadAgeRecipients(); err != nil {
+			return "", err
+		}
+	}
+	src, err := opts.sources()
 	if err != nil {
-		return fmt.Errorf("could not get user home dir: %w", err)
+		return "", err
+	}
+
+	backupsDir, err := localBackupsDir()
+	if err != nil {
+		return "", err
 	}
-	backupsDir := filepath.Join(home, "setup", "backups")
 	tmpDir := filepath.Join(backupsDir, "tmp")
 
-	// Clean up tmpDir if it exists
-	_ = os.RemoveAll(tmpDir)
+	unlock, err := acquireLock(backupsDir)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	// Clean up tmpDir if it exists, unless resuming an interrupted run.
+	if !opts.Resume {
+		_ = os.RemoveAll(tmpDir)
+	}
 
 	// Copy all files/folders to tmpDir (reusing CopyAllToFiles logic, but targeting tmpDir)
-	if err := CopyAllToTarget(tmpDir); err != nil {
-		return fmt.Errorf("could not copy files to tmp: %w", err)
+	so := stageOptions{resume: opts.Resume, skipJunk: opts.SkipJunk}
+	summary, err := stageSources(src, tmpDir, so)
+	if err != nil {
+		if !opts.IgnoreMissing || !onlyMissing(summary.Failed) {
+			return "", err
+		}
+		for _, f := range summary.Failed {
+			fmt.Fprintf(os.Stderr, "Warning: skipping missing %s\n", f.Path)
+		}
+		if summary.Copied == 0 {
+			return "", errors.New("none of the declared paths exist; nothing to back up")
+		}
+	}
+	fmt.Printf("Copied %d path(s) to staging, %d failed.\n", summary.Copied, len(summary.Failed))
+	if n := len(summary.preProcessed); n > 0 {
+		fmt.Printf("Pre-processed %d file(s) before archiving.\n", n)
 	}
 
-	// Get username for naming
-	currentUser, err := user.Current()
-	username := "user"
-	if err == nil && currentUser.Username != "" {
-		// Only use the last path component (in case username is "alice" or "alice@host")
-		if u := filepath.Base(currentUser.Username); u != "" {
-			username = u
+	if opts.KeepEmptyDirs {
+		if err := stageEmptyDirs(src, tmpDir, so); err != nil {
+			return "", fmt.Errorf("could not stage empty directories: %w", err)
 		}
 	}
 
-	// Get timestamp for naming
-	timestamp := time.Now().Format("20060102-150405")
-	archiveName := fmt.Sprintf("home-%s-backup-%s.tar.xz", username, timestamp)
+	manifest, err := buildManifest(tmpDir, src.setNames(), summary.preProcessed)
+	if err != nil {
+		return "", err
+	}
+	if opts.TargetHome != "" {
+		manifest.Home = filepath.Clean(opts.TargetHome)
+	}
+	manifest.Remove = removalPaths(src)
+	manifest.markNoUpdate(src.noUpdatePaths())
+	manifest.Tags = opts.Tags
+	if len(manifest.Remove) > 0 {
+		fmt.Printf("%d path(s) from FilesRemove will be deleted on apply.\n", len(manifest.Remove))
+	}
+
+	if opts.OnlyNew {
+		if last, ok := unchangedSinceLast(backupsDir, manifest); ok {
+			_ = os.RemoveAll(tmpDir)
+			return "", fmt.Errorf("%w (%s)", ErrNoChanges, last)
+		}
+	}
+
+	if opts.Base != nil {
+		if opts.Base.Archive == "" {
+			return "", errors.New("base manifest does not name its archive")
+		}
+		changed, err := pruneUnchanged(tmpDir, manifest, opts.Base.Archive, opts.Base)
+		if err != nil {
+			return "", err
+		}
+		fmt.Printf("Incremental backup against %s: %d changed file(s).\n", opts.Base.Archive, changed)
+	} else if opts.SinceLast {
+		parentName, parent, err := previousManifest(backupsDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "No previous backup usable as incremental base (%v); creating a full backup.\n", err)
+		} else {
+			changed, err := pruneUnchanged(tmpDir, manifest, parentName, parent)
+			if err != nil {
+				return "", err
+			}
+			fmt.Printf("Incremental backup against %s: %d changed file(s).\n", parentName, changed)
+		}
+	}
+
+	base := opts.Name
+	if base == "" {
+		base = defaultBackupName(time.Now())
+	}
+	if opts.Encrypt {
+		ext += encryptedExt
+	}
+	archiveName, err := uniqueArchiveName(backupsDir, base, ext)
+	if err != nil {
+		return "", err
+	}
 	archivePath := filepath.Join(backupsDir, archiveName)
+	manifest.Archive = archiveName
+
+	if err := writeManifestFile(filepath.Join(tmpDir, manifestFileName), manifest); err != nil {
+		return "", err
+	}
 
-	// Create .tar.xz archive of tmpDir contents (treat tmpDir as root of archive)
+	// Create the archive of tmpDir contents (treat tmpDir as root of archive)
 	// The archive should contain the contents of tmpDir, not the tmpDir itself.
-	// So we use tar -C tmpDir . to archive everything inside tmpDir.
-	cmd := exec.Command("tar", "-C", tmpDir, "-cJf", archivePath, ".")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to create archive: %w", err)
+	if opts.UseSystemTar {
+		// --sparse stores holes of sparse files efficiently; extraction restores them.
+		compress, env := systemTarArgs(opts.Format, opts.Level)
+		plainPath := strings.TrimSuffix(archivePath, encryptedExt)
+		cmd := exec.Command("tar", "--sparse", compress, "-C", tmpDir, "-cf", plainPath, ".")
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := utils.RunCommand(cmd, TarTimeout); err != nil {
+			return "", fmt.Errorf("failed to create archive: %w", err)
+		}
+		if opts.Encrypt {
+			if _, err := encryptFile(plainPath, recipients); err != nil {
+				os.Remove(plainPath)
+				return "", fmt.Errorf("failed to encrypt archive: %w", err)
+			}
+		}
+	} else if err := createArchive(tmpDir, archivePath, opts.Format, opts.Level, recipients); err != nil {
+		return "", fmt.Errorf("failed to create archive: %w", err)
 	}
 
 	// Clean up tmpDir
 	if err := os.RemoveAll(tmpDir); err != nil {
-		return fmt.Errorf("could not clean up tmp dir: %w", err)
+		return archivePath, fmt.Errorf("could not clean up tmp dir: %w", err)
 	}
 
 	fmt.Printf("Backup created: %s\n", archivePath)
 
-	// Upload to Google Drive
-	if err := UploadToDrive(archivePath, "linux/backups/"+archiveName); err != nil {
-		return fmt.Errorf("failed to upload backup to Google Drive: %w", err)
+	if err := writeManifestSidecar(manifestSidecarPath(backupsDir, archiveName), manifest); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+
+	// Compare against the previous backup. This one is only recorded in the index
+	// once it is kept locally or uploaded, so that a failed upload does not become
+	// the baseline of the next backup.
+	var entry *BackupIndexEntry
+	if info, err := os.Stat(archivePath); err == nil {
+		warnOnSizeChange(backupsDir, info.Size())
+		entry = &BackupIndexEntry{Name: archiveName, Size: info.Size(), CreatedAt: time.Now(), Tags: opts.Tags}
+		if sum, err := hashFile(archivePath); err == nil {
+			entry.SHA256 = sum
+		}
+	}
+
+	if opts.LocalOnly {
+		recordBackup(backupsDir, entry)
+		fmt.Printf("Backup kept locally (not uploaded): %s\n", archivePath)
+		return archivePath, nil
+	}
+
+	store, err := SelectStore()
+	if err != nil {
+		return archivePath, err
+	}
+	if isDriveStore(store) {
+		store = driveStore{appProperties: tagProperties(opts.Tags), progress: opts.Progress}
+	}
+
+	if opts.DryRunUpload && !isDriveStore(store) {
+		fmt.Printf("Would upload %s to %s/%s\n", archivePath, store, driveBackupPath(archiveName))
+		return archivePath, nil
+	}
+	if opts.DryRunUpload {
+		plan, err := PlanUploadToDrive(archivePath, driveBackupPath(archiveName))
+		if err != nil {
+			return archivePath, fmt.Errorf("failed to plan upload to Google Drive: %w", err)
+		}
+		fmt.Println(plan)
+		return archivePath, nil
+	}
+
+	// Upload to the backup store
+	if err := store.Upload(archivePath, driveBackupPath(archiveName)); err != nil {
+		return archivePath, fmt.Errorf("failed to upload backup to %s: %w", store, err)
+	}
+	fmt.Printf("Backup uploaded to %s: %s\n", store, driveBackupPath(archiveName))
+	sidecar := manifest
+	if opts.RedactManifest {
+		sidecar = manifest.redacted()
+	}
+	if err := uploadManifestSidecar(store, archiveName, sidecar); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not upload manifest sidecar: %v\n", err)
+	}
+
+	if (opts.VerifyUpload || opts.VerifyUploadFull) && !isDriveStore(store) {
+		fmt.Fprintf(os.Stderr, "Warning: upload verification is only supported on Google Drive; skipped for %s\n", store)
+	} else if opts.VerifyUpload || opts.VerifyUploadFull {
+		if err := VerifyDriveUpload(archivePath, driveBackupPath(archiveName), opts.VerifyUploadFull); err != nil {
+			return archivePath, err
+		}
+		fmt.Println("Upload verified against the local archive.")
 	}
-	fmt.Printf("Backup uploaded to Google Drive: linux/backups/%s\n", archiveName)
+	// A failed verification must not leave the archive in the index, where
+	// --since-last would pick it as its base.
+	recordBackup(backupsDir, entry)
 
 	// (No longer removing local backups directory after upload)
+	return archivePath, nil
+}
+
+// sources returns the folders and files opts back up: the active backup sets
+// or opts.Sets, minus opts.ExcludeSets and, with ExcludeCredentials, the
+// credentials .env, rooted at opts.TargetHome when set.
+func (opts CreateBackupOpts) sources() (backupSources, error) {
+	src := activeSources()
+	if len(opts.Sets) > 0 {
+		sets, err := resolveBackupSets(opts.Sets)
+		if err != nil {
+			return backupSources{}, err
+		}
+		src = mergeBackupSets(sets)
+	}
+	if len(opts.ExcludeSets) > 0 {
+		excluded, err := resolveBackupSets(opts.ExcludeSets)
+		if err != nil {
+			return backupSources{}, err
+		}
+		src = subtractBackupSets(src, excluded)
+	}
+	if opts.ExcludeCredentials {
+		src = withoutFiles(src, credentialsFiles())
+	}
+	if opts.TargetHome != "" {
+		if err := checkReadableDir(opts.TargetHome); err != nil {
+			return backupSources{}, fmt.Errorf("invalid target home: %w", err)
+		}
+		src = rehome(src, opts.TargetHome)
+	}
+	return src, nil
+}
+
+// checkReadableDir fails unless dir is a directory whose entries can be listed.
+func checkReadableDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if _, err := f.Readdirnames(1); err != nil && err != io.EOF {
+		return fmt.Errorf("%s is not readable: %w", dir, err)
+	}
 	return nil
 }
 
+// currentUsername returns the name used in archive names and manifests.
+func currentUsername() string {
+	currentUser, err := user.Current()
+	username := "user"
+	if err == nil && currentUser.Username != "" {
+		// Only use the last path component (in case username is "alice" or "alice@host")
+		if u := filepath.Base(currentUser.Username); u != "" {
+			username = u
+		}
+	}
+	return username
+}
+
+// SizeWarnPercent is the relative size change (in percent) between a new backup and
+// the previous one above which CreateBackup prints a warning. Zero disables the check.
+var SizeWarnPercent = 50.0
+
+// warnOnSizeChange compares size against the previous backup (from the local index,
+// falling back to Google Drive metadata) and warns when it changed by more than
+// SizeWarnPercent. This is a cheap detector for accidentally included caches or
+// accidentally excluded folders.
+func warnOnSizeChange(backupsDir string, size int64) {
+	if SizeWarnPercent <= 0 {
+		return
+	}
+	prev := previousBackupSize(backupsDir)
+	if prev <= 0 {
+		return
+	}
+	ratio := float64(size) / float64(prev)
+	change := (ratio - 1) * 100
+	if change < 0 {
+		change = -change
+	}
+	if change <= SizeWarnPercent {
+		return
+	}
+	if ratio > 1 {
+		fmt.Fprintf(os.Stderr, "Warning: archive grew %.1fx vs previous (%s -> %s) — did a cache get included?\n",
+			ratio, utils.FormatBytes(prev), utils.FormatBytes(size))
+	} else {
+		fmt.Fprintf(os.Stderr, "Warning: archive shrank to %.1fx of previous (%s -> %s) — did files get excluded?\n",
+			ratio, utils.FormatBytes(prev), utils.FormatBytes(size))
+	}
+}
+
+// recordBackup adds entry, when known, to the local index in backupsDir, only
+// warning when that fails.
+func recordBackup(backupsDir string, entry *BackupIndexEntry) {
+	if entry == nil {
+		return
+	}
+	if err := appendBackupIndex(backupsDir, *entry); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+}
+
+// previousBackupSize returns the size of the previous backup, or 0 if unknown.
+func previousBackupSize(backupsDir string) int64 {
+	if entry, err := latestIndexEntry(backupsDir); err == nil && entry != nil {
+		return entry.Size
+	}
+	if store, err := SelectStore(); err != nil || !isDriveStore(store) {
+		return 0
+	}
+	if f, err := latestDriveBackupFile(context.Background(), driveBackupFolder(), ""); err == nil {
+		return f.Size
+	}
+	return 0
+}
+
 // CopyAllToTarget copies all files/folders defined in write_files.go to the given targetDir,
 // keeping the directory structure as if targetDir is the root.
-func CopyAllToTarget(targetDir string) error {
+// Paths are copied in parallel (see CopyConcurrency); every path is attempted and
+// an error is returned if any of them failed. Files of FileAdd entries with
+// Update false never overwrite an existing file in targetDir.
+func CopyAllToTarget(targetDir string) (*CopySummary, error) {
+	return stageSources(activeSources(), targetDir, stageOptions{keepExisting: true})
+}
+
+// onlyMissing reports whether every failure is a path that does not exist.
+func onlyMissing(failed []CopyFailure) bool {
+	for _, f := range failed {
+		if !errors.Is(f.Err, fs.ErrNotExist) {
+			return false
+		}
+	}
+	return true
+}
+
+// stageSources copies the files/folders of src to targetDir, keeping the
+// directory structure as if targetDir is the root.
+func stageSources(src backupSources, targetDir string, so stageOptions) (*CopySummary, error) {
+	var jobs []copyJob
+
+	var pre preProcessLog
+
 	// Copy individual files
-	for _, file := range FilesAdd {
-		if err := copyFileToTarget(file.Path, targetDir); err != nil {
-			fmt.Fprintf(os.Stderr, "Error copying %s: %v\n", file.Path, err)
+	skip := so.skipFunc(nil)
+	for _, file := range src.FilesAdd {
+		keep := so.keepExisting && !file.Update
+		stageRaw := func(path string) error { return copyFileToTarget(path, targetDir, so.resume, keep, skip) }
+		for i, path := range file.paths() {
+			if i == 0 && file.PreProcess != nil {
+				pp := *file.PreProcess
+				jobs = append(jobs, copyJob{path: path, copy: func() error { return pre.stage(path, targetDir, pp, stageRaw) }})
+				continue
+			}
+			jobs = append(jobs, copyJob{path: path, copy: func() error { return stageRaw(path) }})
 		}
 	}
 
 	// Copy files inside folders
-	for _, folder := range Folders {
-		for _, content := range folder.Contents {
+	for _, folder := range src.Folders {
+		skip := so.skipFunc(&folder)
+		stageRaw := func(path string) error { return copyFileToTarget(path, targetDir, so.resume, false, skip) }
+		consumed := folder.consumedContents()
+		contents, err := folder.resolveContents()
+		if err != nil {
+			jobs = append(jobs, copyJob{path: folder.Path, copy: func() error { return err }})
+			continue
+		}
+		for _, content := range contents {
 			orig := filepath.Join(folder.Path, content)
-			if err := copyFileToTarget(orig, targetDir); err != nil {
-				fmt.Fprintf(os.Stderr, "Error copying %s: %v\n", orig, err)
+			if pp, ok := folder.PreProcess[content]; ok {
+				jobs = append(jobs, copyJob{path: orig, copy: func() error { return pre.stage(orig, targetDir, pp, stageRaw) }})
+				continue
+			}
+			if consumed[content] {
+				continue
 			}
+			jobs = append(jobs, copyJob{path: orig, copy: func() error { return stageRaw(orig) }})
 		}
 	}
 
+	summary, err := runCopyJobs(jobs)
+	summary.preProcessed = pre.files
+	return summary, err
+}
+
+// stageEmptyDirs recreates in targetDir every empty directory of the folders
+// of src that staging them copied: the declared Contents (or the whole folder)
+// and whatever below them so's skip rules (SkipHidden, Excludes, junk) keep.
+func stageEmptyDirs(src backupSources, targetDir string, so stageOptions) error {
+	for _, folder := range src.Folders {
+		skip := so.skipFunc(&folder)
+		contents, err := folder.resolveContents()
+		if err != nil {
+			return err
+		}
+		for _, content := range contents {
+			root, err := expandHome(filepath.Join(folder.Path, content))
+			if err != nil {
+				return err
+			}
+			err = walkSkipping(root, skip, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					if os.IsNotExist(err) && path == root {
+						return filepath.SkipDir
+					}
+					return err
+				}
+				if !info.IsDir() {
+					return nil
+				}
+				entries, err := os.ReadDir(path)
+				if err != nil {
+					return err
+				}
+				if len(entries) > 0 {
+					return nil
+				}
+				return os.MkdirAll(filepath.Join(targetDir, trimLeadingSlash(path)), info.Mode().Perm())
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
 // copyFileToTarget copies a file or directory from the system to the targetDir, keeping the root directory structure.
-func copyFileToTarget(origPath, targetDir string) error {
+// With resume, files already staged with identical contents are skipped; with
+// keepExisting, files already present at their destination are. Entries below a
+// directory whose name matches skip (when non-nil) are left out. Symlinks,
+// origPath included, are recreated as links.
+func copyFileToTarget(origPath, targetDir string, resume, keepExisting bool, skip func(name string) bool) error {
 	expanded, err := expandHome(origPath)
 	if err != nil {
 		return err
@@ -112,15 +615,47 @@ func copyFileToTarget(origPath, targetDir string) error {
 	relPath := trimLeadingSlash(expanded)
 	destPath := filepath.Join(targetDir, relPath)
 
-	// If it's a directory, copy recursively
-	info, err := os.Stat(expanded)
+	// A symlink is staged as a link, like the walk below does, never through it.
+	info, err := os.Lstat(expanded)
 	if err != nil {
 		return err
 	}
-	if info.IsDir() {
-		return utils.CopyDir(expanded, destPath)
+	if !resume && !keepExisting && skip == nil {
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			return utils.CopySymlink(expanded, destPath)
+		case info.IsDir():
+			return utils.CopyDir(expanded, destPath)
+		}
+		return utils.CopyFile(expanded, destPath)
 	}
-	return utils.CopyFile(expanded, destPath)
+	return walkSkipping(expanded, skip, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(expanded, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(destPath, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode())
+		}
+		if keepExisting {
+			if _, err := os.Lstat(target); err == nil {
+				return nil
+			}
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return utils.CopySymlink(path, target)
+		}
+		if resume {
+			if equal, err := utils.FilesAreEqual(path, target); err == nil && equal {
+				return nil
+			}
+		}
+		return utils.CopyFile(path, target, info.Mode())
+	})
 }
 
 // expandHome expands ~ to the user's home directory.
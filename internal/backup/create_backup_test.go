@@ -0,0 +1,251 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCreateBackupWithSetsKeepsGlobals(t *testing.T) {
+	home := setTestHome(t)
+	writeTestFile(t, filepath.Join(home, ".leaktest"), "leak test\n")
+	registerTestSet(t, BackupSet{Name: "leaktest", FilesAdd: []FileAdd{{Path: "~/.leaktest", Update: true}}})
+
+	active := slices.Clone(ActiveBackupSets)
+	folders, filesAdd, filesRemove := slices.Clone(Folders), slices.Clone(FilesAdd), slices.Clone(FilesRemove)
+
+	archive, err := CreateBackupWithOpts(CreateBackupOpts{Sets: []string{"leaktest"}, LocalOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := extractManifest(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m.Sets, []string{"leaktest"}) {
+		t.Errorf("manifest sets = %v, want [leaktest]", m.Sets)
+	}
+	if _, ok := m.find(filepath.ToSlash(trimLeadingSlash(filepath.Join(home, ".leaktest")))); !ok {
+		t.Errorf("backup lacks ~/.leaktest")
+	}
+
+	if !reflect.DeepEqual(ActiveBackupSets, active) {
+		t.Errorf("ActiveBackupSets changed to %v", ActiveBackupSets)
+	}
+	if !reflect.DeepEqual(Folders, folders) || !reflect.DeepEqual(FilesAdd, filesAdd) || !reflect.DeepEqual(FilesRemove, filesRemove) {
+		t.Errorf("merged global slices changed")
+	}
+}
+
+func TestCreateBackupKeepEmptyDirs(t *testing.T) {
+	home := setTestHome(t)
+	writeTestFile(t, filepath.Join(home, "proj", "a.txt"), "a\n")
+	for _, dir := range []string{"sub/empty", "undeclared"} {
+		if err := os.MkdirAll(filepath.Join(home, "proj", dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	registerTestSet(t, BackupSet{Name: "emptydirs", Folders: []Folder{{Path: "~/proj", Contents: []string{"a.txt", "sub"}}}})
+
+	for _, keep := range []bool{false, true} {
+		archive, err := CreateBackupWithOpts(CreateBackupOpts{Sets: []string{"emptydirs"}, LocalOnly: true, KeepEmptyDirs: keep})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dest := t.TempDir()
+		if err := extractArchive(archive, dest); err != nil {
+			t.Fatal(err)
+		}
+		// Staging a declared directory copies its empty subdirectories; the
+		// ones outside Contents stay out either way.
+		if _, err := os.Stat(filepath.Join(dest, home, "proj", "sub", "empty")); err != nil {
+			t.Errorf("empty dir below Contents not archived (KeepEmptyDirs %v): %v", keep, err)
+		}
+		if _, err := os.Stat(filepath.Join(dest, home, "proj", "undeclared")); err == nil {
+			t.Errorf("empty dir outside Contents archived (KeepEmptyDirs %v)", keep)
+		}
+	}
+}
+
+func TestCreateBackupExcludes(t *testing.T) {
+	home := setTestHome(t)
+	for _, p := range []string{"settings.json", "conversations/1.json", "extensions/ext/x.wasm", "themes/cache.tmp", "themes/dark.json"} {
+		writeTestFile(t, filepath.Join(home, "zed", p), "x\n")
+	}
+	registerTestSet(t, BackupSet{
+		Name:     "excludes",
+		Folders:  []Folder{{Path: "~/zed", Excludes: []string{"conversations", "extensions"}}},
+		Excludes: []string{"**/*.tmp"},
+	})
+
+	archive, err := CreateBackupWithOpts(CreateBackupOpts{Sets: []string{"excludes"}, LocalOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := t.TempDir()
+	if err := extractArchive(archive, dest); err != nil {
+		t.Fatal(err)
+	}
+	for p, want := range map[string]bool{
+		"settings.json":         true,
+		"themes/dark.json":      true,
+		"conversations/1.json":  false,
+		"extensions/ext/x.wasm": false,
+		"themes/cache.tmp":      false,
+	} {
+		_, err := os.Stat(filepath.Join(dest, home, "zed", p))
+		if got := err == nil; got != want {
+			t.Errorf("%s archived = %v, want %v", p, got, want)
+		}
+	}
+}
+
+func TestCreateBackupNamesDoNotCollide(t *testing.T) {
+	home := setTestHome(t)
+	writeTestFile(t, filepath.Join(home, ".names"), "names\n")
+	registerTestSet(t, BackupSet{Name: "names", FilesAdd: []FileAdd{{Path: "~/.names", Update: true}}})
+
+	for _, name := range []string{"", "fixed"} {
+		seen := make(map[string]bool)
+		for range 3 {
+			archive, err := CreateBackupWithOpts(CreateBackupOpts{Sets: []string{"names"}, LocalOnly: true, Name: name})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if seen[archive] {
+				t.Fatalf("backup %s created twice", archive)
+			}
+			seen[archive] = true
+		}
+	}
+}
+
+func TestValidateBackupName(t *testing.T) {
+	for name, ok := range map[string]bool{
+		"laptop-2024":            true,
+		"before_upgrade.v2":      true,
+		"":                       false,
+		"-leading-dash":          false,
+		"../escape":              false,
+		"with space":             false,
+		"already.tar.xz":         false,
+		strings.Repeat("a", 129): false,
+	} {
+		if err := ValidateBackupName(name); (err == nil) != ok {
+			t.Errorf("ValidateBackupName(%q) = %v, want ok %v", name, err, ok)
+		}
+	}
+}
+
+func TestCreateBackupKeepEmptyDirsHonorsExcludes(t *testing.T) {
+	home := setTestHome(t)
+	writeTestFile(t, filepath.Join(home, "zed", "settings.json"), "{}\n")
+	for _, dir := range []string{"themes/empty", "conversations/old", "extensions", "cache/tmp", ".hidden"} {
+		if err := os.MkdirAll(filepath.Join(home, "zed", dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	registerTestSet(t, BackupSet{
+		Name:     "emptyexcludes",
+		Folders:  []Folder{{Path: "~/zed", Excludes: []string{"conversations", "extensions"}, SkipHidden: true}},
+		Excludes: []string{"cache"},
+	})
+
+	archive, err := CreateBackupWithOpts(CreateBackupOpts{Sets: []string{"emptyexcludes"}, LocalOnly: true, KeepEmptyDirs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := t.TempDir()
+	if err := extractArchive(archive, dest); err != nil {
+		t.Fatal(err)
+	}
+	for dir, want := range map[string]bool{
+		"themes/empty":      true,
+		"conversations/old": false,
+		"conversations":     false,
+		"extensions":        false,
+		"cache":             false,
+		".hidden":           false,
+	} {
+		_, err := os.Stat(filepath.Join(dest, home, "zed", dir))
+		if got := err == nil; got != want {
+			t.Errorf("%s archived = %v, want %v", dir, got, want)
+		}
+	}
+}
+
+func TestCopyFileToTargetStagesSymlinkAsLink(t *testing.T) {
+	home := setTestHome(t)
+	writeTestFile(t, filepath.Join(home, "dotfiles", "zshrc"), "zsh\n")
+	link := filepath.Join(home, ".zshrc")
+	if err := os.Symlink("dotfiles/zshrc", link); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name                 string
+		resume, keepExisting bool
+		skip                 func(string) bool
+	}{
+		{name: "plain"},
+		{name: "resume", resume: true},
+		{name: "keep existing", keepExisting: true},
+		{name: "skip", skip: func(string) bool { return false }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			targetDir := t.TempDir()
+			if err := copyFileToTarget("~/.zshrc", targetDir, tt.resume, tt.keepExisting, tt.skip); err != nil {
+				t.Fatal(err)
+			}
+			staged := filepath.Join(targetDir, trimLeadingSlash(link))
+			info, err := os.Lstat(staged)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode()&os.ModeSymlink == 0 {
+				t.Fatalf("staged %v, want a symlink", info.Mode())
+			}
+			if got, _ := os.Readlink(staged); got != "dotfiles/zshrc" {
+				t.Errorf("staged link points at %q", got)
+			}
+		})
+	}
+}
+
+func TestCheckReadableDir(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty")
+	if err := os.Mkdir(empty, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "file")
+	writeTestFile(t, file, "x")
+
+	for _, tt := range []struct {
+		name, dir string
+		ok        bool
+	}{
+		{"dir with entries", dir, true},
+		{"empty dir", empty, true},
+		{"regular file", file, false},
+		{"missing", filepath.Join(dir, "missing"), false},
+	} {
+		if err := checkReadableDir(tt.dir); (err == nil) != tt.ok {
+			t.Errorf("%s: checkReadableDir(%s) = %v", tt.name, tt.dir, err)
+		}
+	}
+
+	if runtime.GOOS != "windows" && os.Geteuid() != 0 {
+		locked := filepath.Join(dir, "locked")
+		if err := os.Mkdir(locked, 0o311); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkReadableDir(locked); err == nil {
+			t.Error("checkReadableDir passed for a directory that cannot be listed")
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"setup/shared/utils"
+)
+
+// BackupPlan lists what a backup would copy, as reported by PlanBackup.
+type BackupPlan struct {
+	Paths []PlannedPath `json:"paths"`
+	// Missing are the source paths that do not exist; create reports them as
+	// copy errors and leaves them out of the archive.
+	Missing []string `json:"missing"`
+	// Files and Size count the regular files that would be staged and their
+	// bytes before compression (and before any PreProcess command).
+	Files int   `json:"files"`
+	Size  int64 `json:"size"`
+}
+
+// PlannedPath is one source path of a BackupPlan, with "~" expanded.
+type PlannedPath struct {
+	Path    string `json:"path"`
+	Files   int    `json:"files"`
+	Size    int64  `json:"size"`
+	Missing bool   `json:"missing,omitempty"`
+	// Error is set when the path exists but could not be fully read.
+	Error string `json:"error,omitempty"`
+}
+
+// PlanBackup reports what CreateBackup would copy from the active backup sets,
+// without copying or writing anything.
+func PlanBackup() (*BackupPlan, error) {
+	return PlanBackupWithOpts(CreateBackupOpts{})
+}
+
+// PlanBackupWithOpts is PlanBackup for the sources and filters selected by opts
+// (see CreateBackupWithOpts).
+func PlanBackupWithOpts(opts CreateBackupOpts) (*BackupPlan, error) {
+	src, err := opts.sources()
+	if err != nil {
+		return nil, err
+	}
+	so := stageOptions{skipJunk: opts.SkipJunk}
+	plan := &BackupPlan{Paths: []PlannedPath{}, Missing: []string{}}
+	add := func(path string, skip func(string) bool) {
+		p := planPath(path, skip)
+		plan.Paths = append(plan.Paths, p)
+		if p.Missing {
+			plan.Missing = append(plan.Missing, p.Path)
+		}
+		plan.Files += p.Files
+		plan.Size += p.Size
+	}
+
+	for _, file := range src.FilesAdd {
+		for _, path := range file.paths() {
+			add(path, so.skipFunc(nil))
+		}
+	}
+	for _, folder := range src.Folders {
+		skip := so.skipFunc(&folder)
+		contents, err := folder.resolveContents()
+		if err != nil {
+			return nil, err
+		}
+		consumed := folder.consumedContents()
+		for _, content := range contents {
+			if _, pre := folder.PreProcess[content]; !pre && consumed[content] {
+				continue
+			}
+			add(filepath.Join(folder.Path, content), skip)
+		}
+	}
+	return plan, nil
+}
+
+// planPath measures the source path, walking directories like staging does.
+func planPath(path string, skip func(string) bool) PlannedPath {
+	expanded, err := expandHome(path)
+	if err != nil {
+		return PlannedPath{Path: path, Error: err.Error()}
+	}
+	p := PlannedPath{Path: expanded}
+	if _, err := os.Stat(expanded); os.IsNotExist(err) {
+		p.Missing = true
+		return p
+	}
+	err = walkSkipping(expanded, skip, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			p.Files++
+			p.Size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		p.Error = err.Error()
+	}
+	return p
+}
+
+// Print writes the plan to w: one line per path, then the totals.
+func (p *BackupPlan) Print(w io.Writer) {
+	for _, path := range p.Paths {
+		switch {
+		case path.Missing:
+			fmt.Fprintf(w, "MISSING  %s\n", path.Path)
+		case path.Error != "":
+			fmt.Fprintf(w, "ERROR    %s: %s\n", path.Path, path.Error)
+		default:
+			fmt.Fprintf(w, "copy     %s (%d file(s), %s)\n", path.Path, path.Files, utils.FormatBytes(path.Size))
+		}
+	}
+	fmt.Fprintf(w, "%d file(s), %s before compression; %d path(s) missing.\n", p.Files, utils.FormatBytes(p.Size), len(p.Missing))
+}
@@ -0,0 +1,160 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"setup/shared/utils"
+)
+
+// DiffReport compares the files of a backup with the live system. Paths are the
+// live targets.
+type DiffReport struct {
+	Archive string `json:"archive"`
+	// Added are in the backup but missing on the system.
+	Added []string `json:"added"`
+	// Removed exist on the system but are listed in the backup's FilesRemove,
+	// so applying it would delete them.
+	Removed []string `json:"removed"`
+	// Changed exist on both sides with different contents (or types).
+	Changed []string `json:"changed"`
+	// Identical exist on both sides with the same contents.
+	Identical []string `json:"identical"`
+}
+
+// DiffBackup extracts the backup archivePath (resolved like the backup file of
+// ApplyBackupWithOpts) and compares every file filter accepts against its live
+// target under /, without changing anything. A nil filter compares all files.
+func DiffBackup(archivePath string, filter func(rel string, info os.FileInfo) bool) (*DiffReport, error) {
+	if filter == nil {
+		filter = func(string, os.FileInfo) bool { return true }
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("could not get user home: %w", err)
+	}
+	backupsDir, err := localBackupsDir()
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := acquireLock(backupsDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	prepared, err := prepareBackup(backupsDir, archivePath, home, false, false)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(prepared.TmpDir)
+
+	report := &DiffReport{
+		Archive:   filepath.Base(prepared.Archive),
+		Added:     []string{},
+		Changed:   []string{},
+		Identical: []string{},
+	}
+	err = walkBackupTree(prepared.TmpDir, filter, func(rel, path string, info os.FileInfo) error {
+		if info.IsDir() {
+			return nil
+		}
+		target := filepath.Join(string(os.PathSeparator), rel)
+		same, err := sameAsLive(path, target, info)
+		switch {
+		case os.IsNotExist(err):
+			report.Added = append(report.Added, target)
+		case err != nil:
+			return err
+		case same:
+			report.Identical = append(report.Identical, target)
+		default:
+			report.Changed = append(report.Changed, target)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not compare backup: %w", err)
+	}
+	report.Removed = append([]string{}, removalTargets(prepared.Manifest, filter)...)
+	return report, nil
+}
+
+// sameAsLive reports whether the extracted file path (described by info) matches
+// target: symlinks must point to the same place and regular files hold the same
+// bytes. A missing target is returned as an os.IsNotExist error.
+func sameAsLive(path, target string, info os.FileInfo) (bool, error) {
+	live, err := os.Lstat(target)
+	if err != nil {
+		return false, err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		if live.Mode()&os.ModeSymlink == 0 {
+			return false, nil
+		}
+		want, err := os.Readlink(path)
+		if err != nil {
+			return false, err
+		}
+		got, err := os.Readlink(target)
+		if err != nil {
+			return false, err
+		}
+		return want == got, nil
+	}
+	if !live.Mode().IsRegular() {
+		return false, nil
+	}
+	return utils.FilesAreEqual(path, target)
+}
+
+// StepsFilter returns a filter accepting the paths of any of the named backup
+// steps (see ApplyBackupOpts.Steps), or nil when no step is named.
+func StepsFilter(steps []string) (func(rel string, info os.FileInfo) bool, error) {
+	if len(steps) == 0 {
+		return nil, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("could not get user home: %w", err)
+	}
+	selected, err := ApplyBackupOpts{Steps: steps}.steps(home)
+	if err != nil {
+		return nil, err
+	}
+	var filters []func(rel string, info os.FileInfo) bool
+	for _, step := range selected {
+		if step.Filter != nil {
+			filters = append(filters, step.Filter)
+		}
+	}
+	return func(rel string, info os.FileInfo) bool {
+		for _, f := range filters {
+			if f(rel, info) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// Print writes the counts of r to w, preceded with nameOnly by the differing
+// paths, one per line with a marker: "+" added, "-" removed, "M" changed.
+func (r *DiffReport) Print(w io.Writer, nameOnly bool) {
+	if nameOnly {
+		for _, p := range r.Added {
+			fmt.Fprintf(w, "+ %s\n", p)
+		}
+		for _, p := range r.Removed {
+			fmt.Fprintf(w, "- %s\n", p)
+		}
+		for _, p := range r.Changed {
+			fmt.Fprintf(w, "M %s\n", p)
+		}
+	}
+	fmt.Fprintf(w, "%s: %d added, %d removed, %d changed, %d identical.\n",
+		r.Archive, len(r.Added), len(r.Removed), len(r.Changed), len(r.Identical))
+}
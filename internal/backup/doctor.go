@@ -0,0 +1,117 @@
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"setup/shared/utils"
+)
+
+// Check is the outcome of one environment check of Doctor.
+type Check struct {
+	Name string
+	// Hard checks are prerequisites of create and apply; the others only matter
+	// for optional features.
+	Hard bool
+	// Err is nil when the check passed.
+	Err error
+}
+
+// Doctor checks the prerequisites of setup: the home directory, the setup root,
+// git, the backup store credentials and, for --system-tar, tar with xz.
+func Doctor() []Check {
+	return []Check{
+		{Name: "home directory", Hard: true, Err: checkHomeDir()},
+		{Name: "setup root is writable", Hard: true, Err: checkSetupRoot()},
+		{Name: "git", Hard: true, Err: checkCommand("git")},
+		{Name: "backup store credentials", Hard: true, Err: checkStoreCredentials()},
+		{Name: "tar (for --system-tar)", Err: checkCommand("tar")},
+		{Name: "tar with xz round-trip (for --system-tar)", Err: checkTarXz()},
+	}
+}
+
+// checkHomeDir fails when the home directory of the user cannot be resolved.
+func checkHomeDir() error {
+	_, err := os.UserHomeDir()
+	return err
+}
+
+// checkSetupRoot fails unless utils.SetupRoot is an existing, writable directory.
+func checkSetupRoot() error {
+	root, err := utils.SetupRoot()
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(root)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s does not exist (create it with: setup init)", root)
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", root)
+	}
+	return checkWritableDir(root)
+}
+
+// checkCommand fails when name is not found in $PATH.
+func checkCommand(name string) error {
+	_, err := exec.LookPath(name)
+	return err
+}
+
+// checkStoreCredentials fails when the backup store selected by $BACKUP_STORE
+// lacks its configuration; for Google Drive these are the GOOGLE_* variables
+// of the credentials .env.
+func checkStoreCredentials() error {
+	store, err := SelectStore()
+	if err != nil {
+		return err
+	}
+	if isDriveStore(store) {
+		_, _, err = getCredentials()
+	}
+	return err
+}
+
+// checkTarXz archives a small file with "tar -cJf" and extracts it again,
+// failing unless the contents survive the round-trip.
+func checkTarXz() error {
+	dir, err := os.MkdirTemp("", "setup-doctor-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	want := []byte("setup doctor\n")
+	if err := os.WriteFile(filepath.Join(dir, "probe"), want, 0o644); err != nil {
+		return err
+	}
+	archive := filepath.Join(dir, "probe.tar.xz")
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0o755); err != nil {
+		return err
+	}
+	for _, args := range [][]string{
+		{"-C", dir, "-cJf", archive, "probe"},
+		{"-C", out, "-xJf", archive},
+	} {
+		if output, err := exec.Command("tar", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("tar %s: %w: %s", args[2], err, strings.TrimSpace(string(output)))
+		}
+	}
+	got, err := os.ReadFile(filepath.Join(out, "probe"))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, want) {
+		return errors.New("extracted file differs from the archived one")
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package backup
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCheckHomeDir(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	if err := checkHomeDir(); err != nil {
+		t.Errorf("checkHomeDir with HOME set: %v", err)
+	}
+	if runtime.GOOS != "windows" {
+		t.Setenv("HOME", "")
+		if err := checkHomeDir(); err == nil {
+			t.Error("checkHomeDir passed without HOME")
+		}
+	}
+}
+
+func TestCheckSetupRoot(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SETUP_ROOT", dir)
+	if err := checkSetupRoot(); err != nil {
+		t.Errorf("checkSetupRoot(existing dir): %v", err)
+	}
+
+	t.Setenv("SETUP_ROOT", filepath.Join(dir, "missing"))
+	if err := checkSetupRoot(); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("checkSetupRoot(missing) = %v, want a does not exist error", err)
+	}
+
+	file := filepath.Join(dir, "file")
+	writeTestFile(t, file, "")
+	t.Setenv("SETUP_ROOT", file)
+	if err := checkSetupRoot(); err == nil {
+		t.Error("checkSetupRoot passed for a regular file")
+	}
+
+	if os.Geteuid() != 0 {
+		readOnly := filepath.Join(dir, "ro")
+		if err := os.Mkdir(readOnly, 0o555); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("SETUP_ROOT", readOnly)
+		if err := checkSetupRoot(); err == nil {
+			t.Error("checkSetupRoot passed for a read-only dir")
+		}
+	}
+}
+
+func TestCheckCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err == nil {
+		if err := checkCommand("sh"); err != nil {
+			t.Errorf("checkCommand(sh): %v", err)
+		}
+	}
+	if err := checkCommand("setup-doctor-no-such-command"); err == nil {
+		t.Error("checkCommand passed for a missing command")
+	}
+}
+
+func TestCheckStoreCredentials(t *testing.T) {
+	vars := []string{"GOOGLE_CLIENT_ID", "GOOGLE_CLIENT_SECRET", "GOOGLE_AUTH_URI", "GOOGLE_TOKEN_URI", "GOOGLE_REDIRECT_URIS", "GOOGLE_ACCESS_TOKEN", "GOOGLE_REFRESH_TOKEN", "GOOGLE_TOKEN_TYPE", "GOOGLE_TOKEN_EXPIRY"}
+	for _, v := range vars {
+		t.Setenv(v, "")
+	}
+	old := CredentialsEnvFile
+	t.Cleanup(func() { CredentialsEnvFile = old })
+	t.Setenv("BACKUP_STORE", "drive")
+
+	CredentialsEnvFile = filepath.Join(t.TempDir(), ".env")
+	writeTestFile(t, CredentialsEnvFile, "GOOGLE_CLIENT_ID=id\n")
+	if err := checkStoreCredentials(); err == nil {
+		t.Error("checkStoreCredentials passed with credentials missing")
+	}
+
+	writeTestFile(t, CredentialsEnvFile, strings.Join([]string{
+		"GOOGLE_CLIENT_ID=id",
+		"GOOGLE_CLIENT_SECRET=secret",
+		"GOOGLE_AUTH_URI=https://accounts.google.com/o/oauth2/auth",
+		"GOOGLE_TOKEN_URI=https://oauth2.googleapis.com/token",
+		"GOOGLE_REDIRECT_URIS=http://localhost",
+		"GOOGLE_REFRESH_TOKEN=refresh",
+	}, "\n")+"\n")
+	if err := checkStoreCredentials(); err != nil {
+		t.Errorf("checkStoreCredentials with complete credentials: %v", err)
+	}
+
+	t.Setenv("BACKUP_STORE", "floppy")
+	if err := checkStoreCredentials(); err == nil {
+		t.Error("checkStoreCredentials passed for an unknown store")
+	}
+}
+
+func TestCheckTarXz(t *testing.T) {
+	for _, cmd := range []string{"tar", "xz"} {
+		if _, err := exec.LookPath(cmd); err != nil {
+			t.Skipf("no %s", cmd)
+		}
+	}
+	if err := checkTarXz(); err != nil {
+		t.Errorf("checkTarXz: %v", err)
+	}
+}
@@ -4,21 +4,70 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"time"
 
+	"setup/shared/utils"
+
 	"github.com/joho/godotenv"
 	"golang.org/x/oauth2"
 	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/option"
 )
 
+// DriveBackupDir is the slash separated Google Drive folder backups are stored in.
+var DriveBackupDir = "linux/backups"
+
+// CredentialsEnvFile, when set, is the env file the Google credentials are loaded
+// from instead of the .env in the working directory. Its values take precedence
+// over variables already present in the environment.
+var CredentialsEnvFile string
+
+// credentialsFiles returns the env files that may hold the Google credentials:
+// CredentialsEnvFile when set and the .env of the setup root.
+func credentialsFiles() []string {
+	var files []string
+	if CredentialsEnvFile != "" {
+		files = append(files, filepath.Clean(CredentialsEnvFile))
+	}
+	if root, err := utils.SetupRoot(); err == nil {
+		files = append(files, filepath.Join(root, ".env"))
+	}
+	return files
+}
+
+// driveBackupPath returns the Drive path of the backup archive called name.
+func driveBackupPath(name string) string {
+	return strings.Trim(DriveBackupDir, "/") + "/" + name
+}
+
+// driveBackupFolder returns DriveBackupDir split into folder names.
+func driveBackupFolder() []string {
+	return strings.Split(strings.Trim(DriveBackupDir, "/"), "/")
+}
+
+// loadCredentialsEnv carrega as variáveis de CredentialsEnvFile ou, sem ele, do
+// .env do diretório atual, se existir.
+func loadCredentialsEnv() error {
+	if CredentialsEnvFile != "" {
+		if err := godotenv.Overload(CredentialsEnvFile); err != nil {
+			return fmt.Errorf("erro ao carregar %s: %w", CredentialsEnvFile, err)
+		}
+		return nil
+	}
+	_ = godotenv.Load()
+	return nil
+}
+
 // getCredentials loads OAuth2 config and token from environment variables (.env).
 func getCredentials() (*oauth2.Config, *oauth2.Token, error) {
-	// Carrega variáveis do .env, se existir
-	_ = godotenv.Load()
+	if err := loadCredentialsEnv(); err != nil {
+		return nil, nil, err
+	}
 
 	clientID := os.Getenv("GOOGLE_CLIENT_ID")
 	clientSecret := os.Getenv("GOOGLE_CLIENT_SECRET")
@@ -27,7 +76,7 @@ func getCredentials() (*oauth2.Config, *oauth2.Token, error) {
 	redirectURIs := os.Getenv("GOOGLE_REDIRECT_URIS")
 
 	if clientID == "" || clientSecret == "" || authURI == "" || tokenURI == "" || redirectURIs == "" {
-		return nil, nil, fmt.Errorf("alguma variável de ambiente de credencial Google está faltando")
+		return nil, nil, fmt.Errorf("alguma variável de ambiente de credencial Google está faltando (informe um .env com --credentials-from <arquivo>)")
 	}
 
 	config := &oauth2.Config{
@@ -46,33 +95,52 @@ func getCredentials() (*oauth2.Config, *oauth2.Token, error) {
 	tokenType := os.Getenv("GOOGLE_TOKEN_TYPE")
 	expiryStr := os.Getenv("GOOGLE_TOKEN_EXPIRY")
 
-	if accessToken == "" || refreshToken == "" || tokenType == "" || expiryStr == "" {
-		return nil, nil, fmt.Errorf("alguma variável de ambiente de token Google está faltando")
+	if accessToken == "" && refreshToken == "" {
+		return nil, nil, fmt.Errorf("alguma variável de ambiente de token Google está faltando (informe um .env com --credentials-from <arquivo>)")
 	}
-
-	expiry, err := time.Parse(time.RFC3339Nano, expiryStr)
-	if err != nil {
-		return nil, nil, fmt.Errorf("erro ao converter GOOGLE_TOKEN_EXPIRY: %w", err)
+	if tokenType == "" {
+		tokenType = "Bearer"
 	}
 
 	token := &oauth2.Token{
 		AccessToken:  accessToken,
 		TokenType:    tokenType,
 		RefreshToken: refreshToken,
-		Expiry:       expiry,
+	}
+
+	switch {
+	case accessToken == "":
+		// Só o refresh token: marca o token como expirado para que um access
+		// token seja obtido na primeira requisição.
+		token.Expiry = time.Now().Add(-time.Minute)
+	case expiryStr != "":
+		expiry, err := time.Parse(time.RFC3339Nano, expiryStr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("erro ao converter GOOGLE_TOKEN_EXPIRY: %w", err)
+		}
+		token.Expiry = expiry
+	}
+	if refreshToken == "" {
+		fmt.Fprintln(os.Stderr, "Warning: GOOGLE_REFRESH_TOKEN ausente; o access token não poderá ser renovado quando expirar.")
 	}
 
 	return config, token, nil
 }
 
-// getDriveService authenticates and returns a Drive service client.
+// getDriveService authenticates and returns a Drive service client. Refreshed
+// access tokens are saved back to the credentials .env.
 func getDriveService() (*drive.Service, error) {
+	return getDriveServiceContext(context.Background())
+}
+
+// getDriveServiceContext is getDriveService, refreshing tokens within ctx.
+func getDriveServiceContext(ctx context.Context) (*drive.Service, error) {
 	config, token, err := getCredentials()
 	if err != nil {
 		return nil, err
 	}
-	ctx := context.Background()
-	client := config.Client(ctx, token)
+	ts := &persistingTokenSource{src: config.TokenSource(ctx, token), last: token.AccessToken}
+	client := oauth2.NewClient(ctx, ts)
 	srv, err := drive.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
 		return nil, fmt.Errorf("unable to create Drive client: %w", err)
@@ -82,31 +150,28 @@ func getDriveService() (*drive.Service, error) {
 
 // getRepoPath returns the absolute path to the repo (where token.json is).
 func getRepoPath() (string, error) {
-	// Return the hardcoded path to the setup directory
-	return "/home/alice/setup", nil
+	return utils.SetupRoot()
 }
 
 // findOrCreateFolder finds (or creates) a folder by path in Google Drive.
 // pathParts should be like: []string{"linux", "backups"}
-func findOrCreateFolder(srv *drive.Service, pathParts []string) (string, error) {
-	parent := "root"
-	for _, part := range pathParts {
-		q := fmt.Sprintf("name = '%s' and mimeType = 'application/vnd.google-apps.folder' and '%s' in parents and trashed = false", part, parent)
-		r, err := srv.Files.List().Q(q).Fields("files(id, name)").Do()
-		if err != nil {
-			return "", fmt.Errorf("unable to search for folder '%s': %w", part, err)
-		}
-		if len(r.Files) > 0 {
-			parent = r.Files[0].Id
-			continue
-		}
+func findOrCreateFolder(ctx context.Context, srv *drive.Service, pathParts []string) (string, error) {
+	parent, missing, err := findFolder(ctx, srv, pathParts)
+	if err != nil {
+		return "", err
+	}
+	for _, part := range missing {
 		// Not found, create it
 		folder := &drive.File{
 			Name:     part,
 			MimeType: "application/vnd.google-apps.folder",
 			Parents:  []string{parent},
 		}
-		created, err := srv.Files.Create(folder).Fields("id").Do()
+		var created *drive.File
+		err := withRetry(ctx, driveRetryAttempts, func() (err error) {
+			created, err = srv.Files.Create(folder).Fields("id").Context(ctx).Do()
+			return err
+		})
 		if err != nil {
 			return "", fmt.Errorf("unable to create folder '%s': %w", part, err)
 		}
@@ -115,30 +180,156 @@ func findOrCreateFolder(srv *drive.Service, pathParts []string) (string, error)
 	return parent, nil
 }
 
-// GetLatestDriveBackup returns the name of the most recently modified .tar.xz file in linux/backups/
-func GetLatestDriveBackup() (string, error) {
+// findFolder resolves a folder path in Google Drive without creating anything. It
+// returns the id of the deepest existing folder and the path parts that are missing
+// below it (empty when the whole path exists).
+func findFolder(ctx context.Context, srv *drive.Service, pathParts []string) (string, []string, error) {
+	parent := "root"
+	for i, part := range pathParts {
+		q := fmt.Sprintf("name = '%s' and mimeType = 'application/vnd.google-apps.folder' and '%s' in parents and trashed = false", part, parent)
+		r, err := listDriveFiles(ctx, srv.Files.List().Q(q).Fields("files(id, name)"))
+		if err != nil {
+			return "", nil, fmt.Errorf("unable to search for folder '%s': %w", part, err)
+		}
+		if len(r.Files) == 0 {
+			return parent, pathParts[i:], nil
+		}
+		parent = r.Files[0].Id
+	}
+	return parent, nil, nil
+}
+
+// listDriveFiles runs the listing call within ctx, retrying transient failures.
+func listDriveFiles(ctx context.Context, call *drive.FilesListCall) (*drive.FileList, error) {
+	var r *drive.FileList
+	err := withRetry(ctx, driveRetryAttempts, func() (err error) {
+		r, err = call.Context(ctx).Do()
+		return err
+	})
+	return r, err
+}
+
+// UploadPlan describes what UploadToDrive would do for a file.
+type UploadPlan struct {
+	LocalPath string
+	DrivePath string
+	Size      int64
+	// MissingFolders are the folders that would be created, outermost first.
+	MissingFolders []string
+	// Replace is true when a file with the same name exists and would be replaced.
+	Replace bool
+}
+
+// PlanUploadToDrive resolves where UploadToDrive would store localPath, without
+// creating folders or transferring any bytes.
+func PlanUploadToDrive(localPath, drivePath string) (*UploadPlan, error) {
 	srv, err := getDriveService()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	parentId, err := findOrCreateFolder(srv, []string{"linux", "backups"})
+
+	drivePath = strings.TrimPrefix(drivePath, "/")
+	parts := strings.Split(drivePath, "/")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("drivePath must be at least linux/backups/filename")
+	}
+	plan := &UploadPlan{LocalPath: localPath, DrivePath: drivePath}
+	if info, err := os.Stat(localPath); err == nil {
+		plan.Size = info.Size()
+	}
+
+	parentId, missing, err := findFolder(context.Background(), srv, parts[:len(parts)-1])
+	if err != nil {
+		return nil, err
+	}
+	plan.MissingFolders = missing
+	if len(missing) > 0 {
+		return plan, nil
+	}
+
+	q := fmt.Sprintf("name = '%s' and '%s' in parents and trashed = false", parts[len(parts)-1], parentId)
+	r, err := srv.Files.List().Q(q).Fields("files(id)").Do()
+	if err != nil {
+		return nil, fmt.Errorf("unable to search for existing file: %w", err)
+	}
+	plan.Replace = len(r.Files) > 0
+	return plan, nil
+}
+
+// String renders the plan as a human readable description.
+func (p *UploadPlan) String() string {
+	action := "create"
+	if p.Replace {
+		action = "replace"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Would %s %s on Google Drive (%s from %s)", action, p.DrivePath, utils.FormatBytes(p.Size), p.LocalPath)
+	if len(p.MissingFolders) > 0 {
+		fmt.Fprintf(&b, "\nWould create missing folder(s): %s", strings.Join(p.MissingFolders, "/"))
+	}
+	return b.String()
+}
+
+// GetLatestDriveBackup returns the name of the most recently modified backup archive (.tar.xz or .tar.zst) in linux/backups/
+func GetLatestDriveBackup() (string, error) {
+	return GetLatestDriveBackupContext(context.Background())
+}
+
+// GetLatestDriveBackupContext is GetLatestDriveBackup, giving up when ctx is done.
+func GetLatestDriveBackupContext(ctx context.Context) (string, error) {
+	f, err := latestDriveBackupFile(ctx, driveBackupFolder(), "")
 	if err != nil {
 		return "", err
 	}
-	q := fmt.Sprintf("name contains '.tar.xz' and '%s' in parents and trashed = false", parentId)
-	r, err := srv.Files.List().Q(q).Fields("files(name, modifiedTime)").OrderBy("modifiedTime desc").Do()
+	return f.Name, nil
+}
+
+// latestDriveBackupFile returns the metadata (name, size, modifiedTime) of the most
+// recently modified backup archive in folder (e.g. linux/backups), restricted to tag
+// when not empty.
+func latestDriveBackupFile(ctx context.Context, folder []string, tag string) (*drive.File, error) {
+	srv, err := getDriveServiceContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	parentId, err := findOrCreateFolder(ctx, srv, folder)
+	if err != nil {
+		return nil, err
+	}
+	q := fmt.Sprintf("%s and not name contains '%s' and '%s' in parents and trashed = false", archiveNameQuery, manifestSidecarSuffix, parentId) + tagQuery(tag)
+	r, err := listDriveFiles(ctx, srv.Files.List().Q(q).Fields("files(name, size, modifiedTime)").OrderBy("modifiedTime desc"))
 	if err != nil {
-		return "", fmt.Errorf("unable to list backup files: %w", err)
+		return nil, fmt.Errorf("unable to list backup files: %w", err)
 	}
 	if len(r.Files) == 0 {
-		return "", fmt.Errorf("no .tar.xz backups found in Google Drive")
+		if tag != "" {
+			return nil, withKind(ErrNoBackupFound, fmt.Errorf("no backups tagged %q found in Google Drive", tag))
+		}
+		return nil, withKind(ErrNoBackupFound, fmt.Errorf("no backups found in Google Drive"))
 	}
-	return r.Files[0].Name, nil
+	return r.Files[0], nil
 }
 
 // UploadToDrive uploads a local file to Google Drive at /linux/backups/[filename].
 func UploadToDrive(localPath, drivePath string) error {
-	srv, err := getDriveService()
+	return UploadToDriveContext(context.Background(), localPath, drivePath)
+}
+
+// UploadToDriveContext is UploadToDrive, aborting the upload when ctx is done.
+func UploadToDriveContext(ctx context.Context, localPath, drivePath string) error {
+	return uploadToDrive(ctx, localPath, drivePath, nil, nil)
+}
+
+// UploadToDriveProgress is UploadToDrive, calling progress as the file is sent
+// with the bytes uploaded so far and the file size.
+func UploadToDriveProgress(localPath, drivePath string, progress func(done, total int64)) error {
+	return uploadToDrive(context.Background(), localPath, drivePath, nil, progress)
+}
+
+// uploadToDrive is UploadToDriveContext, also setting props as the file's
+// appProperties and reporting to progress when not nil.
+func uploadToDrive(ctx context.Context, localPath, drivePath string, props map[string]string, progress func(done, total int64)) error {
+	srv, err := getDriveServiceContext(ctx)
 	if err != nil {
 		return err
 	}
@@ -151,14 +342,14 @@ func UploadToDrive(localPath, drivePath string) error {
 	folderParts := parts[:len(parts)-1]
 	filename := parts[len(parts)-1]
 
-	parentId, err := findOrCreateFolder(srv, folderParts)
+	parentId, err := findOrCreateFolder(ctx, srv, folderParts)
 	if err != nil {
 		return err
 	}
 
 	// Check if file already exists (replace if so)
 	q := fmt.Sprintf("name = '%s' and '%s' in parents and trashed = false", filename, parentId)
-	r, err := srv.Files.List().Q(q).Fields("files(id)").Do()
+	r, err := listDriveFiles(ctx, srv.Files.List().Q(q).Fields("files(id)"))
 	if err != nil {
 		return fmt.Errorf("unable to search for existing file: %w", err)
 	}
@@ -172,19 +363,38 @@ func UploadToDrive(localPath, drivePath string) error {
 		return fmt.Errorf("unable to open local file: %w", err)
 	}
 	defer f.Close()
+	var media io.ReadSeeker = f
+	if progress != nil {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		media = &progressReader{r: f, total: info.Size(), progress: progress}
+	}
+	if TransferLimit > 0 {
+		media = newLimitedReader(ctx, media)
+	}
 
 	driveFile := &drive.File{
-		Name:    filename,
-		Parents: []string{parentId},
+		Name:          filename,
+		Parents:       []string{parentId},
+		AppProperties: props,
 	}
 
-	if fileId != "" {
-		// Update existing file
-		_, err = srv.Files.Update(fileId, driveFile).Media(f).Do()
-	} else {
+	err = withRetry(ctx, driveRetryAttempts, func() error {
+		// A retry sends the whole file again.
+		if _, err := media.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if fileId != "" {
+			// Update existing file
+			_, err := srv.Files.Update(fileId, driveFile).Media(media).Context(ctx).Do()
+			return err
+		}
 		// Create new file
-		_, err = srv.Files.Create(driveFile).Media(f).Do()
-	}
+		_, err := srv.Files.Create(driveFile).Media(media).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("unable to upload file: %w", err)
 	}
@@ -193,7 +403,13 @@ func UploadToDrive(localPath, drivePath string) error {
 
 // DownloadFromDrive downloads a file from Google Drive /linux/backups/[filename] to localPath.
 func DownloadFromDrive(drivePath, localPath string) error {
-	srv, err := getDriveService()
+	return DownloadFromDriveContext(context.Background(), drivePath, localPath)
+}
+
+// DownloadFromDriveContext is DownloadFromDrive, aborting the download when ctx
+// is done.
+func DownloadFromDriveContext(ctx context.Context, drivePath, localPath string) error {
+	srv, err := getDriveServiceContext(ctx)
 	if err != nil {
 		return err
 	}
@@ -206,23 +422,27 @@ func DownloadFromDrive(drivePath, localPath string) error {
 	folderParts := parts[:len(parts)-1]
 	filename := parts[len(parts)-1]
 
-	parentId, err := findOrCreateFolder(srv, folderParts)
+	parentId, err := findOrCreateFolder(ctx, srv, folderParts)
 	if err != nil {
 		return err
 	}
 
 	// Find the file
 	q := fmt.Sprintf("name = '%s' and '%s' in parents and trashed = false", filename, parentId)
-	r, err := srv.Files.List().Q(q).Fields("files(id)").Do()
+	r, err := listDriveFiles(ctx, srv.Files.List().Q(q).Fields("files(id, md5Checksum)"))
 	if err != nil {
 		return fmt.Errorf("unable to search for file: %w", err)
 	}
 	if len(r.Files) == 0 {
-		return fmt.Errorf("file not found in Google Drive: %s", drivePath)
+		return fmt.Errorf("%s: %w in Google Drive", drivePath, os.ErrNotExist)
 	}
 	fileId := r.Files[0].Id
 
-	resp, err := srv.Files.Get(fileId).Download()
+	var resp *http.Response
+	err = withRetry(ctx, driveRetryAttempts, func() (err error) {
+		resp, err = srv.Files.Get(fileId).Context(ctx).Download()
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("unable to download file: %w", err)
 	}
@@ -232,11 +452,26 @@ func DownloadFromDrive(drivePath, localPath string) error {
 	if err != nil {
 		return fmt.Errorf("unable to create local file: %w", err)
 	}
-	defer out.Close()
 
-	_, err = io.Copy(out, resp.Body)
+	var body io.Reader = resp.Body
+	if TransferLimit > 0 {
+		body = newLimitedReader(ctx, body)
+	}
+	_, err = io.Copy(out, body)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
+		os.Remove(localPath)
 		return fmt.Errorf("unable to save downloaded file: %w", err)
 	}
+
+	// Drive computes no checksum for some files (e.g. Google Docs); skip those.
+	if sum := r.Files[0].Md5Checksum; sum != "" {
+		if err := VerifyLocalChecksum(localPath, sum); err != nil {
+			os.Remove(localPath)
+			return fmt.Errorf("download of %s is corrupt: %w", drivePath, err)
+		}
+	}
 	return nil
 }
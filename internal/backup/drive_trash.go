@@ -0,0 +1,155 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// ListTrashedBackups returns the backups in the Drive backup folder that are in
+// the trash but not yet permanently deleted, most recently trashed first.
+func ListTrashedBackups() ([]*drive.File, error) {
+	srv, err := getDriveService()
+	if err != nil {
+		return nil, err
+	}
+	parentId, missing, err := findFolder(context.Background(), srv, driveBackupFolder())
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		return nil, nil
+	}
+	q := fmt.Sprintf("%s and '%s' in parents and trashed = true", archiveNameQuery, parentId)
+	r, err := srv.Files.List().Q(q).Fields("files(id, name, size, trashedTime)").OrderBy("modifiedTime desc").Do()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list trashed backups: %w", err)
+	}
+	return r.Files, nil
+}
+
+// UntrashBackup restores the trashed backup called name (and its manifest
+// sidecar, if trashed too) in the Drive backup folder.
+func UntrashBackup(name string) error {
+	files, err := ListTrashedBackups()
+	if err != nil {
+		return err
+	}
+	srv, err := getDriveService()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, f := range files {
+		if f.Name != name && f.Name != name+manifestSidecarSuffix {
+			continue
+		}
+		// Trashed is false by default, so it must be forced into the request.
+		update := &drive.File{Trashed: false, ForceSendFields: []string{"Trashed"}}
+		if _, err := srv.Files.Update(f.Id, update).Do(); err != nil {
+			return fmt.Errorf("unable to restore %s from the trash: %w", f.Name, err)
+		}
+		found = found || f.Name == name
+	}
+	if !found {
+		return fmt.Errorf("no trashed backup named %s in %s", name, DriveBackupDir)
+	}
+	return nil
+}
+
+// PruneDriveBackups moves to the trash every backup in the Drive backup folder
+// except the keep most recently modified ones and the backups those are
+// increments of, along with their manifest sidecars. It returns the names of the
+// trashed backups; nothing is touched when there are keep backups or fewer.
+// Kept increments whose base is already gone are reported on stderr. Trashed
+// backups can be brought back with UntrashBackup until Drive empties the trash.
+func PruneDriveBackups(keep int) (deleted []string, err error) {
+	if keep < 1 {
+		return nil, fmt.Errorf("invalid keep count %d: at least one backup must be kept", keep)
+	}
+	files, err := ListDriveBackups("")
+	if err != nil {
+		return nil, err
+	}
+	if len(files) <= keep {
+		return nil, nil
+	}
+	present := map[string]bool{}
+	for _, f := range files {
+		present[f.Name] = true
+	}
+	var kept []string
+	for _, f := range files[:keep] {
+		kept = append(kept, f.Name)
+	}
+	bases, broken, err := incrementalBases(kept, present, LoadArchiveManifest)
+	if err != nil {
+		return nil, fmt.Errorf("%w; nothing was trashed", err)
+	}
+	for _, msg := range broken {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", msg)
+	}
+	srv, err := getDriveService()
+	if err != nil {
+		return nil, err
+	}
+	parentId, _, err := findFolder(context.Background(), srv, driveBackupFolder())
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files[keep:] {
+		if bases[f.Name] {
+			fmt.Printf("Keeping %s: a kept incremental backup is based on it.\n", f.Name)
+			continue
+		}
+		if _, err := srv.Files.Update(f.Id, &drive.File{Trashed: true}).Do(); err != nil {
+			return deleted, fmt.Errorf("unable to trash %s: %w", f.Name, err)
+		}
+		deleted = append(deleted, f.Name)
+
+		q := fmt.Sprintf("name = '%s' and '%s' in parents and trashed = false", f.Name+manifestSidecarSuffix, parentId)
+		r, err := srv.Files.List().Q(q).Fields("files(id)").Do()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not look up the manifest sidecar of %s: %v\n", f.Name, err)
+			continue
+		}
+		for _, s := range r.Files {
+			if _, err := srv.Files.Update(s.Id, &drive.File{Trashed: true}).Do(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: could not trash the manifest sidecar of %s: %v\n", f.Name, err)
+			}
+		}
+	}
+	return deleted, nil
+}
+
+// incrementalBases returns the backups the kept ones are increments of, directly
+// or through other increments, reading manifests with load. An increment whose
+// base is not present can no longer be applied; broken describes each of them.
+func incrementalBases(kept []string, present map[string]bool, load func(name string) (*Manifest, error)) (bases map[string]bool, broken []string, err error) {
+	bases = map[string]bool{}
+	for _, top := range kept {
+		name := top
+		for depth := 0; ; depth++ {
+			m, err := load(name)
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not read the manifest of %s to find its incremental base: %w", name, err)
+			}
+			if m.Parent == "" || bases[m.Parent] {
+				break
+			}
+			if !present[m.Parent] {
+				broken = append(broken, fmt.Sprintf("kept backup %s cannot be applied: %s is an increment of %s, which is no longer in %s", top, name, m.Parent, DriveBackupDir))
+				break
+			}
+			if depth >= maxParentChain {
+				broken = append(broken, fmt.Sprintf("kept backup %s has more than %d chained incremental backups", top, maxParentChain))
+				break
+			}
+			bases[m.Parent] = true
+			name = m.Parent
+		}
+	}
+	return bases, broken, nil
+}
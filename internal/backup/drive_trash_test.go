@@ -0,0 +1,41 @@
+package backup
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIncrementalBases(t *testing.T) {
+	parents := map[string]string{
+		"inc3":   "inc2",
+		"inc2":   "full1",
+		"full1":  "",
+		"full0":  "",
+		"orphan": "gone",
+	}
+	load := func(name string) (*Manifest, error) {
+		parent, ok := parents[name]
+		if !ok {
+			return nil, fmt.Errorf("no manifest for %s", name)
+		}
+		return &Manifest{Archive: name, Parent: parent}, nil
+	}
+	present := map[string]bool{"inc3": true, "inc2": true, "full1": true, "full0": true, "orphan": true}
+
+	bases, broken, err := incrementalBases([]string{"inc3", "orphan"}, present, load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"inc2": true, "full1": true}; !reflect.DeepEqual(bases, want) {
+		t.Errorf("bases = %v, want %v", bases, want)
+	}
+	if len(broken) != 1 || !strings.Contains(broken[0], "orphan") || !strings.Contains(broken[0], "gone") {
+		t.Errorf("broken = %q, want one entry for orphan", broken)
+	}
+
+	if _, _, err := incrementalBases([]string{"unknown"}, present, load); err == nil {
+		t.Error("a kept backup without manifest did not fail")
+	}
+}
@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// encryptedExt is appended to the name of archives encrypted with age
+// ("home-alice-backup-20240102-150405.tar.xz.age").
+const encryptedExt = ".age"
+
+// AgeRecipientsFile returns the file listing the age recipients (public keys,
+// one per line) backups are encrypted to.
+func AgeRecipientsFile() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "setup", "age-recipients.txt"), nil
+}
+
+// loadAgeRecipients parses the recipients of AgeRecipientsFile.
+func loadAgeRecipients() ([]age.Recipient, error) {
+	path, err := AgeRecipientsFile()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read age recipients: %w", err)
+	}
+	defer f.Close()
+	recipients, err := age.ParseRecipients(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid age recipients in %s: %w", path, err)
+	}
+	return recipients, nil
+}
+
+// loadAgeIdentities parses the identity file named by AGE_IDENTITY.
+func loadAgeIdentities() ([]age.Identity, error) {
+	path := os.Getenv("AGE_IDENTITY")
+	if path == "" {
+		return nil, errors.New("the archive is encrypted; set AGE_IDENTITY to an age identity file")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read age identity: %w", err)
+	}
+	defer f.Close()
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid age identity in %s: %w", path, err)
+	}
+	return identities, nil
+}
+
+// isEncrypted reports whether the archive called name is encrypted with age.
+func isEncrypted(name string) bool {
+	return strings.HasSuffix(name, encryptedExt)
+}
+
+// encryptFile encrypts path to recipients as path+".age" and removes path,
+// returning the encrypted file's path.
+func encryptFile(path string, recipients []age.Recipient) (_ string, err error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	encPath := path + encryptedExt
+	out, err := os.OpenFile(encPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(encPath)
+		}
+	}()
+	ew, err := age.Encrypt(out, recipients...)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(ew, in); err != nil {
+		return "", err
+	}
+	if err := ew.Close(); err != nil {
+		return "", err
+	}
+	in.Close()
+	return encPath, os.Remove(path)
+}
+
+// decryptingReader returns r decrypted with the AGE_IDENTITY identities when the
+// archive called name is encrypted, and r itself otherwise.
+func decryptingReader(r io.Reader, name string) (io.Reader, error) {
+	if !isEncrypted(name) {
+		return r, nil
+	}
+	identities, err := loadAgeIdentities()
+	if err != nil {
+		return nil, err
+	}
+	dr, err := age.Decrypt(r, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt %s: %w", filepath.Base(name), err)
+	}
+	return dr, nil
+}
@@ -0,0 +1,52 @@
+package backup
+
+import "errors"
+
+// Kinds of failure of ApplyBackupWithOpts, to be matched with errors.Is. PlanApply
+// and DiffBackup, which fetch and extract backups the same way, return them too.
+var (
+	// ErrNoBackupFound means there is no backup to apply: the store (or, with
+	// NoDownload, the backups dir) holds none, or not the one named.
+	ErrNoBackupFound = errors.New("no backup found")
+	// ErrDownloadFailed means the archive or one of its incremental bases could
+	// not be downloaded from the store.
+	ErrDownloadFailed = errors.New("backup download failed")
+	// ErrExtractFailed means the archive or one of its incremental bases could
+	// not be extracted.
+	ErrExtractFailed = errors.New("backup extraction failed")
+	// ErrFileNotInBackup means the backup holds no entry for the path asked
+	// for (see RestoreSingleFile).
+	ErrFileNotInBackup = errors.New("file not in backup")
+	// ErrStepFailed means a backup step, or one of its hooks, failed; the error
+	// is a *StepError naming the step.
+	ErrStepFailed = errors.New("backup step failed")
+)
+
+// StepError is returned by ApplyBackupWithOpts when a backup step fails.
+type StepError struct {
+	Step string
+	Err  error
+}
+
+// Error returns the message of the underlying error, which names the step.
+func (e *StepError) Error() string { return e.Err.Error() }
+
+// Unwrap returns the underlying error.
+func (e *StepError) Unwrap() error { return e.Err }
+
+// Is makes every StepError match ErrStepFailed.
+func (e *StepError) Is(target error) bool { return target == ErrStepFailed }
+
+// kindError gives an error one of the kinds above without changing its message.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string   { return e.err.Error() }
+func (e *kindError) Unwrap() []error { return []error{e.err, e.kind} }
+
+// withKind returns err marked with kind for errors.Is, keeping its message.
+func withKind(kind, err error) error {
+	return &kindError{kind: kind, err: err}
+}
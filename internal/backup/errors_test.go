@@ -0,0 +1,98 @@
+package backup
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyErrorKinds(t *testing.T) {
+	setTestHome(t)
+	for _, v := range []string{"GOOGLE_CLIENT_ID", "GOOGLE_CLIENT_SECRET", "GOOGLE_AUTH_URI", "GOOGLE_TOKEN_URI", "GOOGLE_REDIRECT_URIS", "GOOGLE_ACCESS_TOKEN", "GOOGLE_REFRESH_TOKEN"} {
+		t.Setenv(v, "")
+	}
+	t.Setenv("BACKUP_STORE", "drive")
+	old := CredentialsEnvFile
+	CredentialsEnvFile = ""
+	t.Cleanup(func() { CredentialsEnvFile = old })
+
+	corrupt := filepath.Join(t.TempDir(), "corrupt.tar.xz")
+	writeTestFile(t, corrupt, "not an archive")
+
+	for _, tt := range []struct {
+		name       string
+		backupFile string
+		opts       ApplyBackupOpts
+		kind       error
+	}{
+		{"missing local backup", "home-x-backup-20240102-150405.tar.xz", ApplyBackupOpts{NoDownload: true}, ErrNoBackupFound},
+		{"download without credentials", "home-x-backup-20240102-150405.tar.xz", ApplyBackupOpts{}, ErrDownloadFailed},
+		{"corrupt archive", corrupt, ApplyBackupOpts{NoDownload: true}, ErrExtractFailed},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ApplyBackupWithOpts(tt.backupFile, tt.opts)
+			if !errors.Is(err, tt.kind) {
+				t.Fatalf("err = %v, want %v", err, tt.kind)
+			}
+			if err.Error() == tt.kind.Error() {
+				t.Errorf("message %q lost its details", err)
+			}
+			var stepErr *StepError
+			if errors.As(err, &stepErr) || errors.Is(err, ErrStepFailed) {
+				t.Errorf("%v reported as a step failure", err)
+			}
+		})
+	}
+}
+
+func TestFetchArchiveErrorKinds(t *testing.T) {
+	store := &fakeStore{files: map[string]string{}}
+	failing := failingStore{store}
+	for _, tt := range []struct {
+		name  string
+		store BackupStore
+		kind  error
+	}{
+		{"named backup not in store", store, ErrNoBackupFound},
+		{"store failure", failing, ErrDownloadFailed},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := fetchArchive(tt.store, t.TempDir(), "home-x-backup-20240102-150405.tar.xz", false)
+			if !errors.Is(err, tt.kind) {
+				t.Fatalf("err = %v, want %v", err, tt.kind)
+			}
+		})
+	}
+}
+
+// failingStore is a fakeStore whose downloads fail for another reason than a
+// missing file.
+type failingStore struct{ *fakeStore }
+
+func (failingStore) Download(remotePath, localPath string) error {
+	return errors.New("connection reset")
+}
+
+func TestApplyStepErrorNamesStep(t *testing.T) {
+	ht := newHookTest(t)
+	// A directory where the file goes cannot be saved for rollback nor replaced.
+	if err := os.Remove(ht.target); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(ht.target, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	err := ht.apply(nil)
+	var stepErr *StepError
+	if !errors.As(err, &stepErr) {
+		t.Fatalf("err = %v, want a *StepError", err)
+	}
+	if stepErr.Step != "before clone" {
+		t.Errorf("failed step = %q, want before clone", stepErr.Step)
+	}
+	if !errors.Is(err, ErrStepFailed) {
+		t.Errorf("%v does not match ErrStepFailed", err)
+	}
+}
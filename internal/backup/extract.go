@@ -0,0 +1,270 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"setup/shared/utils"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// TarTimeout bounds every tar invocation; a stuck filesystem makes the tar
+// process get killed instead of hanging the run.
+var TarTimeout = time.Hour
+
+var (
+	// ErrArchiveTruncated means the archive ends prematurely, usually after an
+	// interrupted download.
+	ErrArchiveTruncated = errors.New("archive is truncated (incomplete download?)")
+	// ErrArchiveFormat means the file is not a .tar.xz/.tar.zst archive at all.
+	ErrArchiveFormat = errors.New("file is not a valid backup archive")
+	// ErrArchiveCorrupt means the archive data fails integrity checks.
+	ErrArchiveCorrupt = errors.New("archive data is corrupt")
+)
+
+// extractArchive extracts a .tar.xz or .tar.zst archive, told apart by its
+// extension, to the destination directory. Entries
+// with absolute paths or ".." components, hard links to paths outside destDir and
+// entries below a symlink are rejected with ErrUnsafePath. Read failures are
+// translated into ErrArchiveTruncated, ErrArchiveFormat or ErrArchiveCorrupt.
+func extractArchive(archivePath, destDir string) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := decryptingReader(bufio.NewReader(f), archivePath)
+	if err != nil {
+		return err
+	}
+	dr, release, err := newDecompressor(r, archivePath)
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("%w: %v", ErrArchiveTruncated, err)
+		}
+		return fmt.Errorf("%w: %v", ErrArchiveFormat, err)
+	}
+	defer release()
+	deadline := time.Now().Add(TarTimeout)
+	tr := tar.NewReader(dr)
+	for entries := 0; ; entries++ {
+		if TarTimeout > 0 && time.Now().After(deadline) {
+			return fmt.Errorf("extracting %s: %w after %s", filepath.Base(archivePath), utils.ErrCommandTimeout, TarTimeout)
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return classifyReadError(err, entries == 0)
+		}
+		if err := extractEntry(tr, hdr, destDir); err != nil {
+			if errors.Is(err, ErrUnsafePath) || errors.Is(err, os.ErrPermission) {
+				return err
+			}
+			var pathErr *os.PathError
+			if errors.As(err, &pathErr) {
+				return err
+			}
+			return classifyReadError(err, false)
+		}
+	}
+}
+
+// ErrUnsafePath means an archive entry would be written outside the extraction
+// directory.
+var ErrUnsafePath = errors.New("archive entry escapes the extraction directory")
+
+// extractEntry writes the entry hdr, whose data tr is positioned at, below destDir.
+func extractEntry(tr *tar.Reader, hdr *tar.Header, destDir string) error {
+	target, err := safeJoin(destDir, hdr.Name)
+	if err != nil {
+		return err
+	}
+	if target == filepath.Clean(destDir) {
+		return nil
+	}
+	if err := checkNoSymlinkParents(destDir, target); err != nil {
+		return err
+	}
+	mode := hdr.FileInfo().Mode()
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(target, mode.Perm()|0o700)
+	case tar.TypeReg, tar.TypeGNUSparse:
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		// Never write through a symlink an earlier entry created at target.
+		if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			if err := os.Remove(target); err != nil {
+				return err
+			}
+		}
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode.Perm())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		// Special bits are dropped by the umask on create; set them explicitly.
+		if err := os.Chmod(target, mode); err != nil {
+			return err
+		}
+		return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+	case tar.TypeSymlink:
+		// Links are kept as data whatever they point to; extraction never
+		// follows them (see checkNoSymlinkParents).
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		_ = os.Remove(target)
+		return os.Symlink(hdr.Linkname, target)
+	case tar.TypeLink:
+		source, err := safeJoin(destDir, hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		_ = os.Remove(target)
+		return os.Link(source, target)
+	}
+	// Devices, FIFOs and other special entries are never part of a backup.
+	return nil
+}
+
+// safeJoin returns name joined to dir, failing with ErrUnsafePath for absolute
+// names and names containing ".." components.
+func safeJoin(dir, name string) (string, error) {
+	slashed := filepath.ToSlash(name)
+	if strings.HasPrefix(slashed, "/") || filepath.IsAbs(name) {
+		return "", fmt.Errorf("%w: absolute path %s", ErrUnsafePath, name)
+	}
+	for _, part := range strings.Split(slashed, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("%w: %s", ErrUnsafePath, name)
+		}
+	}
+	target := filepath.Join(dir, filepath.FromSlash(slashed))
+	if !withinDir(dir, target) {
+		return "", fmt.Errorf("%w: %s", ErrUnsafePath, name)
+	}
+	return target, nil
+}
+
+// withinDir reports whether path is dir or below it.
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
+// checkNoSymlinkParents fails when a directory between destDir and target is a
+// symlink, which an earlier entry could have planted to redirect later writes.
+func checkNoSymlinkParents(destDir, target string) error {
+	rel, err := filepath.Rel(destDir, filepath.Dir(target))
+	if err != nil || rel == "." {
+		return err
+	}
+	path := destDir
+	for _, part := range strings.Split(rel, string(os.PathSeparator)) {
+		path = filepath.Join(path, part)
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s is below the symlink %s", ErrUnsafePath, target, path)
+		}
+	}
+	return nil
+}
+
+// classifyReadError maps an error reading the compressed tar stream to one of
+// the archive errors. atStart tells whether no entry was read yet, where a bad
+// header means the file is not a tar archive at all.
+func classifyReadError(err error, atStart bool) error {
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return fmt.Errorf("%w: %v", ErrArchiveTruncated, err)
+	case (errors.Is(err, tar.ErrHeader) || errors.Is(err, zstd.ErrMagicMismatch)) && atStart:
+		return fmt.Errorf("%w: %v", ErrArchiveFormat, err)
+	}
+	return fmt.Errorf("%w: %v", ErrArchiveCorrupt, err)
+}
+
+// isCorruption reports whether err indicates a damaged (rather than wrong) archive,
+// which a fresh download may fix.
+func isCorruption(err error) bool {
+	return errors.Is(err, ErrArchiveTruncated) || errors.Is(err, ErrArchiveCorrupt)
+}
+
+// verifyAgainstIndex compares the archive with the checksum recorded in the local
+// index when it was created. Archives unknown to the index are accepted.
+func verifyAgainstIndex(backupsDir, archivePath string) error {
+	entries, err := loadBackupIndex(backupsDir)
+	if err != nil {
+		return nil
+	}
+	name := filepath.Base(archivePath)
+	for _, e := range entries {
+		if e.Name != name || e.SHA256 == "" {
+			continue
+		}
+		sum, err := hashFile(archivePath)
+		if err != nil {
+			return err
+		}
+		if sum != e.SHA256 {
+			return fmt.Errorf("%w: checksum mismatch: expected %s got %s", ErrArchiveCorrupt, e.SHA256, sum)
+		}
+	}
+	return nil
+}
+
+// extractWithRetry verifies and extracts archivePath into destDir. When the archive
+// is truncated or corrupt, redownload is called once and extraction is retried.
+func extractWithRetry(backupsDir, archivePath, destDir string, redownload func() error) error {
+	extract := func() error {
+		if err := verifyAgainstIndex(backupsDir, archivePath); err != nil {
+			return err
+		}
+		return extractArchive(archivePath, destDir)
+	}
+
+	err := extract()
+	if err == nil || !isCorruption(err) || redownload == nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Archive looks damaged (%v); downloading it again...\n", err)
+	if err := redownload(); err != nil {
+		return withKind(ErrDownloadFailed, fmt.Errorf("re-download failed: %w", err))
+	}
+	// Start from a clean destination so leftovers of the failed attempt are not applied.
+	if err := os.RemoveAll(destDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		return err
+	}
+	return extract()
+}
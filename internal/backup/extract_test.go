@@ -0,0 +1,133 @@
+package backup
+
+import (
+	"archive/tar"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testEntry is an entry of an archive made by writeTestArchive.
+type testEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	body     string
+}
+
+// writeTestArchive writes a .tar.xz archive of entries to path, as crafted as
+// the entries are.
+func writeTestArchive(t *testing.T, path string, entries []testEntry) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw, err := newCompressor(f, FormatXz, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(zw)
+	for _, e := range entries {
+		typeflag := e.typeflag
+		if typeflag == 0 {
+			typeflag = tar.TypeReg
+		}
+		hdr := &tar.Header{Name: e.name, Typeflag: typeflag, Linkname: e.linkname, Mode: 0o644, Size: int64(len(e.body))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractArchiveRejectsUnsafeEntries(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		entries []testEntry
+		// escaped is a path below the parent of the extraction dir that must
+		// not be created or changed.
+		escaped string
+	}{
+		{
+			name:    "dot-dot entry",
+			entries: []testEntry{{name: "./../evil", body: "evil"}},
+			escaped: "evil",
+		},
+		{
+			name:    "absolute name",
+			entries: []testEntry{{name: "/tmp/evil", body: "evil"}},
+		},
+		{
+			name: "write through symlinked dir",
+			entries: []testEntry{
+				{name: "./link", typeflag: tar.TypeSymlink, linkname: "../outside"},
+				{name: "./link/secret", body: "evil"},
+			},
+			escaped: "outside/secret",
+		},
+		{
+			name: "hard link outside",
+			entries: []testEntry{
+				{name: "./stolen", typeflag: tar.TypeLink, linkname: "../outside/secret"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeTestFile(t, filepath.Join(root, "outside", "secret"), "secret")
+			dest := filepath.Join(root, "dest")
+			if err := os.Mkdir(dest, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			archive := filepath.Join(root, "crafted.tar.xz")
+			writeTestArchive(t, archive, tc.entries)
+
+			if err := extractArchive(archive, dest); !errors.Is(err, ErrUnsafePath) {
+				t.Fatalf("extractArchive = %v, want ErrUnsafePath", err)
+			}
+			if tc.escaped != "" {
+				data, err := os.ReadFile(filepath.Join(root, tc.escaped))
+				if err == nil && string(data) == "evil" {
+					t.Errorf("%s was written outside the extraction dir", tc.escaped)
+				}
+			}
+			if _, err := os.Lstat(filepath.Join(dest, "stolen")); err == nil {
+				t.Error("hard link to a file outside the extraction dir was created")
+			}
+		})
+	}
+}
+
+func TestExtractArchiveReplacesSymlinkInsteadOfWritingThroughIt(t *testing.T) {
+	root := t.TempDir()
+	outside := filepath.Join(root, "outside")
+	writeTestFile(t, outside, "secret")
+	dest := filepath.Join(root, "dest")
+	archive := filepath.Join(root, "crafted.tar.xz")
+	writeTestArchive(t, archive, []testEntry{
+		{name: "./file", typeflag: tar.TypeSymlink, linkname: outside},
+		{name: "./file", body: "archived"},
+	})
+
+	if err := extractArchive(archive, dest); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(outside); string(data) != "secret" {
+		t.Errorf("the symlink target was overwritten with %q", data)
+	}
+	info, err := os.Lstat(filepath.Join(dest, "file"))
+	if err != nil || !info.Mode().IsRegular() {
+		t.Errorf("file is not a regular file after extraction (err %v)", err)
+	}
+}
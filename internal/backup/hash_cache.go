@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"setup/shared/utils"
+)
+
+// hashCacheFileName is the checksum cache kept in the state dir (see utils.StateDir).
+const hashCacheFileName = "hash-cache.json"
+
+// hashCacheEntry is the checksum of a file as it was at Size and ModTime.
+type hashCacheEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	SHA256  string    `json:"sha256"`
+}
+
+// hashCache maps absolute paths to their last known checksum. It is loaded on
+// first use and written back by saveHashCache.
+var hashCache struct {
+	sync.Mutex
+	loaded  bool
+	dirty   bool
+	entries map[string]hashCacheEntry
+}
+
+// cachedHash returns the cached checksum of path if info still matches it.
+func cachedHash(path string, info os.FileInfo) (string, bool) {
+	hashCache.Lock()
+	defer hashCache.Unlock()
+	loadHashCacheLocked()
+	e, ok := hashCache.entries[path]
+	if !ok || e.Size != info.Size() || !e.ModTime.Equal(info.ModTime()) {
+		return "", false
+	}
+	return e.SHA256, true
+}
+
+// storeHash records sum as the checksum of path in the state described by info.
+func storeHash(path string, info os.FileInfo, sum string) {
+	hashCache.Lock()
+	defer hashCache.Unlock()
+	loadHashCacheLocked()
+	hashCache.entries[path] = hashCacheEntry{Size: info.Size(), ModTime: info.ModTime(), SHA256: sum}
+	hashCache.dirty = true
+}
+
+// loadHashCacheLocked reads the persisted cache once. A missing or unreadable
+// cache starts empty.
+func loadHashCacheLocked() {
+	if hashCache.loaded {
+		return
+	}
+	hashCache.loaded = true
+	hashCache.entries = map[string]hashCacheEntry{}
+	dir, err := utils.StateDir()
+	if err != nil {
+		return
+	}
+	data, err := os.ReadFile(filepath.Join(dir, hashCacheFileName))
+	if err != nil {
+		return
+	}
+	_ = json.Unmarshal(data, &hashCache.entries)
+}
+
+// saveHashCache persists the cache if it changed.
+func saveHashCache() error {
+	hashCache.Lock()
+	defer hashCache.Unlock()
+	if !hashCache.dirty {
+		return nil
+	}
+	dir, err := utils.StateDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("could not create state dir: %w", err)
+	}
+	data, err := json.Marshal(hashCache.entries)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, hashCacheFileName), data, 0o600); err != nil {
+		return fmt.Errorf("could not write checksum cache: %w", err)
+	}
+	hashCache.dirty = false
+	return nil
+}
+
+// hashStagedFile returns the checksum of the staged copy of the live file source.
+// Staging keeps modification times, so while source has the size and
+// modification time of its staged copy the two are taken to be the same file:
+// the checksum cached for source is used, or the fresh one is cached for it.
+// Copies extracted for apply or verify live in temp dirs and are never cached.
+func hashStagedFile(staged string, stagedInfo os.FileInfo, source string) (string, error) {
+	liveInfo, err := os.Stat(source)
+	same := err == nil && liveInfo.Size() == stagedInfo.Size() && liveInfo.ModTime().Equal(stagedInfo.ModTime())
+	if same {
+		if sum, ok := cachedHash(source, liveInfo); ok {
+			return sum, nil
+		}
+	}
+	sum, err := hashFile(staged)
+	if err != nil {
+		return "", err
+	}
+	if same {
+		storeHash(source, liveInfo, sum)
+	}
+	return sum, nil
+}
@@ -0,0 +1,70 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"setup/shared/utils"
+)
+
+// resetHashCache forgets the in-memory checksum cache, before and after the test.
+func resetHashCache(t *testing.T) {
+	reset := func() {
+		hashCache.Lock()
+		hashCache.loaded, hashCache.dirty, hashCache.entries = false, false, nil
+		hashCache.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestHashStagedFileUsesCache(t *testing.T) {
+	home := setTestHome(t)
+	resetHashCache(t)
+	live := filepath.Join(home, "live.txt")
+	staged := filepath.Join(home, "staging", "live.txt")
+	writeTestFile(t, live, "original\n")
+	if err := utils.CopyFile(live, staged); err != nil {
+		t.Fatal(err)
+	}
+	stagedInfo, err := os.Stat(staged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := hashFile(staged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := hashStagedFile(staged, stagedInfo, live); err != nil || got != want {
+		t.Fatalf("hashStagedFile = %q, %v; want %q", got, err, want)
+	}
+	if err := saveHashCache(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Same size and time, other contents: only a cache hit returns the old sum.
+	hashCache.Lock()
+	hashCache.loaded, hashCache.entries = false, nil
+	hashCache.Unlock()
+	writeTestFile(t, staged, "modified\n")
+	if err := os.Chtimes(staged, time.Time{}, stagedInfo.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := hashStagedFile(staged, stagedInfo, live); got != want {
+		t.Errorf("unchanged file was hashed again instead of using the persisted cache")
+	}
+
+	// A live file modified after staging is not taken from the cache.
+	if err := os.Chtimes(live, time.Time{}, stagedInfo.ModTime().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := hashFile(staged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := hashStagedFile(staged, stagedInfo, live); got != fresh {
+		t.Errorf("hashStagedFile = %q after the live file changed, want %q", got, fresh)
+	}
+}
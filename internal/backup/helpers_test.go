@@ -0,0 +1,65 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setTestHome points HOME, the XDG directories and SETUP_ROOT (home/setup) at a
+// fresh temp dir and returns it.
+func setTestHome(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	useTestHome(t, home)
+	return home
+}
+
+// setApplyTestHome is setTestHome for tests applying backups. Apply never
+// restores the top-level tmp/ of an archive (see walkBackupTree), so the home
+// is made outside /tmp, in /var/tmp when the temp dir is below /tmp.
+func setApplyTestHome(t *testing.T) string {
+	t.Helper()
+	dir := os.TempDir()
+	if first, _, _ := strings.Cut(filepath.ToSlash(trimLeadingSlash(dir)), "/"); first == "tmp" {
+		dir = filepath.Join(string(os.PathSeparator), "var", "tmp")
+	}
+	home, err := os.MkdirTemp(dir, "setup-test-")
+	if err != nil {
+		t.Skipf("no temp dir outside /tmp: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(home) })
+	useTestHome(t, home)
+	return home
+}
+
+// useTestHome points HOME, the XDG directories and SETUP_ROOT at home.
+func useTestHome(t *testing.T, home string) {
+	t.Helper()
+	t.Setenv("HOME", home)
+	for _, v := range []string{"XDG_CONFIG_HOME", "XDG_CACHE_HOME", "XDG_DATA_HOME", "XDG_STATE_HOME"} {
+		t.Setenv(v, "")
+	}
+	t.Setenv("SETUP_ROOT", filepath.Join(home, "setup"))
+}
+
+// writeTestFile writes data to path, creating its parent directories.
+func writeTestFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// registerTestSet registers set for the duration of the test.
+func registerTestSet(t *testing.T, set BackupSet) {
+	t.Helper()
+	if err := RegisterBackupSet(set); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { delete(backupSets, strings.ToLower(set.Name)) })
+}
@@ -0,0 +1,79 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"setup/shared/utils"
+)
+
+// Hook directories below HooksDir. Apply runs the executables of the first
+// before restoring anything and those of the second once every step ran.
+const (
+	preApplyHooks  = "pre-apply.d"
+	postApplyHooks = "post-apply.d"
+)
+
+// HooksDir returns the directory holding the apply hooks: hooks below
+// utils.SetupRoot (~/setup/hooks by default).
+func HooksDir() (string, error) {
+	root, err := utils.SetupRoot()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "hooks"), nil
+}
+
+// runHooks runs the executable files of dir in filename order, with env added
+// to the environment and the output passed through, stopping at the first one
+// that fails. A missing dir has no hooks; hidden and non-executable files are
+// skipped.
+func runHooks(dir string, env []string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read hooks: %w", err)
+	}
+	// ReadDir returns the entries sorted by filename.
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+			continue
+		}
+		fmt.Printf("Running hook %s\n", path)
+		cmd := exec.Command(path)
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("hook %s failed: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// applyHookEnv returns the environment of the apply hooks: the dir the backup
+// is extracted in and the names of the steps, comma separated like --steps.
+func applyHookEnv(tmpDir string, steps []BackupStep) []string {
+	names := make([]string, 0, len(steps))
+	for _, step := range steps {
+		names = append(names, step.Name)
+	}
+	return []string{
+		"SETUP_TMP_DIR=" + tmpDir,
+		"SETUP_APPLIED_STEPS=" + strings.Join(names, ","),
+	}
+}
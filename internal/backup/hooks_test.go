@@ -0,0 +1,162 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// hookTest is a backup of ~/.hooktest ("backup") whose live copy was changed
+// since ("local"), with a log the hooks of the test append to.
+type hookTest struct {
+	home, archive, target, log string
+}
+
+func newHookTest(t *testing.T) hookTest {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are shell scripts")
+	}
+	home := setApplyTestHome(t)
+	ht := hookTest{home: home, target: filepath.Join(home, ".hooktest"), log: filepath.Join(t.TempDir(), "hooks.log")}
+	writeTestFile(t, ht.target, "backup\n")
+	registerTestSet(t, BackupSet{Name: "hooktest", FilesAdd: []FileAdd{{Path: "~/.hooktest", Update: true}}})
+	archive, err := CreateBackupWithOpts(CreateBackupOpts{Sets: []string{"hooktest"}, LocalOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ht.archive = archive
+	writeTestFile(t, ht.target, "local\n")
+	return ht
+}
+
+// writeHook installs an executable hook called name in the hook directory dir
+// (preApplyHooks or postApplyHooks) that logs label and the contents of the
+// target, then exits with code.
+func (ht hookTest) writeHook(t *testing.T, dir, name, label string, code int) {
+	t.Helper()
+	hooksDir, err := HooksDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := fmt.Sprintf("#!/bin/sh\necho \"%s $(cat %s)\" >> %s\nexit %d\n", label, ht.target, ht.log, code)
+	path := filepath.Join(hooksDir, dir, name)
+	writeTestFile(t, path, script)
+	if err := os.Chmod(path, 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// logLine returns a func appending label and the contents of the target to the
+// log, for step hooks; it fails with err.
+func (ht hookTest) logLine(t *testing.T, label string, err error) func() error {
+	return func() error {
+		data, rerr := os.ReadFile(ht.target)
+		if rerr != nil {
+			t.Error(rerr)
+		}
+		f, ferr := os.OpenFile(ht.log, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if ferr != nil {
+			t.Fatal(ferr)
+		}
+		defer f.Close()
+		fmt.Fprintf(f, "%s %s", label, data)
+		return err
+	}
+}
+
+func (ht hookTest) readLog(t *testing.T) []string {
+	t.Helper()
+	data, err := os.ReadFile(ht.log)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func (ht hookTest) apply(configure func(step *BackupStep)) error {
+	return ApplyBackupWithOpts(ht.archive, ApplyBackupOpts{NoDownload: true, Steps: []string{"before clone"}, ConfigureStep: configure})
+}
+
+// assertNotApplied checks the target kept its local contents and no extracted
+// backup was left behind.
+func (ht hookTest) assertNotApplied(t *testing.T) {
+	t.Helper()
+	if data, _ := os.ReadFile(ht.target); string(data) != "local\n" {
+		t.Errorf("target = %q, want it untouched", data)
+	}
+	backupsDir, err := localBackupsDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(backupsDir, "tmp")); !os.IsNotExist(err) {
+		t.Errorf("extracted backup left in %s: %v", backupsDir, err)
+	}
+}
+
+func TestApplyHookOrder(t *testing.T) {
+	ht := newHookTest(t)
+	ht.writeHook(t, preApplyHooks, "20-second", "pre-apply-2", 0)
+	ht.writeHook(t, preApplyHooks, "10-first", "pre-apply-1", 0)
+	ht.writeHook(t, postApplyHooks, "10-post", "post-apply", 0)
+
+	err := ht.apply(func(step *BackupStep) {
+		step.PreHook = ht.logLine(t, "pre-hook", nil)
+		step.PostHook = ht.logLine(t, "post-hook", nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"pre-apply-1 local",
+		"pre-apply-2 local",
+		"pre-hook local",
+		"post-hook backup",
+		"post-apply backup",
+	}
+	if got := ht.readLog(t); !slices.Equal(got, want) {
+		t.Errorf("hooks ran as\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestApplyPreApplyHookFailureAppliesNothing(t *testing.T) {
+	ht := newHookTest(t)
+	ht.writeHook(t, preApplyHooks, "10-fail", "pre-apply", 1)
+	ht.writeHook(t, preApplyHooks, "20-never", "never", 0)
+
+	err := ht.apply(func(step *BackupStep) {
+		step.PreHook = ht.logLine(t, "pre-hook", nil)
+	})
+	if err == nil || !strings.Contains(err.Error(), "nothing was applied") {
+		t.Fatalf("err = %v, want the pre-apply failure", err)
+	}
+	if got := ht.readLog(t); !slices.Equal(got, []string{"pre-apply local"}) {
+		t.Errorf("hooks ran as %q, want only the failing one", got)
+	}
+	ht.assertNotApplied(t)
+}
+
+func TestApplyStepPreHookFailureSkipsStep(t *testing.T) {
+	ht := newHookTest(t)
+	errHook := errors.New("hook refused")
+	err := ht.apply(func(step *BackupStep) {
+		step.PreHook = ht.logLine(t, "pre-hook", errHook)
+		step.PostHook = ht.logLine(t, "post-hook", nil)
+	})
+	var stepErr *StepError
+	if !errors.As(err, &stepErr) || stepErr.Step != "before clone" || !errors.Is(err, errHook) {
+		t.Fatalf("err = %v, want a StepError of 'before clone' wrapping the hook's", err)
+	}
+	if got := ht.readLog(t); !slices.Equal(got, []string{"pre-hook local"}) {
+		t.Errorf("hooks ran as %q, want only the pre-hook", got)
+	}
+	ht.assertNotApplied(t)
+}
@@ -0,0 +1,186 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// previousManifest returns the name and manifest of the most recent backup, looking
+// first at the local index (and its manifest sidecar) and then at the backup store.
+func previousManifest(backupsDir string) (string, *Manifest, error) {
+	if entry, err := latestIndexEntry(backupsDir); err == nil && entry != nil {
+		if m, err := readManifestFile(manifestSidecarPath(backupsDir, entry.Name)); err == nil {
+			return entry.Name, m, nil
+		}
+	}
+
+	store, err := SelectStore()
+	if err != nil {
+		return "", nil, err
+	}
+	name, err := store.Latest(DriveBackupDir)
+	if err != nil {
+		return "", nil, err
+	}
+	localPath := filepath.Join(backupsDir, name)
+	if _, err := os.Stat(localPath); err != nil {
+		if err := store.Download(driveBackupPath(name), localPath); err != nil {
+			return "", nil, fmt.Errorf("failed to download previous backup: %w", err)
+		}
+	}
+	m, err := extractManifest(localPath)
+	if err != nil {
+		return "", nil, err
+	}
+	return name, m, nil
+}
+
+// extractManifest reads the manifest stored inside a backup archive.
+func extractManifest(archivePath string) (*Manifest, error) {
+	dir, err := os.MkdirTemp("", "setup-manifest-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := extractArchiveEntry(archivePath, "./"+manifestFileName, dir); err != nil {
+		return nil, fmt.Errorf("archive %s has no readable manifest: %w", filepath.Base(archivePath), err)
+	}
+	return ReadManifest(dir)
+}
+
+// extractArchiveEntry extracts only the entry called name of the archive into
+// destDir, stopping at it. A missing entry fails with ErrFileNotInBackup.
+func extractArchiveEntry(archivePath, name, destDir string) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := decryptingReader(bufio.NewReader(f), archivePath)
+	if err != nil {
+		return err
+	}
+	dr, release, err := newDecompressor(r, archivePath)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrArchiveFormat, err)
+	}
+	defer release()
+	tr := tar.NewReader(dr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return withKind(ErrFileNotInBackup, fmt.Errorf("%s not found", name))
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Name == name {
+			return extractEntry(tr, hdr, destDir)
+		}
+	}
+}
+
+// unchangedSinceLast reports whether m lists exactly the same files with the same
+// checksums as the most recent backup in the local index, returning its name.
+func unchangedSinceLast(backupsDir string, m *Manifest) (string, bool) {
+	entry, err := latestIndexEntry(backupsDir)
+	if err != nil || entry == nil {
+		return "", false
+	}
+	last, err := readManifestFile(manifestSidecarPath(backupsDir, entry.Name))
+	if err != nil || len(last.Files) != len(m.Files) {
+		return "", false
+	}
+	for _, f := range m.Files {
+		prev, ok := last.find(f.Path)
+		if !ok || prev.SHA256 != f.SHA256 {
+			return "", false
+		}
+	}
+	return entry.Name, true
+}
+
+// pruneUnchanged removes from stagingDir every file whose checksum matches the
+// parent manifest, marking it as inherited in m. It returns the number of files
+// left in the increment.
+func pruneUnchanged(stagingDir string, m *Manifest, parentName string, parent *Manifest) (int, error) {
+	changed := 0
+	for i, f := range m.Files {
+		prev, ok := parent.find(f.Path)
+		if !ok || prev.SHA256 != f.SHA256 {
+			changed++
+			continue
+		}
+		if err := os.Remove(filepath.Join(stagingDir, filepath.FromSlash(f.Path))); err != nil {
+			return 0, fmt.Errorf("could not drop unchanged file %s: %w", f.Path, err)
+		}
+		m.Files[i].FromParent = true
+	}
+	m.Parent = parentName
+	return changed, nil
+}
+
+// maxParentChain bounds how many increments apply follows back to a full backup.
+const maxParentChain = 32
+
+// restoreInherited completes the increment extracted into dir with the files m
+// inherits from its parent (see ManifestFile.FromParent). The parent is
+// downloaded (or, with noDownload, taken from backupsDir), extracted and
+// verified next to dir, completing it from its own parent first when it is an
+// increment too.
+func restoreInherited(store BackupStore, backupsDir, dir string, m *Manifest, noDownload bool, depth int) error {
+	if m == nil || m.Parent == "" || !slices.ContainsFunc(m.Files, func(f ManifestFile) bool { return f.FromParent }) {
+		return nil
+	}
+	if depth >= maxParentChain {
+		return fmt.Errorf("more than %d chained incremental backups", maxParentChain)
+	}
+	fmt.Printf("Fetching base backup %s...\n", m.Parent)
+	localPath, download, err := fetchArchive(store, backupsDir, m.Parent, noDownload)
+	if err != nil {
+		return err
+	}
+	parentDir, err := os.MkdirTemp(backupsDir, "base-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(parentDir)
+	if err := extractWithRetry(backupsDir, localPath, parentDir, download); err != nil {
+		return withKind(ErrExtractFailed, fmt.Errorf("could not extract base backup %s: %w", m.Parent, err))
+	}
+	parent, err := ReadManifest(parentDir)
+	if err != nil {
+		return fmt.Errorf("base backup %s has no readable manifest: %w", m.Parent, err)
+	}
+	if err := verifyExtracted(parentDir, parent); err != nil {
+		return fmt.Errorf("base backup %s failed verification: %w", m.Parent, err)
+	}
+	if err := restoreInherited(store, backupsDir, parentDir, parent, noDownload, depth+1); err != nil {
+		return err
+	}
+
+	return filepath.Walk(parentDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(parentDir, path)
+		if err != nil {
+			return err
+		}
+		if f, ok := m.find(filepath.ToSlash(rel)); !ok || !f.FromParent {
+			return nil
+		}
+		target := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		return os.Rename(path, target)
+	})
+}
@@ -0,0 +1,85 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"setup/shared/utils"
+)
+
+// indexFileName is the name of the local backup index kept in the backups directory.
+const indexFileName = "index.json"
+
+// BackupIndexEntry records a backup archive created on this machine.
+type BackupIndexEntry struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+	// SHA256 is the checksum of the archive, used to detect corrupt copies before extraction.
+	SHA256 string `json:"sha256,omitempty"`
+	// Tags are the labels the backup was created with.
+	Tags []string `json:"tags,omitempty"`
+}
+
+// localBackupsDir returns the directory archives, their sidecars and the index
+// are kept in: the backups dir below utils.SetupRoot.
+func localBackupsDir() (string, error) {
+	root, err := utils.SetupRoot()
+	if err != nil {
+		return "", fmt.Errorf("could not determine the setup root: %w", err)
+	}
+	return filepath.Join(root, "backups"), nil
+}
+
+// loadBackupIndex reads the local backup index from backupsDir. A missing index
+// is not an error and yields an empty list.
+func loadBackupIndex(backupsDir string) ([]BackupIndexEntry, error) {
+	data, err := os.ReadFile(filepath.Join(backupsDir, indexFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read backup index: %w", err)
+	}
+	var entries []BackupIndexEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("could not parse backup index: %w", err)
+	}
+	return entries, nil
+}
+
+// appendBackupIndex adds entry to the local backup index in backupsDir.
+func appendBackupIndex(backupsDir string, entry BackupIndexEntry) error {
+	entries, err := loadBackupIndex(backupsDir)
+	if err != nil {
+		return err
+	}
+	entries = append(entries, entry)
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not serialize backup index: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(backupsDir, indexFileName), data, 0o644); err != nil {
+		return fmt.Errorf("could not write backup index: %w", err)
+	}
+	return nil
+}
+
+// latestIndexEntry returns the most recently created entry of the local index.
+func latestIndexEntry(backupsDir string) (*BackupIndexEntry, error) {
+	entries, err := loadBackupIndex(backupsDir)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	latest := entries[0]
+	for _, e := range entries[1:] {
+		if e.CreatedAt.After(latest.CreatedAt) {
+			latest = e
+		}
+	}
+	return &latest, nil
+}
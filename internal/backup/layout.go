@@ -0,0 +1,90 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"setup/shared/utils"
+)
+
+// LayoutDir is a directory setup expects to exist.
+type LayoutDir struct {
+	Path string
+	// Perm is the permission the directory is created with.
+	Perm os.FileMode
+}
+
+// Layout returns the directory tree setup works in: the backups directory (private,
+// as archives may hold credentials), the assets directory CopyAllToFiles writes
+// to, the state directory and the config directories for profiles and custom sets.
+func Layout() ([]LayoutDir, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("could not get user home dir: %w", err)
+	}
+	stateDir, err := utils.StateDir()
+	if err != nil {
+		return nil, err
+	}
+	setsDir, err := CustomSetsDir()
+	if err != nil {
+		return nil, err
+	}
+	root, err := utils.SetupRoot()
+	if err != nil {
+		return nil, err
+	}
+	return []LayoutDir{
+		{Path: root, Perm: 0o755},
+		{Path: filepath.Join(root, "backups"), Perm: 0o700},
+		{Path: filepath.Join(root, "assets", "files"), Perm: 0o755},
+		{Path: stateDir, Perm: 0o700},
+		{Path: filepath.Join(home, ".config", "setup", "profiles"), Perm: 0o755},
+		{Path: setsDir, Perm: 0o755},
+	}, nil
+}
+
+// EnsureLayout creates the missing directories of Layout and checks that the
+// existing ones are writable directories. It returns the directories it created.
+func EnsureLayout() ([]string, error) {
+	dirs, err := Layout()
+	if err != nil {
+		return nil, err
+	}
+	var created []string
+	for _, d := range dirs {
+		info, err := os.Stat(d.Path)
+		if os.IsNotExist(err) {
+			if err := os.MkdirAll(d.Path, d.Perm); err != nil {
+				return created, fmt.Errorf("could not create %s: %w", d.Path, err)
+			}
+			// MkdirAll applies the umask; set the intended permission explicitly.
+			if err := os.Chmod(d.Path, d.Perm); err != nil {
+				return created, err
+			}
+			created = append(created, d.Path)
+			continue
+		}
+		if err != nil {
+			return created, err
+		}
+		if !info.IsDir() {
+			return created, fmt.Errorf("%s exists but is not a directory", d.Path)
+		}
+		if err := checkWritableDir(d.Path); err != nil {
+			return created, err
+		}
+	}
+	return created, nil
+}
+
+// checkWritableDir fails unless a file can be created in dir.
+func checkWritableDir(dir string) error {
+	f, err := os.CreateTemp(dir, ".setup-write-check-")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,31 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gofrs/flock"
+)
+
+// lockFileName is the lock held in the backups directory while a run uses its
+// tmp staging directory.
+const lockFileName = ".setup.lock"
+
+// acquireLock takes the backups directory lock so concurrent create/apply runs
+// cannot clobber each other's tmp directory. The returned func releases it.
+func acquireLock(backupsDir string) (func(), error) {
+	if err := os.MkdirAll(backupsDir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create backups dir: %w", err)
+	}
+	path := filepath.Join(backupsDir, lockFileName)
+	lock := flock.New(path)
+	locked, err := lock.TryLock()
+	if err != nil {
+		return nil, fmt.Errorf("could not lock %s: %w", path, err)
+	}
+	if !locked {
+		return nil, fmt.Errorf("another setup run is using %s (lock %s is held)", backupsDir, path)
+	}
+	return func() { _ = lock.Unlock() }, nil
+}
@@ -0,0 +1,330 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"setup/shared/utils"
+)
+
+// manifestFileName is the name of the manifest written at the root of every archive.
+const manifestFileName = "backup-manifest.json"
+
+// manifestSidecarSuffix is appended to an archive name to name its gzip'd manifest
+// sidecar, kept next to the archive locally and on Google Drive.
+const manifestSidecarSuffix = ".manifest.json.gz"
+
+// Manifest describes the contents of a backup archive.
+type Manifest struct {
+	// Archive is the name of the archive the manifest belongs to. Manifests
+	// written before it was recorded leave it empty.
+	Archive   string    `json:"archive,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	Username  string    `json:"username"`
+	Hostname  string    `json:"hostname"`
+	Home      string    `json:"home"`
+	Sets      []string  `json:"sets"`
+	// Parent is the archive name this backup is an increment of, if any.
+	Parent string `json:"parent,omitempty"`
+	// Tags are the labels given with create --tag (e.g. "pre-upgrade").
+	Tags []string `json:"tags,omitempty"`
+	// Redacted manifests store hashed path identifiers (see redactPath) instead of
+	// paths, and no username, hostname or home.
+	Redacted bool           `json:"redacted,omitempty"`
+	Files    []ManifestFile `json:"files"`
+	// Remove lists paths (relative to /, slash separated) apply deletes on the
+	// target machine; they come from the backup sets' FilesRemove. Redacted
+	// manifests carry none.
+	Remove []string `json:"remove,omitempty"`
+
+	index map[string]ManifestFile
+}
+
+// ManifestFile describes one regular file of the backup. Path is relative to the
+// archive root (i.e. to /) using forward slashes.
+type ManifestFile struct {
+	Path    string      `json:"path"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"modTime"`
+	SHA256  string      `json:"sha256"`
+	// Owner is the owner of the live file, restored on apply when the tool has
+	// the privileges. It is unset for files archived outside Linux.
+	Owner *FileOwner `json:"owner,omitempty"`
+	// Capability holds the base64 encoded Linux file capabilities, if any.
+	Capability string `json:"capability,omitempty"`
+	// FromParent marks files that are unchanged since Parent and therefore not
+	// stored in this archive.
+	FromParent bool `json:"fromParent,omitempty"`
+	// PreProcess is set when the archived file is the output of a PreProcess
+	// command; apply reverses it (see restorePreProcessed).
+	PreProcess *PreProcess `json:"preProcess,omitempty"`
+	// NoUpdate marks files of FileAdd entries with Update false: apply creates
+	// them but never overwrites an existing file.
+	NoUpdate bool `json:"noUpdate,omitempty"`
+}
+
+// buildManifest walks stagingDir and records every regular file with its checksum.
+// sets are the names of the backup sets the staged files come from; preProcessed
+// lists the staged files made by PreProcess commands.
+func buildManifest(stagingDir string, sets []string, preProcessed map[string]PreProcess) (*Manifest, error) {
+	home, _ := os.UserHomeDir()
+	hostname, _ := os.Hostname()
+	m := &Manifest{
+		CreatedAt: time.Now(),
+		Username:  currentUsername(),
+		Hostname:  hostname,
+		Home:      home,
+		Sets:      sets,
+	}
+	err := filepath.Walk(stagingDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(stagingDir, path)
+		if err != nil {
+			return err
+		}
+		if rel == manifestFileName {
+			return nil
+		}
+		source := filepath.Join(string(os.PathSeparator), rel)
+		var pp *PreProcess
+		if p, ok := preProcessed[filepath.ToSlash(rel)]; ok {
+			pp = &p
+			source = strings.TrimSuffix(source, p.Suffix)
+		}
+		var sum string
+		if pp != nil {
+			// The staged file differs from the live one; never use the cache.
+			sum, err = hashFile(path)
+		} else {
+			sum, err = hashStagedFile(path, info, source)
+		}
+		if err != nil {
+			return err
+		}
+		f := ManifestFile{
+			Path:       filepath.ToSlash(rel),
+			Size:       info.Size(),
+			Mode:       info.Mode(),
+			ModTime:    info.ModTime(),
+			SHA256:     sum,
+			PreProcess: pp,
+		}
+		// The staged copy loses special bits and mtimes; record them from the live file.
+		captureSourceAttributes(source, &f)
+		m.Files = append(m.Files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not build manifest: %w", err)
+	}
+	if err := saveHashCache(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	return m, nil
+}
+
+// writeManifestFile serializes m to path.
+func writeManifestFile(path string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not serialize manifest: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("could not write manifest: %w", err)
+	}
+	return nil
+}
+
+// writeManifestSidecar serializes m gzip'd to path.
+func writeManifestSidecar(path string, m *Manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("could not serialize manifest: %w", err)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("could not write manifest: %w", err)
+	}
+	return nil
+}
+
+// readManifestFile parses the manifest stored at path, which may be gzip'd.
+func readManifestFile(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("could not read manifest %s: %w", path, err)
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("could not read manifest %s: %w", path, err)
+		}
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("could not parse manifest %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// ReadManifest reads the manifest from the root of an extracted backup in dir.
+func ReadManifest(dir string) (*Manifest, error) {
+	return readManifestFile(filepath.Join(dir, manifestFileName))
+}
+
+// ErrChecksumMismatch means an extracted file differs from the checksum its
+// manifest records.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// verifyExtracted checks every file extracted into dir against m, failing with
+// ErrChecksumMismatch on the first whose checksum differs or that m lists as
+// stored in the archive but is missing. It must run before the extracted tree is
+// modified (see restorePreProcessed and remapHome).
+func verifyExtracted(dir string, m *Manifest) error {
+	report, err := checkExtracted(dir, m)
+	if err != nil {
+		return err
+	}
+	if len(report.Problems) > 0 {
+		return report.Problems[0]
+	}
+	return nil
+}
+
+// printSummary describes where and when the backup of m was made and what it
+// holds.
+func (m *Manifest) printSummary(w io.Writer) {
+	origin := "an unknown host"
+	if !m.Redacted {
+		origin = fmt.Sprintf("%s@%s", m.Username, m.Hostname)
+	}
+	fmt.Fprintf(w, "Backup created %s by %s", m.CreatedAt.Local().Format("2006-01-02 15:04:05"), origin)
+	if len(m.Sets) > 0 {
+		fmt.Fprintf(w, " (sets: %s)", strings.Join(m.Sets, ", "))
+	}
+	fmt.Fprintln(w)
+	var size int64
+	for _, f := range m.Files {
+		size += f.Size
+	}
+	fmt.Fprintf(w, "%d file(s), %s", len(m.Files), utils.FormatBytes(size))
+	if len(m.Tags) > 0 {
+		fmt.Fprintf(w, ", tags: %s", strings.Join(m.Tags, ", "))
+	}
+	if m.Parent != "" {
+		fmt.Fprintf(w, ", incremental on %s", m.Parent)
+	}
+	fmt.Fprintln(w)
+}
+
+// manifestSidecarPath returns where the local copy of an archive's manifest is kept.
+func manifestSidecarPath(backupsDir, archiveName string) string {
+	return filepath.Join(backupsDir, archiveName+manifestSidecarSuffix)
+}
+
+// find returns the entry for the archive-relative, slash separated path. It is
+// safe to call on a nil manifest and handles redacted manifests.
+func (m *Manifest) find(path string) (ManifestFile, bool) {
+	if m == nil {
+		return ManifestFile{}, false
+	}
+	m.buildIndex()
+	if m.Redacted {
+		path = redactPath(path)
+	}
+	f, ok := m.index[path]
+	return f, ok
+}
+
+// buildIndex indexes the files of m by path for find, unless that was done. find
+// builds the index on first use, so callers looking files up from several
+// goroutines build it beforehand.
+func (m *Manifest) buildIndex() {
+	if m == nil || m.index != nil {
+		return
+	}
+	m.index = make(map[string]ManifestFile, len(m.Files))
+	for _, f := range m.Files {
+		m.index[f.Path] = f
+	}
+}
+
+// markNoUpdate sets NoUpdate on the files at or below one of paths.
+func (m *Manifest) markNoUpdate(paths []string) {
+	for i, f := range m.Files {
+		for _, p := range paths {
+			if f.Path == p || strings.HasPrefix(f.Path, p+"/") {
+				m.Files[i].NoUpdate = true
+				break
+			}
+		}
+	}
+}
+
+// redacted returns a copy of m safe to store off-machine: paths are replaced by
+// hashed identifiers and identifying metadata is dropped.
+func (m *Manifest) redacted() *Manifest {
+	r := *m
+	r.Username, r.Hostname, r.Home = "", "", ""
+	r.Redacted = true
+	r.Remove = nil
+	r.index = nil
+	r.Files = make([]ManifestFile, len(m.Files))
+	for i, f := range m.Files {
+		f.Path = redactPath(f.Path)
+		r.Files[i] = f
+	}
+	return &r
+}
+
+// redactPath returns the identifier stored in place of path in redacted manifests.
+func redactPath(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(sum[:])
+}
+
+// hashFile returns the hex encoded sha256 of the file at path. It always reads
+// the file, as integrity checks must (see hashStagedFile for the cached one).
+func hashFile(path string) (string, error) {
+	return hashFileWith(path, sha256.New())
+}
+
+// hashFileWith returns the hex encoded digest h computes over the file at path.
+func hashFileWith(path string, h hash.Hash) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
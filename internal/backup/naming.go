@@ -0,0 +1,69 @@
+package backup
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// validBackupName matches the base names accepted by ValidateBackupName.
+var validBackupName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// ValidateBackupName fails unless name is usable as the base name of a backup
+// archive (see CreateBackupOpts.Name): letters, digits, ".", "_" or "-", at most
+// 128 characters, without the extension, which is added according to the format.
+func ValidateBackupName(name string) error {
+	if !validBackupName.MatchString(name) {
+		return fmt.Errorf("invalid backup name %q (use letters, digits, '.', '_' or '-')", name)
+	}
+	if _, ok := archiveFormat(name); ok {
+		return fmt.Errorf("invalid backup name %q: give it without the archive extension", name)
+	}
+	return nil
+}
+
+// defaultBackupName returns the base name of a backup created at t by the
+// current user ("home-alice-backup-20240102-150405").
+func defaultBackupName(t time.Time) string {
+	return fmt.Sprintf("home-%s-backup-%s", currentUsername(), t.Format("20060102-150405"))
+}
+
+// uniqueArchiveName returns base+ext, unless backupsDir already holds an archive
+// of that name (plain or encrypted) or the local index lists one, e.g. when two
+// backups are created within the same second. Then a short random suffix is added
+// to base ("home-alice-backup-20240102-150405-3f9a.tar.xz") until the name is free.
+func uniqueArchiveName(backupsDir, base, ext string) (string, error) {
+	entries, err := loadBackupIndex(backupsDir)
+	if err != nil {
+		return "", err
+	}
+	indexed := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		indexed[strings.TrimSuffix(e.Name, encryptedExt)] = true
+	}
+	taken := func(name string) bool {
+		plain := strings.TrimSuffix(name, encryptedExt)
+		if indexed[plain] {
+			return true
+		}
+		for _, n := range []string{plain, plain + encryptedExt} {
+			if _, err := os.Lstat(filepath.Join(backupsDir, n)); err == nil {
+				return true
+			}
+		}
+		return false
+	}
+
+	name := base + ext
+	for try := 0; taken(name); try++ {
+		if try == 100 {
+			return "", fmt.Errorf("could not find a free name for backup %s", base)
+		}
+		name = fmt.Sprintf("%s-%04x%s", base, rand.Uint32N(1<<16), ext)
+	}
+	return name, nil
+}
@@ -0,0 +1,159 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"setup/shared/utils"
+)
+
+// Actions of an apply plan.
+const (
+	ActionCreate    = "create"
+	ActionOverwrite = "overwrite"
+	ActionUnchanged = "unchanged"
+	ActionSkip      = "skip"
+	ActionClone     = "clone"
+	ActionRemove    = "remove"
+)
+
+// ApplyPlan lists, per step, every operation an apply would perform.
+type ApplyPlan struct {
+	Archive string        `json:"archive"`
+	Steps   []PlannedStep `json:"steps"`
+}
+
+// PlannedStep holds the operations of one backup step.
+type PlannedStep struct {
+	Name    string          `json:"name"`
+	Actions []PlannedAction `json:"actions"`
+}
+
+// PlannedAction is a single operation on a target path.
+type PlannedAction struct {
+	Action string `json:"action"`
+	Target string `json:"target"`
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// PlanApply extracts backupFile like ApplyBackupWithOpts would and reports every
+// operation it would perform, without touching the live filesystem.
+func PlanApply(backupFile string, opts ApplyBackupOpts) (*ApplyPlan, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("could not get user home: %w", err)
+	}
+	backupsDir, err := localBackupsDir()
+	if err != nil {
+		return nil, err
+	}
+
+	setPaths, err := optsSetPaths(opts, home)
+	if err != nil {
+		return nil, err
+	}
+	steps, err := opts.steps(home)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := acquireLock(backupsDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	prepared, err := prepareBackup(backupsDir, backupFile, remapTarget(opts, home), opts.NoDownload, false)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(prepared.TmpDir)
+
+	cfg := newApplyConfig(opts, prepared.Manifest)
+	if cfg.Overwrite == utils.OverwritePrompt {
+		// A plan cannot ask; files that would be asked about are reported as overwrites.
+		cfg.Overwrite = utils.OverwriteIfDiffer
+	}
+	plan := &ApplyPlan{Archive: filepath.Base(prepared.Archive)}
+	for _, step := range steps {
+		planned := PlannedStep{Name: step.Name, Actions: []PlannedAction{}}
+		if strings.EqualFold(step.Name, "clone all") {
+			planned.Actions = append(planned.Actions, PlannedAction{Action: ActionClone, Target: "all configured repositories"})
+		} else if step.Filter != nil {
+			filter := stepFilter(step, setPaths)
+			actions, err := planFromTmpWithFilter(prepared.TmpDir, filter, cfg)
+			if err != nil {
+				return nil, fmt.Errorf("could not plan backup step '%s': %w", step.Name, err)
+			}
+			if !opts.KeepRemoved {
+				for _, target := range removalTargets(prepared.Manifest, filter) {
+					actions = append(actions, PlannedAction{Action: ActionRemove, Target: target})
+				}
+			}
+			planned.Actions = actions
+		}
+		plan.Steps = append(plan.Steps, planned)
+	}
+	return plan, nil
+}
+
+// planFromTmpWithFilter mirrors applyFromTmpWithFilter, returning the action it
+// would take for every file instead of performing it.
+func planFromTmpWithFilter(tmpDir string, filter func(rel string, info os.FileInfo) bool, cfg applyConfig) ([]PlannedAction, error) {
+	var actions []PlannedAction
+	err := walkBackupTree(tmpDir, filter, func(rel, path string, info os.FileInfo) error {
+		if info.IsDir() {
+			return nil
+		}
+		target := filepath.Join(string(os.PathSeparator), rel)
+		if info.Mode()&os.ModeSymlink != 0 {
+			action, err := cfg.symlinkAction(path, target)
+			if err != nil {
+				return err
+			}
+			actions = append(actions, PlannedAction{Action: action, Target: target})
+			return nil
+		}
+		var restore bool
+		var err error
+		if !cfg.keepsExisting(rel, target) {
+			if restore, err = cfg.shouldRestore(rel, path, target); err != nil {
+				return err
+			}
+		}
+		action := ActionSkip
+		if restore {
+			if action, err = fileAction(path, target); err != nil {
+				return err
+			}
+		}
+		sum := ""
+		if f, ok := cfg.Manifest.find(filepath.ToSlash(rel)); ok {
+			sum = f.SHA256
+		} else if sum, err = hashFile(path); err != nil {
+			return err
+		}
+		actions = append(actions, PlannedAction{Action: action, Target: target, Size: info.Size(), SHA256: sum})
+		return nil
+	})
+	return actions, err
+}
+
+// fileAction decides whether restoring src over target creates, overwrites or
+// leaves it unchanged.
+func fileAction(src, target string) (string, error) {
+	if _, err := os.Stat(target); os.IsNotExist(err) {
+		return ActionCreate, nil
+	}
+	same, err := utils.FilesAreEqual(src, target)
+	if err != nil {
+		return "", err
+	}
+	if same {
+		return ActionUnchanged, nil
+	}
+	return ActionOverwrite, nil
+}
@@ -0,0 +1,288 @@
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"setup/shared/utils"
+)
+
+// PreProcessTimeout bounds each PreProcess command and its restore command.
+var PreProcessTimeout = 10 * time.Minute
+
+// PreProcess transforms a bulky file before it is staged, archiving the result
+// instead of the original (e.g. a sqlite database shrunk with VACUUM INTO).
+// Commands run through "sh -c" with $SRC and $DST set.
+type PreProcess struct {
+	// Name identifies the PreProcess in the manifest. Apply looks the Restore
+	// command up by it in the local backup sets and never runs one read from a
+	// backup, so a PreProcess with a Restore command needs a Name.
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+	// Command reads $SRC, a private copy of the file, and writes the version to
+	// archive to $DST.
+	Command string `yaml:"command" json:"command"`
+	// Restore turns the archived $SRC back into the original file at $DST on
+	// apply. When empty the archived version is restored as is.
+	Restore string `yaml:"restore,omitempty" json:"restore,omitempty"`
+	// Suffix is appended to the archived file name (e.g. ".xz").
+	Suffix string `yaml:"suffix,omitempty" json:"suffix,omitempty"`
+	// Consumes names sibling files (e.g. "messages.db-wal") copied next to $SRC
+	// and folded into the result. They are not archived on their own and are
+	// deleted next to the file when it is restored.
+	Consumes []string `yaml:"consumes,omitempty" json:"consumes,omitempty"`
+}
+
+// messagesDBPreProcess vacuums the messages DB into a compact copy, folding in
+// its write-ahead log; the result is a valid database and is restored as is.
+var messagesDBPreProcess = map[string]PreProcess{
+	"messages.db": {
+		Command:  `sqlite3 "$SRC" "VACUUM INTO '$DST'"`,
+		Consumes: []string{"messages.db-wal", "messages.db-shm"},
+	},
+}
+
+// consumedContents returns the contents of f folded into another entry by its
+// PreProcess.
+func (f Folder) consumedContents() map[string]bool {
+	consumed := map[string]bool{}
+	for _, pp := range f.PreProcess {
+		for _, c := range pp.Consumes {
+			consumed[c] = true
+		}
+	}
+	return consumed
+}
+
+// preProcessLog records the staged files produced by PreProcess commands, keyed
+// by their slash separated path relative to the staging dir.
+type preProcessLog struct {
+	mu    sync.Mutex
+	files map[string]PreProcess
+}
+
+// stage pre-processes origPath into targetDir. When the command fails the file
+// and the siblings it consumes are staged unchanged instead, with a warning.
+func (l *preProcessLog) stage(origPath, targetDir string, pp PreProcess, stageRaw func(path string) error) error {
+	expanded, err := expandHome(origPath)
+	if err != nil {
+		return err
+	}
+	rel, err := preProcessFile(expanded, targetDir, pp)
+	if err == nil {
+		l.mu.Lock()
+		if l.files == nil {
+			l.files = map[string]PreProcess{}
+		}
+		l.files[rel] = pp
+		l.mu.Unlock()
+		return nil
+	}
+	if os.IsNotExist(err) {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Warning: could not pre-process %s, archiving it unchanged: %v\n", expanded, err)
+	if err := stageRaw(origPath); err != nil {
+		return err
+	}
+	for _, c := range pp.Consumes {
+		sibling := filepath.Join(filepath.Dir(origPath), c)
+		if err := stageRaw(sibling); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// preProcessFile runs pp.Command on a copy of path (with its consumed siblings)
+// and stages the result in targetDir, returning its staged relative path.
+func preProcessFile(path, targetDir string, pp PreProcess) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	work, err := os.MkdirTemp("", "setup-preprocess-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(work)
+
+	src := filepath.Join(work, filepath.Base(path))
+	if err := utils.CopyFile(path, src, info.Mode()); err != nil {
+		return "", err
+	}
+	for _, c := range pp.Consumes {
+		sibling := filepath.Join(filepath.Dir(path), c)
+		if _, err := os.Stat(sibling); err != nil {
+			continue
+		}
+		if err := utils.CopyFile(sibling, filepath.Join(work, c)); err != nil {
+			return "", err
+		}
+	}
+	dst := filepath.Join(work, "result")
+	if err := runPreProcessCommand(pp.Command, src, dst); err != nil {
+		return "", err
+	}
+
+	rel := trimLeadingSlash(path) + pp.Suffix
+	if err := utils.CopyFile(dst, filepath.Join(targetDir, rel), info.Mode()); err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// runPreProcessCommand runs command through sh with $SRC and $DST, failing if
+// it does not produce dst.
+func runPreProcessCommand(command, src, dst string) error {
+	var out bytes.Buffer
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), "SRC="+src, "DST="+dst)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := utils.RunCommand(cmd, PreProcessTimeout); err != nil {
+		return fmt.Errorf("%q: %w: %s", command, err, bytes.TrimSpace(out.Bytes()))
+	}
+	if _, err := os.Stat(dst); err != nil {
+		return fmt.Errorf("%q produced no output", command)
+	}
+	return nil
+}
+
+// restorePreProcessed turns the pre-processed files of the backup extracted in
+// tmpDir back into the originals, registering them in m under their original
+// path so later lookups find them. Restore commands come from the local backup
+// sets (see localRestoreCommand) and only run with runCommands; without it, as
+// for dry runs, plans and diffs, such files only get their original name and
+// keep their archived contents.
+func restorePreProcessed(tmpDir string, m *Manifest, runCommands bool) error {
+	if m == nil {
+		return nil
+	}
+	var restored []ManifestFile
+	var notRun int
+	err := filepath.Walk(tmpDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(tmpDir, path)
+		if err != nil {
+			return err
+		}
+		f, ok := m.find(filepath.ToSlash(rel))
+		if !ok || f.PreProcess == nil {
+			return nil
+		}
+		pp := f.PreProcess
+		if strings.ContainsAny(pp.Suffix, `/\`) {
+			return fmt.Errorf("pre-processed %s has an invalid suffix %q", rel, pp.Suffix)
+		}
+		command, err := localRestoreCommand(pp)
+		if err != nil {
+			return fmt.Errorf("could not restore pre-processed %s: %w", rel, err)
+		}
+		out := strings.TrimSuffix(path, pp.Suffix)
+		if command != "" && runCommands {
+			tmpOut := out + ".setup-restore"
+			if err := runPreProcessCommand(command, path, tmpOut); err != nil {
+				return fmt.Errorf("could not restore pre-processed %s: %w", rel, err)
+			}
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			if err := os.Rename(tmpOut, out); err != nil {
+				return err
+			}
+		} else if out != path {
+			if command != "" {
+				notRun++
+			}
+			if err := os.Rename(path, out); err != nil {
+				return err
+			}
+		}
+		_ = os.Chtimes(out, f.ModTime, f.ModTime)
+		if out != path {
+			outRel := filepath.ToSlash(strings.TrimSuffix(rel, pp.Suffix))
+			if m.Redacted {
+				outRel = redactPath(outRel)
+			}
+			f.Path = outRel
+			restored = append(restored, f)
+		}
+		return nil
+	})
+	if len(restored) > 0 {
+		m.Files = append(m.Files, restored...)
+		m.index = nil
+	}
+	if notRun > 0 {
+		fmt.Printf("Note: the restore commands of %d pre-processed file(s) were not run; they are shown in their archived form.\n", notRun)
+	}
+	return err
+}
+
+// localRestoreCommand returns the Restore command of the PreProcess of the local
+// backup sets named like pp, the record of a PreProcess read from a manifest.
+// The Restore command of pp itself is never used: whoever can write to the
+// backup store could otherwise run commands on apply.
+func localRestoreCommand(pp *PreProcess) (string, error) {
+	if pp.Name == "" {
+		if pp.Restore != "" {
+			return "", errors.New("its restore command is only taken from a named PreProcess of the local backup sets, and it has no name")
+		}
+		return "", nil
+	}
+	for _, set := range backupSets {
+		for _, f := range set.Folders {
+			for _, local := range f.PreProcess {
+				if local.Name == pp.Name {
+					return local.Restore, nil
+				}
+			}
+		}
+		for _, fa := range set.FilesAdd {
+			if fa.PreProcess != nil && fa.PreProcess.Name == pp.Name {
+				return fa.PreProcess.Restore, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no local backup set defines the PreProcess %q", pp.Name)
+}
+
+// removeConsumed deletes the siblings of target that f's PreProcess folded into
+// it, saving them under originalsDir first, so stale journals are not replayed
+// onto the restored file. Consumes comes from the manifest, so entries that are
+// not plain file names in target's directory are ignored.
+func removeConsumed(target, originalsDir string, f ManifestFile) {
+	if f.PreProcess == nil {
+		return
+	}
+	for _, c := range f.PreProcess.Consumes {
+		if c == "" || c == "." || c == ".." || filepath.Base(c) != c {
+			fmt.Fprintf(os.Stderr, "Warning: ignoring invalid consumed file %q of %s\n", c, target)
+			continue
+		}
+		sibling := filepath.Join(filepath.Dir(target), c)
+		if filepath.Dir(sibling) != filepath.Dir(target) {
+			continue
+		}
+		if _, err := os.Lstat(sibling); err != nil {
+			continue
+		}
+		backupPath := filepath.Join(originalsDir, trimLeadingSlash(sibling))
+		if err := utils.CopyFile(sibling, backupPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: keeping %s: could not save a copy of it: %v\n", sibling, err)
+			continue
+		}
+		if err := os.Remove(sibling); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not remove %s: %v\n", sibling, err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestorePreProcessedUsesLocalCommands(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no /bin/sh")
+	}
+	setTestHome(t)
+	marker := filepath.Join(t.TempDir(), "ran")
+	registerTestSet(t, BackupSet{Name: "pptest", FilesAdd: []FileAdd{{
+		Path:       "~/notes.txt",
+		PreProcess: &PreProcess{Name: "upper", Command: "cp \"$SRC\" \"$DST\"", Restore: `tr a-z A-Z < "$SRC" > "$DST"`, Suffix: ".up"},
+	}}})
+	archived := &PreProcess{Name: "upper", Restore: "touch " + marker, Suffix: ".up"}
+
+	for _, tc := range []struct {
+		name        string
+		pp          *PreProcess
+		runCommands bool
+		want        string
+		wantErr     bool
+	}{
+		{name: "local command", pp: archived, runCommands: true, want: "NOTES\n"},
+		{name: "not run", pp: archived, runCommands: false, want: "notes\n"},
+		{name: "unnamed", pp: &PreProcess{Restore: "touch " + marker, Suffix: ".up"}, runCommands: true, wantErr: true},
+		{name: "unknown name", pp: &PreProcess{Name: "other", Suffix: ".up"}, runCommands: true, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			writeTestFile(t, filepath.Join(tmpDir, "home", "notes.txt.up"), "notes\n")
+			m := &Manifest{Files: []ManifestFile{{Path: "home/notes.txt.up", PreProcess: tc.pp}}}
+
+			err := restorePreProcessed(tmpDir, m, tc.runCommands)
+			if _, statErr := os.Stat(marker); statErr == nil {
+				t.Fatal("the restore command of the manifest was run")
+			}
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("restorePreProcessed succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(filepath.Join(tmpDir, "home", "notes.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("restored %q, want %q", got, tc.want)
+			}
+			if _, ok := m.find("home/notes.txt"); !ok {
+				t.Error("restored file not registered under its original path")
+			}
+		})
+	}
+}
+
+func TestRemoveConsumedStaysInDir(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "app")
+	target := filepath.Join(dir, "messages.db")
+	for _, p := range []string{target, filepath.Join(dir, "messages.db-wal"), filepath.Join(dir, "sub", "x"), filepath.Join(root, "outside")} {
+		writeTestFile(t, p, "data\n")
+	}
+	f := ManifestFile{PreProcess: &PreProcess{Consumes: []string{"messages.db-wal", "../outside", "sub/x", "..", ""}}}
+
+	removeConsumed(target, filepath.Join(root, "originals"), f)
+	if _, err := os.Stat(filepath.Join(dir, "messages.db-wal")); !os.IsNotExist(err) {
+		t.Errorf("consumed sibling not removed (err %v)", err)
+	}
+	for _, kept := range []string{target, filepath.Join(dir, "sub", "x"), filepath.Join(root, "outside")} {
+		if _, err := os.Stat(kept); err != nil {
+			t.Errorf("%s was removed: %v", kept, err)
+		}
+	}
+}
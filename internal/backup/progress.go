@@ -0,0 +1,31 @@
+package backup
+
+import (
+	"io"
+)
+
+// progressReader reports how much of an upload has been read. Seeking (as a
+// retrying uploader does to start over) moves the count with the offset.
+type progressReader struct {
+	r        io.ReadSeeker
+	done     int64
+	total    int64
+	progress func(done, total int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.done += int64(n)
+		p.progress(p.done, p.total)
+	}
+	return n, err
+}
+
+func (p *progressReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := p.r.Seek(offset, whence)
+	if err == nil {
+		p.done = pos
+	}
+	return pos, err
+}
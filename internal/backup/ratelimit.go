@@ -0,0 +1,51 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// TransferLimit caps each upload and download of the backup store at that many
+// bytes per second; zero leaves them unlimited.
+var TransferLimit int64
+
+// limitedReader throttles reads from r to TransferLimit with a token bucket.
+// It seeks when r does, so a retrying uploader can start over.
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+// newLimitedReader returns r throttled to TransferLimit, which must be positive.
+// Waiting for the limiter ends when ctx is done.
+func newLimitedReader(ctx context.Context, r io.Reader) *limitedReader {
+	// A burst of one second's worth keeps the rate smooth without starving
+	// small reads.
+	burst := int(min(TransferLimit, 1<<30))
+	return &limitedReader{ctx: ctx, r: r, limiter: rate.NewLimiter(rate.Limit(TransferLimit), burst)}
+}
+
+func (l *limitedReader) Read(b []byte) (int, error) {
+	if len(b) > l.limiter.Burst() {
+		b = b[:l.limiter.Burst()]
+	}
+	n, err := l.r.Read(b)
+	if n > 0 {
+		if werr := l.limiter.WaitN(l.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (l *limitedReader) Seek(offset int64, whence int) (int64, error) {
+	s, ok := l.r.(io.Seeker)
+	if !ok {
+		return 0, errors.New("seek on a non-seekable reader")
+	}
+	return s.Seek(offset, whence)
+}
@@ -0,0 +1,48 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func setTransferLimit(t *testing.T, limit int64) {
+	t.Helper()
+	old := TransferLimit
+	TransferLimit = limit
+	t.Cleanup(func() { TransferLimit = old })
+}
+
+func TestLimitedReaderThroughput(t *testing.T) {
+	const limit = 256 << 10
+	setTransferLimit(t, limit)
+
+	// The bucket starts with one second's worth, so the second costs a second.
+	data := bytes.Repeat([]byte("x"), 2*limit)
+	start := time.Now()
+	n, err := io.Copy(io.Discard, newLimitedReader(context.Background(), bytes.NewReader(data)))
+	elapsed := time.Since(start)
+	if err != nil || n != int64(len(data)) {
+		t.Fatalf("copied %d bytes, %v", n, err)
+	}
+	if elapsed < 800*time.Millisecond || elapsed > 3*time.Second {
+		t.Errorf("reading %d bytes at %d B/s took %s, want about 1s", len(data), limit, elapsed)
+	}
+}
+
+func TestLimitedReaderStopsWithContext(t *testing.T) {
+	setTransferLimit(t, 1024)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := io.Copy(io.Discard, newLimitedReader(ctx, bytes.NewReader(make([]byte, 1<<20))))
+	if err == nil {
+		t.Fatal("copy finished despite the deadline")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("copy stopped after %s", elapsed)
+	}
+}
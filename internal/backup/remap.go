@@ -0,0 +1,67 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// remapHome moves the archived home directory of an extracted backup in tmpDir to
+// home, so files of a backup made by another user land in the current user's home.
+// Manifest paths are rewritten to match. It reports whether anything was moved;
+// backups without a (non-redacted) manifest or from the same home are left alone.
+func remapHome(tmpDir string, m *Manifest, home string) (bool, error) {
+	if m == nil || m.Redacted || m.Home == "" || filepath.Clean(m.Home) == filepath.Clean(home) {
+		return false, nil
+	}
+	oldRel := filepath.ToSlash(trimLeadingSlash(filepath.Clean(m.Home)))
+	newRel := filepath.ToSlash(trimLeadingSlash(filepath.Clean(home)))
+	if strings.HasPrefix(newRel+"/", oldRel+"/") || strings.HasPrefix(oldRel+"/", newRel+"/") {
+		return false, fmt.Errorf("cannot remap archived home %s to %s: one contains the other", m.Home, home)
+	}
+
+	src := filepath.Join(tmpDir, filepath.FromSlash(oldRel))
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return false, nil
+	}
+	dst := filepath.Join(tmpDir, filepath.FromSlash(newRel))
+	if err := moveTree(src, dst); err != nil {
+		return false, fmt.Errorf("could not remap archived home %s to %s: %w", m.Home, home, err)
+	}
+
+	for i, f := range m.Files {
+		if strings.HasPrefix(f.Path, oldRel+"/") {
+			m.Files[i].Path = newRel + strings.TrimPrefix(f.Path, oldRel)
+			// The files now belong to the new home's user, not the archived one.
+			m.Files[i].Owner = nil
+		}
+	}
+	for i, p := range m.Remove {
+		if strings.HasPrefix(p, oldRel+"/") {
+			m.Remove[i] = newRel + strings.TrimPrefix(p, oldRel)
+		}
+	}
+	m.index = nil
+	return true, nil
+}
+
+// moveTree renames src to dst, merging into dst entry by entry when it already exists.
+func moveTree(src, dst string) error {
+	if _, err := os.Lstat(dst); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		return os.Rename(src, dst)
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := moveTree(filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())); err != nil {
+			return err
+		}
+	}
+	return os.Remove(src)
+}
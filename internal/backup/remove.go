@@ -0,0 +1,68 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"setup/shared/utils"
+)
+
+// removalPaths converts the FilesRemove entries of src into archive-relative,
+// slash separated paths as recorded in Manifest.Remove.
+func removalPaths(src backupSources) []string {
+	var paths []string
+	for _, p := range src.FilesRemove {
+		expanded, err := expandHome(p)
+		if err != nil {
+			continue
+		}
+		paths = append(paths, filepath.ToSlash(trimLeadingSlash(filepath.Clean(expanded))))
+	}
+	return paths
+}
+
+// removalTargets returns the absolute paths listed in m.Remove that exist on disk
+// and are accepted by filter.
+func removalTargets(m *Manifest, filter func(rel string, info os.FileInfo) bool) []string {
+	if m == nil {
+		return nil
+	}
+	var targets []string
+	for _, rel := range m.Remove {
+		target := filepath.Join(string(os.PathSeparator), filepath.FromSlash(rel))
+		info, err := os.Lstat(target)
+		if err != nil || !filter(filepath.FromSlash(rel), info) {
+			continue
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// removeListedFiles deletes the targets listed in the manifest's Remove that
+// filter accepts, keeping a copy of each under originalsDir first. It returns
+// the removed paths.
+func removeListedFiles(originalsDir string, m *Manifest, filter func(rel string, info os.FileInfo) bool) ([]string, error) {
+	var removed []string
+	for _, target := range removalTargets(m, filter) {
+		backupPath := filepath.Join(originalsDir, trimLeadingSlash(target))
+		info, err := os.Lstat(target)
+		if err == nil && info.IsDir() {
+			err = utils.CopyDir(target, backupPath)
+		} else if err == nil && info.Mode()&os.ModeSymlink != 0 {
+			err = utils.CopySymlink(target, backupPath)
+		} else if err == nil {
+			err = utils.CopyFile(target, backupPath, info.Mode())
+		}
+		if err != nil {
+			return removed, fmt.Errorf("could not keep a copy of %s before removing it: %w", target, err)
+		}
+		if err := os.RemoveAll(target); err != nil {
+			return removed, fmt.Errorf("could not remove %s: %w", target, err)
+		}
+		fmt.Printf("Removed %s\n", target)
+		removed = append(removed, target)
+	}
+	return removed, nil
+}
@@ -0,0 +1,125 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// manifestRel returns path as recorded in Manifest.Remove.
+func manifestRel(path string) string {
+	return filepath.ToSlash(trimLeadingSlash(filepath.Clean(path)))
+}
+
+func TestRemovalPaths(t *testing.T) {
+	home := setTestHome(t)
+	got := removalPaths(backupSources{FilesRemove: []string{"~/.cache/zed/", "~/a/../b", "/etc/stale.conf"}})
+	want := []string{
+		manifestRel(filepath.Join(home, ".cache", "zed")),
+		manifestRel(filepath.Join(home, "b")),
+		"etc/stale.conf",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("removalPaths = %q, want %q", got, want)
+	}
+}
+
+func TestRemoveListedFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Manifest.Remove paths are relative to /")
+	}
+	root := t.TempDir()
+	originals := t.TempDir()
+	file := filepath.Join(root, "stale.conf")
+	dir := filepath.Join(root, "stale.d")
+	link := filepath.Join(root, "stale.link")
+	kept := filepath.Join(root, "keep.conf")
+	writeTestFile(t, file, "file\n")
+	writeTestFile(t, filepath.Join(dir, "inner"), "inner\n")
+	if err := os.Symlink("stale.conf", link); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, kept, "kept\n")
+
+	m := &Manifest{Remove: []string{
+		manifestRel(file), manifestRel(dir), manifestRel(link), manifestRel(kept),
+		manifestRel(filepath.Join(root, "missing")),
+	}}
+	filter := func(rel string, info os.FileInfo) bool { return !strings.HasSuffix(rel, "keep.conf") }
+
+	if got, want := removalTargets(m, filter), []string{file, dir, link}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("removalTargets = %q, want %q", got, want)
+	}
+	removed, err := removeListedFiles(originals, m, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{file, dir, link}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed %q, want %q", removed, want)
+	}
+	for _, p := range removed {
+		if _, err := os.Lstat(p); !os.IsNotExist(err) {
+			t.Errorf("%s still exists: %v", p, err)
+		}
+	}
+	if _, err := os.Stat(kept); err != nil {
+		t.Errorf("filtered out %s was removed: %v", kept, err)
+	}
+
+	// The copies under originals restore what was removed.
+	saved := func(p string) string { return filepath.Join(originals, trimLeadingSlash(p)) }
+	for p, want := range map[string]string{file: "file\n", filepath.Join(dir, "inner"): "inner\n"} {
+		if data, err := os.ReadFile(saved(p)); err != nil || string(data) != want {
+			t.Errorf("copy of %s = %q (%v), want %q", p, data, err, want)
+		}
+	}
+	if dest, err := os.Readlink(saved(link)); err != nil || dest != "stale.conf" {
+		t.Errorf("copy of %s links to %q (%v), want stale.conf", link, dest, err)
+	}
+	if _, err := os.Lstat(saved(kept)); !os.IsNotExist(err) {
+		t.Errorf("filtered out %s was copied: %v", kept, err)
+	}
+}
+
+func TestRemoveListedFilesNilManifest(t *testing.T) {
+	removed, err := removeListedFiles(t.TempDir(), nil, func(string, os.FileInfo) bool { return true })
+	if err != nil || removed != nil {
+		t.Errorf("removeListedFiles(nil) = %q, %v", removed, err)
+	}
+}
+
+func TestApplyKeepRemoved(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Manifest.Remove paths are relative to /")
+	}
+	home := setApplyTestHome(t)
+	stale := filepath.Join(home, ".stale")
+	writeTestFile(t, filepath.Join(home, ".kept"), "kept\n")
+	registerTestSet(t, BackupSet{
+		Name:        "removetest",
+		FilesAdd:    []FileAdd{{Path: "~/.kept", Update: true}},
+		FilesRemove: []string{"~/.stale"},
+	})
+	archive, err := CreateBackupWithOpts(CreateBackupOpts{Sets: []string{"removetest"}, LocalOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, keep := range []bool{true, false} {
+		writeTestFile(t, stale, "stale\n")
+		err := ApplyBackupWithOpts(archive, ApplyBackupOpts{NoDownload: true, Steps: []string{"before clone"}, KeepRemoved: keep})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = os.Stat(stale)
+		if keep && err != nil {
+			t.Errorf("with KeepRemoved %s was removed: %v", stale, err)
+		}
+		if !keep && !os.IsNotExist(err) {
+			t.Errorf("without KeepRemoved %s was kept: %v", stale, err)
+		}
+	}
+}
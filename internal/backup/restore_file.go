@@ -0,0 +1,175 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"setup/shared/utils"
+)
+
+// RestoreSingleFile restores targetPath (which may start with "~") from the backup
+// archivePath, resolved like the backup file of ApplyBackupWithOpts (the latest
+// backup when empty), without applying anything else. Only the matching entry is
+// extracted; files an incremental backup inherited are taken from its bases. A
+// backup made under another home is matched by the path relative to the home.
+// The current file, if any, is kept in a rollback point first (see Rollback).
+// It fails with ErrFileNotInBackup when the backup does not hold the path.
+func RestoreSingleFile(archivePath, targetPath string) error {
+	expanded, err := utils.ExpandHome(targetPath)
+	if err != nil {
+		return err
+	}
+	target, err := filepath.Abs(expanded)
+	if err != nil {
+		return err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("could not get user home: %w", err)
+	}
+	backupsDir, err := localBackupsDir()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := acquireLock(backupsDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	store, err := SelectStore()
+	if err != nil {
+		return err
+	}
+	localPath, _, err := locateBackup(store, backupsDir, archivePath, false)
+	if err != nil {
+		return err
+	}
+	if err := verifyAgainstIndex(backupsDir, localPath); err != nil {
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp(backupsDir, "restore-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	m, err := extractFileEntry(store, backupsDir, localPath, target, home, tmpDir)
+	if err != nil {
+		return err
+	}
+
+	// Lay the entry out like a full extraction would before applying it.
+	if err := restorePreProcessed(tmpDir, m, true); err != nil {
+		return err
+	}
+	if _, err := remapHome(tmpDir, m, home); err != nil {
+		return err
+	}
+	rel := trimLeadingSlash(target)
+	path := filepath.Join(tmpDir, rel)
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory in backup %s; only files can be restored", target, filepath.Base(localPath))
+	}
+	if f, ok := m.find(filepath.ToSlash(rel)); ok && f.PreProcess == nil && info.Mode().IsRegular() {
+		sum, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		if sum != f.SHA256 {
+			return fmt.Errorf("%s: %w", target, ErrChecksumMismatch)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	cfg := applyConfig{Manifest: m, Overwrite: utils.OverwriteAlways, OriginalsDir: newOriginalsDir(backupsDir)}
+	if info.Mode()&os.ModeSymlink != 0 {
+		_, err = cfg.restoreSymlink(path, target, cfg.OriginalsDir)
+	} else {
+		_, err = cfg.restoreFile(restoreJob{rel: rel, path: path, target: target, mode: info.Mode(), decided: true})
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s from %s\n", target, filepath.Base(localPath))
+	if _, err := os.Stat(cfg.OriginalsDir); err == nil {
+		fmt.Printf("The replaced file was saved to %s (undo with: setup rollback)\n", cfg.OriginalsDir)
+	}
+	return nil
+}
+
+// extractFileEntry extracts the entry of target from the archive at localPath into
+// tmpDir and returns the manifest of the archive it came from (nil for archives
+// without one). It maps target into the archived home when the backup was made
+// under another one than home, picks the pre-processed entry a file was stored
+// as and follows FromParent files into the bases of incremental backups, using
+// the copies of the bases already in backupsDir.
+func extractFileEntry(store BackupStore, backupsDir, localPath, target, home, tmpDir string) (*Manifest, error) {
+	for depth := 0; ; depth++ {
+		m, err := extractManifest(localPath)
+		if err != nil && !errors.Is(err, ErrFileNotInBackup) {
+			return nil, err
+		}
+		entry := archivedPath(m, target, home)
+		f, ok := m.find(entry)
+		if !ok && m != nil && !m.Redacted {
+			for _, mf := range m.Files {
+				if mf.PreProcess != nil && strings.TrimSuffix(mf.Path, mf.PreProcess.Suffix) == entry {
+					f, ok, entry = mf, true, mf.Path
+					break
+				}
+			}
+		}
+		if !ok || !f.FromParent {
+			err := extractArchiveEntry(localPath, "./"+entry, tmpDir)
+			if errors.Is(err, ErrFileNotInBackup) {
+				return nil, withKind(ErrFileNotInBackup, fmt.Errorf("%s is not in backup %s", target, filepath.Base(localPath)))
+			}
+			if err != nil {
+				return nil, withKind(ErrExtractFailed, fmt.Errorf("could not extract %s: %w", target, err))
+			}
+			return m, nil
+		}
+
+		if depth >= maxParentChain {
+			return nil, fmt.Errorf("more than %d chained incremental backups", maxParentChain)
+		}
+		// A base kept in backupsDir (e.g. made with create --local-only) is used
+		// as is, after checking it against the index.
+		_, statErr := os.Stat(filepath.Join(backupsDir, m.Parent))
+		if statErr != nil {
+			fmt.Printf("Fetching base backup %s...\n", m.Parent)
+		}
+		if localPath, _, err = fetchArchive(store, backupsDir, m.Parent, statErr == nil); err != nil {
+			return nil, err
+		}
+		if err := verifyAgainstIndex(backupsDir, localPath); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// archivedPath returns the archive-relative, slash separated path target was
+// stored under in the backup described by m: below the archived home when target
+// is in home and the backup was made under another one.
+func archivedPath(m *Manifest, target, home string) string {
+	rel := filepath.ToSlash(trimLeadingSlash(target))
+	if m == nil || m.Redacted || m.Home == "" || filepath.Clean(m.Home) == filepath.Clean(home) {
+		return rel
+	}
+	homeRel := filepath.ToSlash(trimLeadingSlash(filepath.Clean(home)))
+	if !strings.HasPrefix(rel, homeRel+"/") {
+		return rel
+	}
+	return filepath.ToSlash(trimLeadingSlash(filepath.Clean(m.Home))) + strings.TrimPrefix(rel, homeRel)
+}
@@ -0,0 +1,88 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// driveRetryAttempts is how many times a Drive API call is tried before its
+// error is returned.
+const driveRetryAttempts = 5
+
+// retryBaseDelay and retryMaxDelay bound the exponential backoff of withRetry.
+var (
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 30 * time.Second
+)
+
+// withRetry calls fn until it succeeds, fails with an error that is not
+// transient (see retryable), maxAttempts calls were made or ctx is done. Between
+// attempts it waits as long as the server's Retry-After asks or, without one,
+// an exponentially growing jittered delay.
+func withRetry(ctx context.Context, maxAttempts int, fn func() error) error {
+	var err error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if err = fn(); err == nil || !retryable(err) || attempt == maxAttempts-1 {
+			return err
+		}
+		timer := time.NewTimer(retryDelay(err, attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+	return err
+}
+
+// retryable reports whether err is a rate limit (429) or transient server error
+// (500, 502, 503) from a Google API.
+func retryable(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.Code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable:
+		return true
+	}
+	return false
+}
+
+// retryDelay returns how long to wait before retrying after the failed attempt
+// (counted from 0) that returned err.
+func retryDelay(err error, attempt int) time.Duration {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		if d, ok := parseRetryAfter(apiErr.Header.Get("Retry-After")); ok {
+			return d
+		}
+	}
+	backoff := retryBaseDelay << attempt
+	if backoff <= 0 || backoff > retryMaxDelay {
+		backoff = retryMaxDelay
+	}
+	// Full jitter spreads out clients that failed at the same time.
+	return time.Duration(rand.Int64N(int64(backoff))) + 1
+}
+
+// parseRetryAfter parses a Retry-After header, given in seconds or as an HTTP date.
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
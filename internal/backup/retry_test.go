@@ -0,0 +1,84 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+)
+
+// newTestDriveService returns a Drive client talking to a test server that
+// answers with the given status codes in turn, each failure carrying a
+// Retry-After of retryAfter, and then succeeds with an empty listing. The
+// returned counter reports the requests served.
+func newTestDriveService(t *testing.T, retryAfter string, codes ...int) (*drive.Service, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1))
+		w.Header().Set("Content-Type", "application/json")
+		if n <= len(codes) {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(codes[n-1])
+			fmt.Fprintf(w, `{"error": {"code": %d, "message": "test failure"}}`, codes[n-1])
+			return
+		}
+		fmt.Fprint(w, `{"files": []}`)
+	}))
+	t.Cleanup(ts.Close)
+	srv, err := drive.NewService(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, &calls
+}
+
+func TestListDriveFilesRetriesWithRetryAfter(t *testing.T) {
+	// A backoff this long fails the test by timeout unless Retry-After is used.
+	oldBase, oldMax := retryBaseDelay, retryMaxDelay
+	retryBaseDelay, retryMaxDelay = time.Hour, time.Hour
+	t.Cleanup(func() { retryBaseDelay, retryMaxDelay = oldBase, oldMax })
+
+	srv, calls := newTestDriveService(t, "0", http.StatusTooManyRequests, http.StatusServiceUnavailable)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := listDriveFiles(ctx, srv.Files.List()); err != nil {
+		t.Fatal(err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("server got %d requests, want 3", n)
+	}
+}
+
+func TestListDriveFilesGivesUp(t *testing.T) {
+	oldBase, oldMax := retryBaseDelay, retryMaxDelay
+	retryBaseDelay, retryMaxDelay = time.Millisecond, time.Millisecond
+	t.Cleanup(func() { retryBaseDelay, retryMaxDelay = oldBase, oldMax })
+
+	for _, tt := range []struct {
+		name  string
+		codes []int
+		want  int32
+	}{
+		{"not retryable", []int{http.StatusNotFound}, 1},
+		{"too many failures", []int{503, 503, 503, 503, 503, 503}, driveRetryAttempts},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, calls := newTestDriveService(t, "", tt.codes...)
+			if _, err := listDriveFiles(context.Background(), srv.Files.List()); err == nil {
+				t.Fatal("listDriveFiles succeeded")
+			}
+			if n := calls.Load(); n != tt.want {
+				t.Errorf("server got %d requests, want %d", n, tt.want)
+			}
+		})
+	}
+}
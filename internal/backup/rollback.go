@@ -0,0 +1,167 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"setup/shared/utils"
+)
+
+// originalsDirPrefix prefixes the directories, in the local backups dir, keeping
+// the files an apply replaced or removed ("originals-20240102-150405").
+const originalsDirPrefix = "originals-"
+
+// originalsTimeLayout formats the timestamp of an originals dir, like archive names.
+const originalsTimeLayout = "20060102-150405"
+
+// RollbackPoint is the set of files saved by one apply, mirrored under Path as if
+// Path were /.
+type RollbackPoint struct {
+	Name      string
+	Path      string
+	CreatedAt time.Time
+	Files     int
+}
+
+// newOriginalsDir returns the originals dir for an apply starting now. It is only
+// created once a file is saved into it. When an earlier apply within the same
+// second already saved files, a "-2", "-3", ... suffix keeps their originals
+// apart; callers hold the backups lock, so the name cannot be taken meanwhile.
+func newOriginalsDir(backupsDir string) string {
+	base := filepath.Join(backupsDir, originalsDirPrefix+time.Now().Format(originalsTimeLayout))
+	dir := base
+	for n := 2; ; n++ {
+		if _, err := os.Lstat(dir); os.IsNotExist(err) {
+			return dir
+		}
+		dir = fmt.Sprintf("%s-%d", base, n)
+	}
+}
+
+// parseOriginalsStamp parses the name of an originals dir after its prefix,
+// returning its creation time and the uniquifier of newOriginalsDir (1 when
+// absent).
+func parseOriginalsStamp(stamp string) (time.Time, int, bool) {
+	seq := 1
+	if len(stamp) > len(originalsTimeLayout) {
+		n, err := strconv.Atoi(strings.TrimPrefix(stamp[len(originalsTimeLayout):], "-"))
+		if err != nil || n < 2 || stamp[len(originalsTimeLayout)] != '-' {
+			return time.Time{}, 0, false
+		}
+		stamp, seq = stamp[:len(originalsTimeLayout)], n
+	}
+	createdAt, err := time.ParseInLocation(originalsTimeLayout, stamp, time.Local)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	return createdAt, seq, true
+}
+
+// ListRollbackPoints returns the rollback points in the local backups dir, most
+// recent first.
+func ListRollbackPoints() ([]RollbackPoint, error) {
+	backupsDir, err := localBackupsDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(backupsDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var points []RollbackPoint
+	seqs := make(map[string]int)
+	for _, e := range entries {
+		stamp, ok := strings.CutPrefix(e.Name(), originalsDirPrefix)
+		if !ok || !e.IsDir() {
+			continue
+		}
+		createdAt, seq, ok := parseOriginalsStamp(stamp)
+		if !ok {
+			continue
+		}
+		seqs[e.Name()] = seq
+		p := RollbackPoint{Name: e.Name(), Path: filepath.Join(backupsDir, e.Name()), CreatedAt: createdAt}
+		_ = filepath.Walk(p.Path, func(_ string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				p.Files++
+			}
+			return nil
+		})
+		points = append(points, p)
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if !points[i].CreatedAt.Equal(points[j].CreatedAt) {
+			return points[i].CreatedAt.After(points[j].CreatedAt)
+		}
+		return seqs[points[i].Name] > seqs[points[j].Name]
+	})
+	return points, nil
+}
+
+// Rollback copies the files of the rollback point called name (the most recent
+// one when empty) back to their original locations, undoing the overwrites and
+// removals of that apply. Files the apply created are left in place. It returns
+// the restored paths.
+func Rollback(name string) ([]string, error) {
+	points, err := ListRollbackPoints()
+	if err != nil {
+		return nil, err
+	}
+	if len(points) == 0 {
+		return nil, fmt.Errorf("no rollback points found")
+	}
+	point := points[0]
+	if name != "" {
+		found := false
+		for _, p := range points {
+			if p.Name == name || p.Name == originalsDirPrefix+name {
+				point, found = p, true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("rollback point %q not found", name)
+		}
+	}
+
+	backupsDir, err := localBackupsDir()
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := acquireLock(backupsDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var restored []string
+	err = filepath.Walk(point.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(point.Path, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(string(os.PathSeparator), rel)
+		if info.Mode()&os.ModeSymlink != 0 {
+			err = utils.CopySymlink(path, target)
+		} else {
+			err = utils.CopyFile(path, target, info.Mode())
+		}
+		if err != nil {
+			return fmt.Errorf("could not restore %s: %w", target, err)
+		}
+		restored = append(restored, target)
+		return nil
+	})
+	return restored, err
+}
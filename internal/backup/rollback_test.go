@@ -0,0 +1,81 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewOriginalsDirIsUnique(t *testing.T) {
+	home := setTestHome(t)
+	backupsDir, err := localBackupsDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for range 3 {
+		dir := newOriginalsDir(backupsDir)
+		if seen[dir] {
+			t.Fatalf("newOriginalsDir returned %s twice", dir)
+		}
+		seen[dir] = true
+		writeTestFile(t, filepath.Join(dir, home, "a.txt"), "a\n")
+	}
+
+	points, err := ListRollbackPoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != len(seen) {
+		t.Fatalf("got %d rollback points, want %d", len(points), len(seen))
+	}
+	for i := 1; i < len(points); i++ {
+		if points[i].CreatedAt.After(points[i-1].CreatedAt) {
+			t.Errorf("rollback points not most recent first: %s before %s", points[i-1].Name, points[i].Name)
+		}
+	}
+}
+
+func TestRestoreFileKeepsTargetWhenOriginalCannotBeSaved(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "new.txt")
+	target := filepath.Join(dir, "target.txt")
+	writeTestFile(t, src, "new\n")
+	writeTestFile(t, target, "old\n")
+	// A regular file where the originals dir should be makes saving fail.
+	originals := filepath.Join(dir, "originals")
+	writeTestFile(t, originals, "")
+
+	cfg := applyConfig{OriginalsDir: originals}
+	restored, err := cfg.restoreFile(restoreJob{rel: "target.txt", path: src, target: target, mode: 0o644, decided: true})
+	if err == nil || restored {
+		t.Fatalf("restoreFile = %v, %v; want an error", restored, err)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "old\n" {
+		t.Errorf("target overwritten with %q", data)
+	}
+}
+
+func TestRestoreFileSavesOriginalWithItsMode(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "new.txt")
+	target := filepath.Join(dir, "target.txt")
+	writeTestFile(t, src, "new\n")
+	writeTestFile(t, target, "old\n")
+	if err := os.Chmod(target, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	originals := filepath.Join(dir, "originals")
+
+	cfg := applyConfig{OriginalsDir: originals}
+	if _, err := cfg.restoreFile(restoreJob{rel: "target.txt", path: src, target: target, mode: 0o644, decided: true}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(originals, "target.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("original saved with mode %v, want 0600", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,129 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// setsFile is the on-disk format of exported backup set definitions.
+type setsFile struct {
+	Sets []BackupSet `yaml:"sets"`
+}
+
+// CustomSetsDir returns the directory imported backup set files are kept in.
+// Every file in it is registered by LoadCustomBackupSets.
+func CustomSetsDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "setup", "sets"), nil
+}
+
+// ExportBackupSets writes all registered backup sets to path as YAML.
+func ExportBackupSets(path string) error {
+	data, err := yaml.Marshal(setsFile{Sets: ListBackupSets()})
+	if err != nil {
+		return fmt.Errorf("could not serialize backup sets: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("could not write %s: %w", path, err)
+	}
+	return nil
+}
+
+// ImportBackupSets registers the backup sets defined in the file at path and
+// keeps a copy in CustomSetsDir so they stay available, warning about each set
+// that replaces a registered one (built-in sets included). It returns the names
+// of the imported sets.
+func ImportBackupSets(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sets, err := parseSetsFile(path, data)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := CustomSetsDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, filepath.Base(path)), data, 0o644); err != nil {
+		return nil, fmt.Errorf("could not store %s: %w", path, err)
+	}
+
+	var names []string
+	for _, set := range sets {
+		if _, ok := GetBackupSet(set.Name); ok {
+			fmt.Fprintf(os.Stderr, "Warning: imported backup set '%s' replaces the one already registered\n", set.Name)
+		}
+		if err := RegisterBackupSet(set); err != nil {
+			return nil, err
+		}
+		names = append(names, set.Name)
+	}
+	return names, nil
+}
+
+// LoadCustomBackupSets registers the sets of every file in CustomSetsDir. A
+// missing directory is not an error.
+func LoadCustomBackupSets() error {
+	dir, err := CustomSetsDir()
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		sets, err := parseSetsFile(path, data)
+		if err != nil {
+			return err
+		}
+		for _, set := range sets {
+			if err := RegisterBackupSet(set); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseSetsFile decodes and validates the backup sets of a sets file.
+func parseSetsFile(path string, data []byte) ([]BackupSet, error) {
+	var f setsFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	if len(f.Sets) == 0 {
+		return nil, fmt.Errorf("%s defines no backup sets", path)
+	}
+	for _, set := range f.Sets {
+		if strings.TrimSpace(set.Name) == "" {
+			return nil, fmt.Errorf("%s: backup set without a name", path)
+		}
+	}
+	return f.Sets, nil
+}
@@ -0,0 +1,139 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// keepBackupSets restores the set registry as it is now once the test ends.
+func keepBackupSets(t *testing.T) {
+	t.Helper()
+	saved := make(map[string]BackupSet, len(backupSets))
+	for k, v := range backupSets {
+		saved[k] = v
+	}
+	t.Cleanup(func() { backupSets = saved })
+}
+
+func TestBackupSetsRoundTrip(t *testing.T) {
+	setTestHome(t)
+	keepBackupSets(t)
+	set := BackupSet{
+		Name:        "RoundTrip",
+		Description: "exported and imported back",
+		Folders: []Folder{{
+			Path:       "~/.config/zed",
+			Contents:   []string{"settings.json", "keymap.json"},
+			Excludes:   []string{"*.bak"},
+			SkipHidden: true,
+			OSPaths:    map[string]string{"darwin": "~/Library/zed"},
+		}},
+		FilesAdd:    []FileAdd{{Path: "~/.zshrc", Update: true, Companions: []string{"~/.zsh_aliases"}}},
+		FilesRemove: []string{"~/.cache/zed"},
+		Excludes:    []string{"cache"},
+	}
+	if err := RegisterBackupSet(set); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "sets.yaml")
+	if err := ExportBackupSets(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sets, err := parseSetsFile(path, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != len(ListBackupSets()) {
+		t.Errorf("exported %d sets, want %d", len(sets), len(ListBackupSets()))
+	}
+
+	delete(backupSets, "roundtrip")
+	names, err := ImportBackupSets(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(names, "RoundTrip") {
+		t.Errorf("imported %v, want RoundTrip among them", names)
+	}
+	got, ok := GetBackupSet("roundtrip")
+	if !ok {
+		t.Fatal("RoundTrip not registered by the import")
+	}
+	if !reflect.DeepEqual(got, set) {
+		t.Errorf("imported set = %+v, want %+v", got, set)
+	}
+
+	// The stored copy registers the sets again on the next run.
+	dir, err := CustomSetsDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sets.yaml")); err != nil {
+		t.Fatalf("import kept no copy: %v", err)
+	}
+	delete(backupSets, "roundtrip")
+	if err := LoadCustomBackupSets(); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := GetBackupSet("roundtrip"); !ok || !reflect.DeepEqual(got, set) {
+		t.Errorf("loaded set = %+v (%v), want %+v", got, ok, set)
+	}
+}
+
+func TestImportBackupSetsReplacesRegistered(t *testing.T) {
+	setTestHome(t)
+	keepBackupSets(t)
+	path := filepath.Join(t.TempDir(), "default.yaml")
+	writeTestFile(t, path, "sets:\n  - name: Default\n    files_add:\n      - path: ~/.gitconfig\n")
+
+	if _, err := ImportBackupSets(path); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := GetBackupSet("default")
+	want := BackupSet{Name: "Default", FilesAdd: []FileAdd{{Path: "~/.gitconfig"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("default set = %+v, want %+v", got, want)
+	}
+}
+
+func TestImportBackupSetsRejectsInvalid(t *testing.T) {
+	setTestHome(t)
+	keepBackupSets(t)
+	for _, tt := range []struct {
+		name, data, wantErr string
+	}{
+		{"unnamed set", "sets:\n  - name: named\n  - description: no name\n", "without a name"},
+		{"blank name", "sets:\n  - name: \"  \"\n", "without a name"},
+		{"no sets", "sets: []\n", "defines no backup sets"},
+		{"not yaml", "sets: [\n", "could not parse"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sets.yaml")
+			writeTestFile(t, path, tt.data)
+			before := len(backupSets)
+			_, err := ImportBackupSets(path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+			}
+			if _, ok := GetBackupSet("named"); ok || len(backupSets) != before {
+				t.Error("a rejected file registered sets")
+			}
+			dir, err := CustomSetsDir()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "sets.yaml")); !os.IsNotExist(err) {
+				t.Errorf("a rejected file was kept in %s", dir)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// uploadManifestSidecar uploads m as the manifest sidecar of archiveName, next to
+// the archive in store.
+func uploadManifestSidecar(store BackupStore, archiveName string, m *Manifest) error {
+	dir, err := os.MkdirTemp("", "setup-sidecar-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, archiveName+manifestSidecarSuffix)
+	if err := writeManifestSidecar(path, m); err != nil {
+		return err
+	}
+	return store.Upload(path, driveBackupPath(archiveName+manifestSidecarSuffix))
+}
+
+// LoadArchiveManifest returns the manifest of the backup archive called name
+// without downloading the archive when possible. It reads the local sidecar, then
+// the sidecar in the backup store (see SelectStore), and only then extracts the
+// manifest from a local copy of the archive.
+func LoadArchiveManifest(name string) (*Manifest, error) {
+	backupsDir, err := localBackupsDir()
+	if err != nil {
+		return nil, err
+	}
+	name = filepath.Base(name)
+
+	if m, err := readManifestFile(manifestSidecarPath(backupsDir, name)); err == nil {
+		return m, nil
+	}
+
+	dir, err := os.MkdirTemp("", "setup-sidecar-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	remote := filepath.Join(dir, name+manifestSidecarSuffix)
+	store, storeErr := SelectStore()
+	if storeErr == nil {
+		storeErr = store.Download(driveBackupPath(name+manifestSidecarSuffix), remote)
+		if storeErr == nil {
+			return readManifestFile(remote)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(backupsDir, name)); err == nil {
+		return extractManifest(filepath.Join(backupsDir, name))
+	}
+	return nil, fmt.Errorf("no manifest found for %s: %w", name, storeErr)
+}
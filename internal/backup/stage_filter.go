@@ -0,0 +1,68 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// JunkPatterns are the file name patterns (filepath.Match syntax) of editor and
+// OS leftovers skipped inside backed-up directories with SkipJunk.
+var JunkPatterns = []string{".DS_Store", "*.swp", "*~"}
+
+// stageOptions tunes how sources are copied into the staging directory.
+type stageOptions struct {
+	// resume skips files already staged with identical contents.
+	resume bool
+	// skipJunk skips entries matching JunkPatterns inside directories.
+	skipJunk bool
+	// keepExisting honors FileAdd.Update: files of entries with Update false
+	// are only copied when nothing exists at their destination yet.
+	keepExisting bool
+}
+
+// skipFunc returns the filter for entries found while walking the directories of
+// folder (nil for FilesAdd paths), or nil when nothing is skipped. It is given
+// the path of each entry. Paths listed explicitly in a set are never skipped,
+// only what is found below them.
+func (so stageOptions) skipFunc(folder *Folder) func(path string) bool {
+	hidden := folder != nil && folder.SkipHidden
+	excluded := folder.excludeFunc()
+	if !hidden && !so.skipJunk && excluded == nil {
+		return nil
+	}
+	return func(path string) bool {
+		name := filepath.Base(path)
+		if hidden && strings.HasPrefix(name, ".") {
+			return true
+		}
+		if (hidden || so.skipJunk) && isJunk(name) {
+			return true
+		}
+		return excluded != nil && excluded(path)
+	}
+}
+
+// isJunk reports whether name matches one of JunkPatterns.
+func isJunk(name string) bool {
+	for _, pattern := range JunkPatterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// walkSkipping is filepath.Walk that leaves out entries below root whose path
+// matches skip (pruning skipped directories).
+func walkSkipping(root string, skip func(path string) bool, fn filepath.WalkFunc) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && skip != nil && path != root && skip(path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return fn(path, info, err)
+	})
+}
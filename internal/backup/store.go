@@ -0,0 +1,88 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// StoreTimeout bounds each upload, download and listing of the backup store so
+// a stalled connection aborts instead of hanging; zero disables it.
+var StoreTimeout time.Duration
+
+// storeContext returns the context for one backup store operation.
+func storeContext() (context.Context, context.CancelFunc) {
+	if StoreTimeout > 0 {
+		return context.WithTimeout(context.Background(), StoreTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// BackupStore is the remote storage archives are uploaded to and restored from.
+// Remote paths are slash separated (e.g. "linux/backups/<archive>").
+type BackupStore interface {
+	Upload(localPath, remotePath string) error
+	// Download fails with an error wrapping os.ErrNotExist when the store holds
+	// nothing at remotePath.
+	Download(remotePath, localPath string) error
+	// Latest returns the name of the most recent backup archive below prefix.
+	Latest(prefix string) (string, error)
+}
+
+// SelectStore returns the store named by $BACKUP_STORE: "drive" (the default)
+// or "s3".
+func SelectStore() (BackupStore, error) {
+	switch name := strings.ToLower(os.Getenv("BACKUP_STORE")); name {
+	case "", "drive":
+		return driveStore{}, nil
+	case "s3":
+		store, err := newS3Store()
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown BACKUP_STORE %q (use drive or s3)", name)
+	}
+}
+
+// isDriveStore reports whether store is Google Drive, which supports the extras
+// (tags, upload verification, manifest sidecars) other stores lack.
+func isDriveStore(store BackupStore) bool {
+	_, ok := store.(driveStore)
+	return ok
+}
+
+func (driveStore) String() string { return "Google Drive" }
+
+// driveStore is the Google Drive BackupStore.
+type driveStore struct {
+	// appProperties are set on uploaded files (see tagProperties).
+	appProperties map[string]string
+	// progress, when set, is called as uploads proceed.
+	progress func(done, total int64)
+}
+
+func (s driveStore) Upload(localPath, remotePath string) error {
+	ctx, cancel := storeContext()
+	defer cancel()
+	return uploadToDrive(ctx, localPath, remotePath, s.appProperties, s.progress)
+}
+
+func (driveStore) Download(remotePath, localPath string) error {
+	ctx, cancel := storeContext()
+	defer cancel()
+	return DownloadFromDriveContext(ctx, remotePath, localPath)
+}
+
+func (driveStore) Latest(prefix string) (string, error) {
+	ctx, cancel := storeContext()
+	defer cancel()
+	f, err := latestDriveBackupFile(ctx, strings.Split(strings.Trim(prefix, "/"), "/"), "")
+	if err != nil {
+		return "", err
+	}
+	return f.Name, nil
+}
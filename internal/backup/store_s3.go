@@ -0,0 +1,157 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// s3Store is a BackupStore on an S3-compatible bucket (AWS S3, MinIO, ...). It
+// is configured from the environment (or the credentials .env):
+//
+//	BACKUP_S3_BUCKET    bucket name (required)
+//	BACKUP_S3_ENDPOINT  endpoint URL for S3-compatible servers, e.g. https://minio.lan:9000
+//	BACKUP_S3_REGION    region, by default us-east-1
+//	AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and optionally AWS_SESSION_TOKEN
+type s3Store struct {
+	client *s3.Client
+	bucket string
+}
+
+// newS3Store returns the s3Store described by the environment.
+func newS3Store() (*s3Store, error) {
+	if err := loadCredentialsEnv(); err != nil {
+		return nil, err
+	}
+	bucket := os.Getenv("BACKUP_S3_BUCKET")
+	if bucket == "" {
+		return nil, fmt.Errorf("BACKUP_S3_BUCKET is not set")
+	}
+	keyID, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if keyID == "" || secret == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set for the s3 store")
+	}
+	region := os.Getenv("BACKUP_S3_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	opts := s3.Options{
+		Region:      region,
+		Credentials: credentials.NewStaticCredentialsProvider(keyID, secret, os.Getenv("AWS_SESSION_TOKEN")),
+	}
+	if endpoint := os.Getenv("BACKUP_S3_ENDPOINT"); endpoint != "" {
+		opts.BaseEndpoint = aws.String(endpoint)
+		// Self-hosted servers rarely have per-bucket DNS names.
+		opts.UsePathStyle = true
+	}
+	return &s3Store{client: s3.New(opts), bucket: bucket}, nil
+}
+
+func (s *s3Store) String() string { return "s3://" + s.bucket }
+
+func (s *s3Store) Upload(localPath, remotePath string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("unable to open local file: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := storeContext()
+	defer cancel()
+	var body io.ReadSeeker = f
+	if TransferLimit > 0 {
+		body = newLimitedReader(ctx, f)
+	}
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(s3Key(remotePath)),
+		Body:          body,
+		ContentLength: aws.Int64(info.Size()),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to upload to s3://%s/%s: %w", s.bucket, s3Key(remotePath), err)
+	}
+	return nil
+}
+
+func (s *s3Store) Download(remotePath, localPath string) error {
+	ctx, cancel := storeContext()
+	defer cancel()
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s3Key(remotePath)),
+	})
+	var noKey *types.NoSuchKey
+	if errors.As(err, &noKey) {
+		return fmt.Errorf("s3://%s/%s: %w", s.bucket, s3Key(remotePath), os.ErrNotExist)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to download s3://%s/%s: %w", s.bucket, s3Key(remotePath), err)
+	}
+	defer out.Body.Close()
+
+	f, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("unable to create local file: %w", err)
+	}
+	var body io.Reader = out.Body
+	if TransferLimit > 0 {
+		body = newLimitedReader(ctx, body)
+	}
+	_, err = io.Copy(f, body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(localPath)
+		return fmt.Errorf("unable to save downloaded file: %w", err)
+	}
+	return nil
+}
+
+func (s *s3Store) Latest(prefix string) (string, error) {
+	ctx, cancel := storeContext()
+	defer cancel()
+	p := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s3Key(prefix) + "/"),
+	})
+	var latest string
+	var latestTime int64
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return "", fmt.Errorf("unable to list s3://%s/%s: %w", s.bucket, s3Key(prefix), err)
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if _, ok := archiveFormat(key); !ok || obj.LastModified == nil {
+				continue
+			}
+			if t := obj.LastModified.UnixNano(); latest == "" || t > latestTime {
+				latest, latestTime = path.Base(key), t
+			}
+		}
+	}
+	if latest == "" {
+		return "", withKind(ErrNoBackupFound, fmt.Errorf("no backups found in s3://%s/%s", s.bucket, s3Key(prefix)))
+	}
+	return latest, nil
+}
+
+// s3Key turns a slash separated remote path into an object key.
+func s3Key(remotePath string) string {
+	return strings.Trim(remotePath, "/")
+}
@@ -0,0 +1,92 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"setup/shared/utils"
+)
+
+// symlinkAction decides what restoring the archived symlink at path to target
+// does under cfg's overwrite policy: ActionCreate, ActionOverwrite,
+// ActionUnchanged (same link target) or ActionSkip. Links carry no contents or
+// reliable mtime, so if-newer replaces any differing link, like if-differ. A
+// directory at target is never replaced.
+func (cfg applyConfig) symlinkAction(path, target string) (string, error) {
+	current, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return ActionCreate, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if current.IsDir() {
+		return ActionSkip, nil
+	}
+	link, err := os.Readlink(path)
+	if err != nil {
+		return "", err
+	}
+	if current.Mode()&os.ModeSymlink != 0 {
+		if existing, err := os.Readlink(target); err == nil && existing == link {
+			return ActionUnchanged, nil
+		}
+	}
+	switch cfg.Overwrite {
+	case utils.OverwriteNever, utils.OverwriteMissingOnly:
+		return ActionSkip, nil
+	case utils.OverwritePrompt:
+		if cfg.Confirm == nil {
+			return ActionSkip, nil
+		}
+		ok, err := cfg.Confirm(target)
+		if err != nil || !ok {
+			return ActionSkip, err
+		}
+	}
+	return ActionOverwrite, nil
+}
+
+// restoreSymlink recreates the archived symlink at path as target, saving what
+// it replaces under originalsDir. It reports whether the link was written; in
+// dry-run mode it only prints what would happen.
+func (cfg applyConfig) restoreSymlink(path, target, originalsDir string) (bool, error) {
+	action, err := cfg.symlinkAction(path, target)
+	if err != nil {
+		return false, err
+	}
+	if cfg.DryRun {
+		switch action {
+		case ActionCreate:
+			fmt.Printf("Would create %s (symlink)\n", target)
+		case ActionUnchanged:
+			fmt.Printf("Unchanged %s\n", target)
+		case ActionOverwrite:
+			fmt.Printf("Would overwrite %s (symlink differs; original kept for rollback)\n", target)
+		default:
+			fmt.Printf("Would keep %s (differs; kept by overwrite policy)\n", target)
+		}
+		return false, nil
+	}
+	if action != ActionCreate && action != ActionOverwrite {
+		return false, nil
+	}
+
+	if action == ActionOverwrite {
+		backupPath := filepath.Join(originalsDir, trimLeadingSlash(target))
+		info, err := os.Lstat(target)
+		if err == nil && info.Mode()&os.ModeSymlink != 0 {
+			err = utils.CopySymlink(target, backupPath)
+		} else if err == nil {
+			err = utils.CopyFile(target, backupPath, info.Mode().Perm())
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return false, fmt.Errorf("could not keep a copy of %s before overwriting it: %w", target, err)
+		}
+	}
+	if err := utils.CopySymlink(path, target); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,81 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"setup/shared/utils"
+)
+
+func TestRestoreSymlink(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		link     string
+		existing string // contents of a regular file already at the target
+	}{
+		{name: "relative", link: "../real/config"},
+		{name: "absolute", link: "/etc/hostname"},
+		{name: "dangling", link: "missing/nowhere"},
+		{name: "replaces regular file", link: "../real/config", existing: "local\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archived := filepath.Join(dir, "tmp", "link")
+			if err := os.MkdirAll(filepath.Dir(archived), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink(tt.link, archived); err != nil {
+				t.Fatal(err)
+			}
+			target := filepath.Join(dir, "home", "link")
+			if tt.existing != "" {
+				writeTestFile(t, target, tt.existing)
+			}
+			originals := filepath.Join(dir, "originals")
+
+			cfg := applyConfig{Overwrite: utils.OverwriteAlways}
+			restored, err := cfg.restoreSymlink(archived, target, originals)
+			if err != nil || !restored {
+				t.Fatalf("restoreSymlink = %v, %v", restored, err)
+			}
+			info, err := os.Lstat(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode()&os.ModeSymlink == 0 {
+				t.Fatalf("%s is %v, want a symlink", target, info.Mode())
+			}
+			if got, _ := os.Readlink(target); got != tt.link {
+				t.Errorf("link points at %q, want %q", got, tt.link)
+			}
+
+			if tt.existing != "" {
+				data, err := os.ReadFile(filepath.Join(originals, trimLeadingSlash(target)))
+				if err != nil || string(data) != tt.existing {
+					t.Errorf("replaced file not kept: %q, %v", data, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreSymlinkKeepsDirectory(t *testing.T) {
+	dir := t.TempDir()
+	archived := filepath.Join(dir, "link")
+	if err := os.Symlink("elsewhere", archived); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(dir, "target")
+	if err := os.Mkdir(target, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := applyConfig{Overwrite: utils.OverwriteAlways}
+	if restored, err := cfg.restoreSymlink(archived, target, filepath.Join(dir, "originals")); err != nil || restored {
+		t.Fatalf("restoreSymlink = %v, %v; want the directory skipped", restored, err)
+	}
+	if info, err := os.Lstat(target); err != nil || !info.IsDir() {
+		t.Errorf("directory replaced: %v, %v", info, err)
+	}
+}
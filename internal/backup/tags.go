@@ -0,0 +1,108 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// tagPropertyPrefix prefixes the Drive appProperties keys marking an archive's
+// tags ("tag-weekly" = "true"), which lets Drive filter backups by tag.
+const tagPropertyPrefix = "tag-"
+
+// validTag matches the labels accepted by ValidateTag.
+var validTag = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// ValidateTag fails unless tag is a usable backup label: letters, digits, ".",
+// "_" or "-", at most 64 characters.
+func ValidateTag(tag string) error {
+	if !validTag.MatchString(tag) {
+		return fmt.Errorf("invalid tag %q (use letters, digits, '.', '_' or '-')", tag)
+	}
+	return nil
+}
+
+// tagProperties returns the Drive appProperties recording tags.
+func tagProperties(tags []string) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	props := make(map[string]string, len(tags))
+	for _, t := range tags {
+		props[tagPropertyPrefix+t] = "true"
+	}
+	return props
+}
+
+// DriveBackupTags returns the tags recorded in the appProperties of a Drive backup.
+func DriveBackupTags(f *drive.File) []string {
+	var tags []string
+	for key := range f.AppProperties {
+		if t, ok := strings.CutPrefix(key, tagPropertyPrefix); ok {
+			tags = append(tags, t)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// tagQuery returns the Drive query clause restricting a listing to tag, or ""
+// when tag is empty.
+func tagQuery(tag string) string {
+	if tag == "" {
+		return ""
+	}
+	return fmt.Sprintf(" and appProperties has { key='%s' and value='true' }", tagPropertyPrefix+tag)
+}
+
+// ListDriveBackups returns the backups in the Drive backup folder, most recently
+// modified first. When tag is not empty only backups carrying it are listed.
+func ListDriveBackups(tag string) ([]*drive.File, error) {
+	if tag != "" {
+		if err := ValidateTag(tag); err != nil {
+			return nil, err
+		}
+	}
+	srv, err := getDriveService()
+	if err != nil {
+		return nil, err
+	}
+	parentId, missing, err := findFolder(context.Background(), srv, driveBackupFolder())
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		return nil, nil
+	}
+	q := fmt.Sprintf("%s and not name contains '%s' and '%s' in parents and trashed = false", archiveNameQuery, manifestSidecarSuffix, parentId) + tagQuery(tag)
+	var files []*drive.File
+	call := srv.Files.List().Q(q).Fields("nextPageToken, files(id, name, size, modifiedTime, appProperties)").OrderBy("modifiedTime desc")
+	for {
+		r, err := listDriveFiles(context.Background(), call)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list backup files: %w", err)
+		}
+		files = append(files, r.Files...)
+		if r.NextPageToken == "" {
+			return files, nil
+		}
+		call.PageToken(r.NextPageToken)
+	}
+}
+
+// GetLatestDriveBackupWithTag is like GetLatestDriveBackup, but only considers
+// backups tagged with tag.
+func GetLatestDriveBackupWithTag(tag string) (string, error) {
+	if err := ValidateTag(tag); err != nil {
+		return "", err
+	}
+	f, err := latestDriveBackupFile(context.Background(), driveBackupFolder(), tag)
+	if err != nil {
+		return "", err
+	}
+	return f.Name, nil
+}
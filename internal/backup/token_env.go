@@ -0,0 +1,89 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gofrs/flock"
+	"golang.org/x/oauth2"
+
+	"setup/shared/utils"
+)
+
+// persistingTokenSource wraps the Drive token source and writes every newly
+// minted access token back to the credentials .env, so the next run does not
+// start from a stale token.
+type persistingTokenSource struct {
+	src oauth2.TokenSource
+
+	mu   sync.Mutex
+	last string
+}
+
+// Token implements oauth2.TokenSource.
+func (s *persistingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.src.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tok.AccessToken != s.last {
+		s.last = tok.AccessToken
+		if err := saveTokenToEnv(tok); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not save the refreshed token: %v\n", err)
+		}
+	}
+	return tok, nil
+}
+
+// credentialsEnvPath returns the .env the credentials were loaded from (see
+// loadCredentialsEnv), or "" when they came from the environment only.
+func credentialsEnvPath() string {
+	if CredentialsEnvFile != "" {
+		return CredentialsEnvFile
+	}
+	if _, err := os.Stat(".env"); err == nil {
+		return ".env"
+	}
+	return ""
+}
+
+// saveTokenToEnv writes the access token, expiry and type of tok (and its refresh
+// token, if Google rotated it) into the credentials .env, keeping the other
+// variables and comments. The read-modify-write holds a lock next to the file so concurrent
+// runs do not drop each other's changes. The process environment is updated too.
+func saveTokenToEnv(tok *oauth2.Token) error {
+	values := map[string]string{
+		"GOOGLE_ACCESS_TOKEN": tok.AccessToken,
+		"GOOGLE_TOKEN_TYPE":   tok.Type(),
+		"GOOGLE_TOKEN_EXPIRY": tok.Expiry.Format(time.RFC3339Nano),
+	}
+	if tok.RefreshToken != "" {
+		values["GOOGLE_REFRESH_TOKEN"] = tok.RefreshToken
+	}
+	for k, v := range values {
+		os.Setenv(k, v)
+	}
+
+	path := credentialsEnvPath()
+	if path == "" {
+		return nil
+	}
+	lock := flock.New(path + ".lock")
+	if err := lock.Lock(); err != nil {
+		return fmt.Errorf("could not lock %s: %w", path, err)
+	}
+	defer lock.Unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := utils.UpdateEnvFile(path, values, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("could not write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joho/godotenv"
+	"golang.org/x/oauth2"
+)
+
+func TestSaveTokenToEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	writeTestFile(t, path, "# Drive\nGOOGLE_CLIENT_ID=id\nGOOGLE_ACCESS_TOKEN=old\nGOOGLE_REFRESH_TOKEN=refresh\n")
+	if err := os.Chmod(path, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	old := CredentialsEnvFile
+	CredentialsEnvFile = path
+	t.Cleanup(func() { CredentialsEnvFile = old })
+	for _, k := range []string{"GOOGLE_ACCESS_TOKEN", "GOOGLE_TOKEN_TYPE", "GOOGLE_TOKEN_EXPIRY"} {
+		t.Setenv(k, "")
+	}
+
+	tok := &oauth2.Token{AccessToken: "new", TokenType: "Bearer", Expiry: time.Now().Add(time.Hour)}
+	if err := saveTokenToEnv(tok); err != nil {
+		t.Fatal(err)
+	}
+	env, err := godotenv.Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env["GOOGLE_ACCESS_TOKEN"] != "new" || env["GOOGLE_REFRESH_TOKEN"] != "refresh" || env["GOOGLE_CLIENT_ID"] != "id" {
+		t.Errorf("unexpected .env values %v", env)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "# Drive\n") {
+		t.Errorf("comment dropped:\n%s", data)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("mode of %s changed: %v, %v", path, info, err)
+	}
+}
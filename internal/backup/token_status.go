@@ -0,0 +1,44 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// TokenStatus is the state of the Google credentials reported by CheckToken.
+type TokenStatus struct {
+	// Current is the token as loaded from the credentials .env.
+	Current *oauth2.Token
+	// Valid reports whether Current's access token had not expired yet.
+	Valid bool
+	// Refreshed is the token obtained by refreshing, nil when the refresh
+	// failed (see RefreshErr) or there was no refresh token to try.
+	Refreshed  *oauth2.Token
+	RefreshErr error
+}
+
+// CheckToken loads the Google credentials like every Drive command and tries
+// to refresh the access token, without any Drive request. A refreshed token is
+// saved back to the credentials .env.
+func CheckToken() (*TokenStatus, error) {
+	config, token, err := getCredentials()
+	if err != nil {
+		return nil, err
+	}
+	status := &TokenStatus{Current: token, Valid: token.Valid()}
+	if token.RefreshToken == "" {
+		status.RefreshErr = fmt.Errorf("no GOOGLE_REFRESH_TOKEN to refresh with")
+		return status, nil
+	}
+
+	// Marking the token expired makes the token source refresh it even when
+	// the current one is still valid.
+	stale := *token
+	stale.Expiry = time.Now().Add(-time.Minute)
+	ts := &persistingTokenSource{src: config.TokenSource(context.Background(), &stale), last: token.AccessToken}
+	status.Refreshed, status.RefreshErr = ts.Token()
+	return status, nil
+}
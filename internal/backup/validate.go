@@ -0,0 +1,49 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ValidatePatterns are the base-name patterns (filepath.Match syntax) of restored
+// files that are parsed as JSON when post-restore validation is enabled.
+var ValidatePatterns = []string{"*.json"}
+
+// validateRestoredFiles parses every path matching ValidatePatterns as JSON. Invalid
+// files are reported as warnings, or as an error when strict is set.
+func validateRestoredFiles(paths []string, strict bool) error {
+	var invalid []string
+	for _, path := range paths {
+		if !matchesValidatePattern(path) {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not read %s for validation: %v\n", path, err)
+			invalid = append(invalid, path)
+			continue
+		}
+		var v any
+		if err := json.Unmarshal(data, &v); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: restored file %s is not valid JSON: %v\n", path, err)
+			invalid = append(invalid, path)
+		}
+	}
+	if len(invalid) > 0 && strict {
+		return fmt.Errorf("%d restored file(s) failed validation", len(invalid))
+	}
+	return nil
+}
+
+// matchesValidatePattern reports whether the base name of path matches any of ValidatePatterns.
+func matchesValidatePattern(path string) bool {
+	base := filepath.Base(path)
+	for _, pattern := range ValidatePatterns {
+		if ok, _ := filepath.Match(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// VerifyReport is the outcome of VerifyBackup.
+type VerifyReport struct {
+	Archive string
+	// HasManifest is false for archives created before manifests existed; only
+	// their extraction is checked.
+	HasManifest bool
+	// OK, Missing and Corrupt count the manifest's files found with the right
+	// checksum, absent from the archive and found with another checksum.
+	OK      int
+	Missing int
+	Corrupt int
+	// Inherited counts the files of an increment stored in its parent backups,
+	// which are not checked.
+	Inherited int
+	// Problems describes every missing and corrupt file.
+	Problems []error
+}
+
+// Passed reports whether no file was missing or corrupt.
+func (r *VerifyReport) Passed() bool {
+	return r.Missing == 0 && r.Corrupt == 0
+}
+
+// VerifyBackup extracts the archive at archivePath into a temporary directory and,
+// when it holds a manifest, checks every file the manifest lists against its
+// checksum. An archive that does not extract cleanly is an error; missing and
+// corrupt files are reported.
+func VerifyBackup(archivePath string) (*VerifyReport, error) {
+	dir, err := os.MkdirTemp("", "setup-verify-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := extractArchive(archivePath, dir); err != nil {
+		return nil, fmt.Errorf("could not extract %s: %w", filepath.Base(archivePath), err)
+	}
+	m, err := ReadManifest(dir)
+	if os.IsNotExist(err) {
+		return &VerifyReport{Archive: filepath.Base(archivePath)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	report, err := checkExtracted(dir, m)
+	if err != nil {
+		return nil, err
+	}
+	report.Archive = filepath.Base(archivePath)
+	return report, nil
+}
+
+// FetchBackup returns the local path of the backup called name: name itself when
+// it is an existing file, or else the archive downloaded from the backup store
+// into the local backups dir. An empty name fetches the latest backup.
+func FetchBackup(name string) (string, error) {
+	backupsDir, err := localBackupsDir()
+	if err != nil {
+		return "", err
+	}
+	store, err := SelectStore()
+	if err != nil {
+		return "", err
+	}
+	path, _, err := locateBackup(store, backupsDir, name, false)
+	return path, err
+}
+
+// checkExtracted compares the files extracted into dir with m.
+func checkExtracted(dir string, m *Manifest) (*VerifyReport, error) {
+	report := &VerifyReport{HasManifest: true}
+	seen := map[string]bool{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == manifestFileName {
+			return err
+		}
+		f, ok := m.find(filepath.ToSlash(rel))
+		if !ok {
+			return nil
+		}
+		seen[f.Path] = true
+		sum, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		if sum != f.SHA256 {
+			report.Corrupt++
+			report.Problems = append(report.Problems, fmt.Errorf("%w: %s: expected %s, got %s", ErrChecksumMismatch, filepath.ToSlash(rel), f.SHA256, sum))
+			return nil
+		}
+		report.OK++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range m.Files {
+		switch {
+		case seen[f.Path]:
+		case f.FromParent:
+			report.Inherited++
+		default:
+			report.Missing++
+			report.Problems = append(report.Problems, fmt.Errorf("%w: %s is listed in the manifest but missing from the archive", ErrChecksumMismatch, f.Path))
+		}
+	}
+	return report, nil
+}
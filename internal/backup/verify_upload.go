@@ -0,0 +1,106 @@
+package backup
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// VerifyDriveUpload checks that the file stored at drivePath matches localPath.
+// It compares the size and the md5 checksum Drive computed on its side; with full
+// set it also downloads the whole file again and compares its sha256.
+func VerifyDriveUpload(localPath, drivePath string, full bool) error {
+	srv, err := getDriveService()
+	if err != nil {
+		return err
+	}
+	remote, err := lookupDriveFile(srv, drivePath, "id, size, md5Checksum")
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	if remote.Size != info.Size() {
+		return fmt.Errorf("upload verification failed for %s: Drive has %d bytes, local file has %d", drivePath, remote.Size, info.Size())
+	}
+	localMD5, err := hashFileWith(localPath, md5.New())
+	if err != nil {
+		return err
+	}
+	if remote.Md5Checksum == "" {
+		return fmt.Errorf("upload verification failed for %s: Drive reported no checksum", drivePath)
+	}
+	if !strings.EqualFold(remote.Md5Checksum, localMD5) {
+		return fmt.Errorf("upload verification failed for %s: Drive md5 %s, local md5 %s", drivePath, remote.Md5Checksum, localMD5)
+	}
+	if !full {
+		return nil
+	}
+
+	resp, err := srv.Files.Get(remote.Id).Download()
+	if err != nil {
+		return fmt.Errorf("unable to download file for verification: %w", err)
+	}
+	defer resp.Body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return fmt.Errorf("unable to download file for verification: %w", err)
+	}
+	localSHA, err := hashFile(localPath)
+	if err != nil {
+		return err
+	}
+	if remoteSHA := hex.EncodeToString(h.Sum(nil)); remoteSHA != localSHA {
+		return fmt.Errorf("upload verification failed for %s: downloaded copy differs from the local file", drivePath)
+	}
+	return nil
+}
+
+// VerifyLocalChecksum fails unless the md5 of the file at localPath is expectedMD5
+// (hex encoded, as Drive reports it in md5Checksum).
+func VerifyLocalChecksum(localPath, expectedMD5 string) error {
+	sum, err := hashFileWith(localPath, md5.New())
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(sum, expectedMD5) {
+		return fmt.Errorf("checksum mismatch: expected %s got %s", expectedMD5, sum)
+	}
+	return nil
+}
+
+// lookupDriveFile returns the requested fields of the file stored at drivePath.
+func lookupDriveFile(srv *drive.Service, drivePath, fields string) (*drive.File, error) {
+	drivePath = strings.TrimPrefix(drivePath, "/")
+	parts := strings.Split(drivePath, "/")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("drivePath must be at least linux/backups/filename")
+	}
+	parentId, missing, err := findFolder(context.Background(), srv, parts[:len(parts)-1])
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("file not found in Google Drive: %s", drivePath)
+	}
+	q := fmt.Sprintf("name = '%s' and '%s' in parents and trashed = false", parts[len(parts)-1], parentId)
+	r, err := srv.Files.List().Q(q).Fields(googleapi.Field("files(" + fields + ")")).Do()
+	if err != nil {
+		return nil, fmt.Errorf("unable to search for file: %w", err)
+	}
+	if len(r.Files) == 0 {
+		return nil, fmt.Errorf("file not found in Google Drive: %s", drivePath)
+	}
+	return r.Files[0], nil
+}
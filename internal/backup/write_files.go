@@ -1,43 +1,106 @@
 package backup
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"sort"
 	"strings"
+
+	"setup/shared/utils"
 )
 
 // Folder represents a folder and its contents.
 type Folder struct {
-	Path     string
-	Contents []string
+	Path string `yaml:"path"`
+	// Contents are the files and directories of the folder to back up, relative
+	// to Path. Entries may be glob patterns ("messages.db*", "**/*.json"); an
+	// empty list backs up the whole folder.
+	Contents []string `yaml:"contents"`
+	// Excludes are glob patterns, relative to Path ("conversations",
+	// "**/*.log"), of files and directories left out while copying Contents.
+	Excludes []string `yaml:"excludes,omitempty"`
+	// SkipHidden leaves hidden files (and JunkPatterns) found inside the folder's
+	// directories out of the backup.
+	SkipHidden bool `yaml:"skip_hidden,omitempty"`
+	// OSPaths overrides Path per runtime.GOOS (e.g. "linux", "darwin").
+	OSPaths map[string]string `yaml:"os_paths,omitempty"`
+	// PreProcess transforms entries of Contents, keyed by content, before they
+	// are archived (see PreProcess).
+	PreProcess map[string]PreProcess `yaml:"pre_process,omitempty"`
 }
 
 // FileAdd represents a file to add and whether it should be updated.
 type FileAdd struct {
-	Path   string
-	Update bool
+	Path string `yaml:"path"`
+	// Update overwrites an existing copy on apply. Without it the file (and its
+	// companions) is only created where it does not exist yet.
+	Update bool `yaml:"update"`
+	// OSPaths overrides Path per runtime.GOOS (e.g. "linux", "darwin").
+	OSPaths map[string]string `yaml:"os_paths,omitempty"`
+	// Companions are files or directories the file depends on (e.g. the
+	// oh-my-zsh custom dir for .zshrc). They are backed up and restored with it.
+	Companions []string `yaml:"companions,omitempty"`
+	// PreProcess transforms the file (not its companions) before it is archived.
+	PreProcess *PreProcess `yaml:"pre_process,omitempty"`
+}
+
+// forOS returns f with Path resolved for the running platform.
+func (f FileAdd) forOS() FileAdd {
+	if p, ok := f.OSPaths[runtime.GOOS]; ok {
+		f.Path = p
+	}
+	return f
+}
+
+// forOS returns f with Path resolved for the running platform.
+func (f Folder) forOS() Folder {
+	if p, ok := f.OSPaths[runtime.GOOS]; ok {
+		f.Path = p
+	}
+	return f
+}
+
+// paths returns the path of the file followed by its companions.
+func (f FileAdd) paths() []string {
+	return append([]string{f.Path}, f.Companions...)
 }
 
 // BackupSet is a modular grouping of folders/files that can be backed up.
+// FilesRemove lists paths that apply deletes on the target machine (e.g. shell
+// defaults superseded by the restored configuration). create records them in the
+// manifest; they are never archived.
 type BackupSet struct {
-	Name        string
-	Description string
-	Folders     []Folder
-	FilesAdd    []FileAdd
-	FilesRemove []string
+	Name        string    `yaml:"name"`
+	Description string    `yaml:"description,omitempty"`
+	Folders     []Folder  `yaml:"folders,omitempty"`
+	FilesAdd    []FileAdd `yaml:"files_add,omitempty"`
+	FilesRemove []string  `yaml:"files_remove,omitempty"`
+	// Excludes are added to the Excludes of every folder of the set.
+	Excludes []string `yaml:"excludes,omitempty"`
 }
 
+// aliceSettingsOSPaths locates the Alice preferences outside ~/Library on Linux.
+var aliceSettingsOSPaths = map[string]string{"linux": "{config}/Alice/preferences/settings.json"}
+
 // ConfigurationBackupSet is the primary full backup configuration.
 var ConfigurationBackupSet = BackupSet{
 	Name:        "default",
 	Description: "Full system/home backup",
 	Folders: []Folder{
 		{
-			Path:     "~/Library/Cache/Alice/messages",
-			Contents: []string{"messages.db", "messages.db-shm", "messages.db-wal"},
+			Path:       "~/Library/Cache/Alice/messages",
+			Contents:   []string{"messages.db", "messages.db-shm", "messages.db-wal"},
+			OSPaths:    map[string]string{"linux": "{cache}/Alice/messages"},
+			PreProcess: messagesDBPreProcess,
 		},
 		{
 			Path:     "~/.config/zed",
 			Contents: []string{"keymap.json", "prompts/prompts-library-db.0.mdb", "settings.json", "themes/ask-dark+.json"},
+			// Chat history and downloaded extensions are large and machine specific.
+			Excludes: []string{"conversations", "extensions"},
 		},
 	},
 	FilesAdd: []FileAdd{
@@ -46,10 +109,10 @@ var ConfigurationBackupSet = BackupSet{
 		{Path: "~/setup/.env", Update: true},
 		{Path: "~/.wget-hsts", Update: true},
 		{Path: "~/.XCompose", Update: true},
-		{Path: "~/.zshrc", Update: true},
+		{Path: "~/.zshrc", Update: true, Companions: []string{"~/.oh-my-zsh/custom"}},
 		{Path: "~/Desktop/github.com/alice-bnuy/discordcore/.env", Update: true},
 		{Path: "~/github.com/alice-bnuy/alicebot/.env", Update: true},
-		{Path: "~/Library/Application Support/Alice/preferences/settings.json", Update: true},
+		{Path: "~/Library/Application Support/Alice/preferences/settings.json", Update: true, OSPaths: aliceSettingsOSPaths},
 		{Path: "/etc/prime-discrete", Update: true},
 	},
 	FilesRemove: []string{
@@ -75,10 +138,12 @@ var AliceBotBackupSet = BackupSet{
 				"messages.db-shm",
 				"messages.db-wal",
 			},
+			OSPaths:    map[string]string{"linux": "{cache}/Alice/messages"},
+			PreProcess: messagesDBPreProcess,
 		},
 	},
 	FilesAdd: []FileAdd{
-		{Path: "~/Library/Application Support/Alice/preferences/settings.json", Update: true},
+		{Path: "~/Library/Application Support/Alice/preferences/settings.json", Update: true, OSPaths: aliceSettingsOSPaths},
 		{Path: "~/github.com/alice-bnuy/alicebot/.env", Update: true},
 	},
 }
@@ -105,72 +170,162 @@ func init() {
 	recomputeActiveSlices()
 }
 
+// backupSources is the merged view of one or more backup sets: the inputs of a
+// single create run.
+type backupSources struct {
+	Sets        []BackupSet
+	Folders     []Folder
+	FilesAdd    []FileAdd
+	FilesRemove []string
+}
+
+// setNames returns the names of the sets the sources were merged from.
+func (src backupSources) setNames() []string {
+	names := make([]string, 0, len(src.Sets))
+	for _, set := range src.Sets {
+		names = append(names, set.Name)
+	}
+	return names
+}
+
+// noUpdatePaths returns the paths (relative to /, slash separated) of the files
+// and companions of the FileAdd entries with Update false.
+func (src backupSources) noUpdatePaths() []string {
+	var paths []string
+	for _, f := range src.FilesAdd {
+		if f.Update {
+			continue
+		}
+		for _, p := range f.paths() {
+			expanded, err := expandHome(p)
+			if err != nil {
+				continue
+			}
+			paths = append(paths, filepath.ToSlash(trimLeadingSlash(filepath.Clean(expanded))))
+		}
+	}
+	return paths
+}
+
+// activeSources returns the sources described by the active sets and the legacy global slices.
+func activeSources() backupSources {
+	return backupSources{
+		Sets:        ActiveBackupSets,
+		Folders:     Folders,
+		FilesAdd:    FilesAdd,
+		FilesRemove: FilesRemove,
+	}
+}
+
+// resolveBackupSets looks up the named sets (case-insensitive), failing on unknown names.
+func resolveBackupSets(names []string) ([]BackupSet, error) {
+	var sets []BackupSet
+	for _, name := range names {
+		set, ok := backupSets[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown backup set %q (available: %s)", name, strings.Join(ListBackupSetNames(), ", "))
+		}
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+// StrictDuplicates makes merging backup sets panic when a folder, FilesAdd or
+// FilesRemove path is declared by more than one set, instead of keeping the first
+// occurrence.
+var StrictDuplicates bool
+
+// Debug enables diagnostic output on stderr. It defaults to whether $SETUP_DEBUG
+// is set.
+var Debug = os.Getenv("SETUP_DEBUG") != ""
+
+// debugf prints a diagnostic line when Debug is set.
+func debugf(format string, args ...any) {
+	if Debug {
+		fmt.Fprintf(os.Stderr, "Debug: "+format+"\n", args...)
+	}
+}
+
 // recomputeActiveSlices merges all active backup sets into the legacy global slices.
-// Any duplicate paths (case-insensitive) across FilesAdd, FilesRemove, or Folder paths
-// cause an immediate panic with a descriptive error so that developers must resolve
-// the conflict instead of relying on silent deduplication.
+// Duplicate paths are handled as described on mergeBackupSets.
 func recomputeActiveSlices() {
+	src := mergeBackupSets(ActiveBackupSets)
+	Folders = src.Folders
+	FilesAdd = src.FilesAdd
+	FilesRemove = src.FilesRemove
+}
+
+// mergeBackupSets merges sets into a single backupSources without touching any
+// package-level state. Paths are compared case-insensitively and the first
+// occurrence wins: a folder declared again only adds the contents it did not
+// list yet, and repeated FilesAdd and FilesRemove entries are dropped. Duplicates
+// are reported with debugf, or panic when StrictDuplicates is set.
+func mergeBackupSets(sets []BackupSet) backupSources {
 	var folders []Folder
 	var filesAdd []FileAdd
 	var filesRemove []string
 
-	seenFolder := map[string]struct{}{}
+	folderIndex := map[string]int{}
 	seenAdd := map[string]struct{}{}
 	seenRemove := map[string]struct{}{}
 
-	dupFolders := []string{}
-	dupAdd := []string{}
-	dupRemove := []string{}
-
-	recordedFolderDup := map[string]struct{}{}
-	recordedAddDup := map[string]struct{}{}
-	recordedRemoveDup := map[string]struct{}{}
+	var dupFolders, dupAdd, dupRemove []string
 
-	for _, set := range ActiveBackupSets {
-		// Folders: keep ordering; also detect duplicate folder path usage
+	for _, set := range sets {
+		// Folders: keep ordering; merge the contents of a folder declared again.
 		for _, f := range set.Folders {
+			f = f.forOS()
+			f.Excludes = append(slices.Clone(f.Excludes), set.Excludes...)
 			key := strings.ToLower(f.Path)
-			if _, ok := seenFolder[key]; ok {
-				if _, rec := recordedFolderDup[key]; !rec {
-					dupFolders = append(dupFolders, f.Path)
-					recordedFolderDup[key] = struct{}{}
+			i, ok := folderIndex[key]
+			if !ok {
+				folderIndex[key] = len(folders)
+				f.Contents = slices.Clone(f.Contents)
+				folders = append(folders, f)
+				continue
+			}
+			dupFolders = append(dupFolders, f.Path)
+			// Only what every declaration excludes stays excluded.
+			folders[i].Excludes = slices.DeleteFunc(folders[i].Excludes, func(p string) bool { return !slices.Contains(f.Excludes, p) })
+			// A folder declared without contents is backed up whole.
+			if len(folders[i].Contents) == 0 {
+				continue
+			}
+			if len(f.Contents) == 0 {
+				folders[i].Contents = nil
+				continue
+			}
+			for _, c := range f.Contents {
+				if !slices.ContainsFunc(folders[i].Contents, func(have string) bool { return strings.EqualFold(have, c) }) {
+					folders[i].Contents = append(folders[i].Contents, c)
 				}
-			} else {
-				seenFolder[key] = struct{}{}
 			}
-			folders = append(folders, f)
 		}
 
-		// FilesAdd: detect duplicates by path (case-insensitive)
+		// FilesAdd: keep the first entry per path (case-insensitive)
 		for _, fa := range set.FilesAdd {
+			fa = fa.forOS()
 			key := strings.ToLower(fa.Path)
 			if _, ok := seenAdd[key]; ok {
-				if _, rec := recordedAddDup[key]; !rec {
-					dupAdd = append(dupAdd, fa.Path)
-					recordedAddDup[key] = struct{}{}
-				}
-			} else {
-				seenAdd[key] = struct{}{}
-				filesAdd = append(filesAdd, fa)
+				dupAdd = append(dupAdd, fa.Path)
+				continue
 			}
+			seenAdd[key] = struct{}{}
+			filesAdd = append(filesAdd, fa)
 		}
 
-		// FilesRemove: detect duplicates by path (case-insensitive)
+		// FilesRemove: keep the first entry per path (case-insensitive)
 		for _, fr := range set.FilesRemove {
 			key := strings.ToLower(fr)
 			if _, ok := seenRemove[key]; ok {
-				if _, rec := recordedRemoveDup[key]; !rec {
-					dupRemove = append(dupRemove, fr)
-					recordedRemoveDup[key] = struct{}{}
-				}
-			} else {
-				seenRemove[key] = struct{}{}
-				filesRemove = append(filesRemove, fr)
+				dupRemove = append(dupRemove, fr)
+				continue
 			}
+			seenRemove[key] = struct{}{}
+			filesRemove = append(filesRemove, fr)
 		}
 	}
 
-	// If any duplicates detected, panic with descriptive error to force resolution.
 	if len(dupFolders) > 0 || len(dupAdd) > 0 || len(dupRemove) > 0 {
 		var parts []string
 		if len(dupFolders) > 0 {
@@ -182,40 +337,145 @@ func recomputeActiveSlices() {
 		if len(dupRemove) > 0 {
 			parts = append(parts, "FilesRemove: "+strings.Join(dupRemove, ", "))
 		}
-		panic("backup: duplicate paths detected across active backup sets -> " + strings.Join(parts, " | "))
+		if StrictDuplicates {
+			panic("backup: duplicate paths detected across active backup sets -> " + strings.Join(parts, " | "))
+		}
+		debugf("duplicate paths across backup sets, keeping the first occurrence -> %s", strings.Join(parts, " | "))
 	}
 
-	Folders = folders
-	FilesAdd = filesAdd
-	FilesRemove = filesRemove
+	return backupSources{
+		Sets:        sets,
+		Folders:     folders,
+		FilesAdd:    filesAdd,
+		FilesRemove: filesRemove,
+	}
 }
 
-// UseBackupSet resets the active sets to a single named set (case-insensitive).
-// If the name is unknown, the previous active list is left unchanged.
-// NOTE: Any duplicate paths across active sets will trigger a panic during
-// recomputeActiveSlices() to enforce explicit non-duplication.
-func UseBackupSet(name string) {
-	if set, ok := backupSets[strings.ToLower(name)]; ok {
-		ActiveBackupSets = []BackupSet{set}
-		recomputeActiveSlices()
+// subtractBackupSets removes from src every FilesAdd path, companion and folder
+// content declared by the excluded sets. Paths are compared expanded and
+// cleaned (see pathKey), so "~/x" and "$HOME/x" match. Folders left without
+// contents are dropped.
+func subtractBackupSets(src backupSources, excluded []BackupSet) backupSources {
+	drop := map[string]struct{}{}
+	for _, set := range excluded {
+		for _, fa := range set.FilesAdd {
+			for _, p := range fa.forOS().paths() {
+				drop[pathKey(p)] = struct{}{}
+			}
+		}
+		for _, f := range set.Folders {
+			for _, p := range f.forOS().declaredPaths() {
+				drop[pathKey(p)] = struct{}{}
+			}
+		}
+	}
+	dropped := func(p string) bool {
+		_, ok := drop[pathKey(p)]
+		return ok
 	}
+
+	out := backupSources{Sets: src.Sets, FilesRemove: src.FilesRemove}
+	for _, fa := range src.FilesAdd {
+		if dropped(fa.Path) {
+			continue
+		}
+		fa.Companions = slices.DeleteFunc(slices.Clone(fa.Companions), dropped)
+		out.FilesAdd = append(out.FilesAdd, fa)
+	}
+	for _, f := range src.Folders {
+		if dropped(f.Path) {
+			continue
+		}
+		if len(f.Contents) == 0 {
+			out.Folders = append(out.Folders, f)
+			continue
+		}
+		var contents []string
+		for _, content := range f.Contents {
+			if !dropped(filepath.Join(f.Path, content)) {
+				contents = append(contents, content)
+			}
+		}
+		if len(contents) > 0 {
+			f.Contents = contents
+			out.Folders = append(out.Folders, f)
+		}
+	}
+	return out
 }
 
-// UseBackupSets sets multiple active backup sets (order matters for folder concatenation).
-// Unknown names are ignored; if none resolve, the current active list is unchanged.
-// NOTE: If any duplicate folder paths, FilesAdd paths, or FilesRemove entries are
-// present across the combined sets, a panic will occur to force resolution.
-func UseBackupSets(names ...string) {
-	var sets []BackupSet
-	for _, name := range names {
-		if set, ok := backupSets[strings.ToLower(name)]; ok {
-			sets = append(sets, set)
+// pathKey returns the form paths of backup sets are compared in: environment
+// variables, "~" and the location placeholders expanded, cleaned and lower-cased.
+func pathKey(p string) string {
+	p = os.ExpandEnv(p)
+	if expanded, err := expandHome(p); err == nil {
+		p = expanded
+	}
+	return strings.ToLower(filepath.Clean(p))
+}
+
+// rehome returns src with "~" and location placeholders resolved against home,
+// so the sources of another user's account can be backed up.
+func rehome(src backupSources, home string) backupSources {
+	out := src
+	out.FilesAdd = make([]FileAdd, len(src.FilesAdd))
+	for i, fa := range src.FilesAdd {
+		fa.Path = utils.ExpandHomeIn(fa.Path, home)
+		companions := make([]string, len(fa.Companions))
+		for j, c := range fa.Companions {
+			companions[j] = utils.ExpandHomeIn(c, home)
+		}
+		fa.Companions = companions
+		out.FilesAdd[i] = fa
+	}
+	out.Folders = make([]Folder, len(src.Folders))
+	for i, f := range src.Folders {
+		f.Path = utils.ExpandHomeIn(f.Path, home)
+		out.Folders[i] = f
+	}
+	out.FilesRemove = make([]string, len(src.FilesRemove))
+	for i, p := range src.FilesRemove {
+		out.FilesRemove[i] = utils.ExpandHomeIn(p, home)
+	}
+	return out
+}
+
+// withoutFiles returns src without the FilesAdd entries whose expanded path is one
+// of paths.
+func withoutFiles(src backupSources, paths []string) backupSources {
+	out := src
+	out.FilesAdd = nil
+	for _, fa := range src.FilesAdd {
+		expanded, err := expandHome(fa.Path)
+		if err == nil && slices.Contains(paths, filepath.Clean(expanded)) {
+			continue
 		}
+		out.FilesAdd = append(out.FilesAdd, fa)
+	}
+	return out
+}
+
+// UseBackupSet resets the active sets to a single named set (case-insensitive).
+// If the name is unknown, an error is returned and the active list is unchanged.
+func UseBackupSet(name string) error {
+	return UseBackupSets(name)
+}
+
+// UseBackupSets sets multiple active backup sets (order matters for folder concatenation).
+// If any name is unknown, an error listing the available sets is returned and the
+// active list is unchanged. Paths shared by several sets are merged as described
+// on mergeBackupSets.
+func UseBackupSets(names ...string) error {
+	if len(names) == 0 {
+		return fmt.Errorf("no backup set given")
 	}
-	if len(sets) > 0 {
-		ActiveBackupSets = sets
-		recomputeActiveSlices()
+	sets, err := resolveBackupSets(names)
+	if err != nil {
+		return err
 	}
+	ActiveBackupSets = sets
+	recomputeActiveSlices()
+	return nil
 }
 
 // GetBackupSet returns a copy of the named backup set and a bool indicating existence.
@@ -224,6 +484,25 @@ func GetBackupSet(name string) (BackupSet, bool) {
 	return set, ok
 }
 
+// RegisterBackupSet adds set to the registry, replacing any set with the same
+// (case-insensitive) name.
+func RegisterBackupSet(set BackupSet) error {
+	if strings.TrimSpace(set.Name) == "" {
+		return fmt.Errorf("backup set has no name")
+	}
+	backupSets[strings.ToLower(set.Name)] = set
+	return nil
+}
+
+// ListBackupSets returns all registered backup sets sorted by name.
+func ListBackupSets() []BackupSet {
+	var sets []BackupSet
+	for _, name := range ListBackupSetNames() {
+		sets = append(sets, backupSets[name])
+	}
+	return sets
+}
+
 // ListBackupSetNames returns the list of registered backup set names in sorted order.
 func ListBackupSetNames() []string {
 	names := make([]string, 0, len(backupSets))
@@ -0,0 +1,156 @@
+package backup
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSubtractBackupSets(t *testing.T) {
+	setTestHome(t)
+	src := mergeBackupSets([]BackupSet{{
+		Name: "main",
+		Folders: []Folder{
+			{Path: "~/.config/zed", Contents: []string{"settings.json", "keymap.json"}},
+			{Path: "~/.oh-my-zsh/custom"},
+		},
+		FilesAdd: []FileAdd{
+			{Path: "~/.gitconfig", Update: true},
+			{Path: "~/.zshrc", Update: true, Companions: []string{"~/.zsh_plugins", "~/.zsh_aliases"}},
+			{Path: "~/.XCompose", Update: true},
+		},
+	}})
+	excluded := []BackupSet{{
+		Name: "private",
+		Folders: []Folder{
+			{Path: "$HOME/.config/zed", Contents: []string{"./keymap.json"}},
+		},
+		FilesAdd: []FileAdd{
+			{Path: "$HOME/.gitconfig"},
+			{Path: "~/.profile", Companions: []string{"~/.oh-my-zsh/custom/", "~/.zsh_aliases"}},
+		},
+	}}
+
+	got := subtractBackupSets(src, excluded)
+	wantFolders := []Folder{{Path: "~/.config/zed", Contents: []string{"settings.json"}}}
+	wantFiles := []FileAdd{
+		{Path: "~/.zshrc", Update: true, Companions: []string{"~/.zsh_plugins"}},
+		{Path: "~/.XCompose", Update: true},
+	}
+	if !reflect.DeepEqual(got.Folders, wantFolders) {
+		t.Errorf("folders = %+v, want %+v", got.Folders, wantFolders)
+	}
+	if !reflect.DeepEqual(got.FilesAdd, wantFiles) {
+		t.Errorf("files = %+v, want %+v", got.FilesAdd, wantFiles)
+	}
+}
+
+// TestSingleFilesAddDefinition parses every Go file of the module, whatever its
+// build tags, and checks that the FilesAdd and Folders lists are declared once,
+// in this package.
+func TestSingleFilesAddDefinition(t *testing.T) {
+	root, err := filepath.Abs(filepath.Join("..", ".."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decls := map[string][]string{}
+	fset := token.NewFileSet()
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() != "." && (strings.HasPrefix(d.Name(), ".") || d.Name() == "vendor" || d.Name() == "testdata") {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		f, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				for _, name := range spec.(*ast.ValueSpec).Names {
+					if name.Name == "FilesAdd" || name.Name == "Folders" {
+						decls[name.Name] = append(decls[name.Name], rel)
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join("internal", "backup", "write_files.go")
+	for _, name := range []string{"FilesAdd", "Folders"} {
+		if !reflect.DeepEqual(decls[name], []string{want}) {
+			t.Errorf("%s declared in %v, want only %s", name, decls[name], want)
+		}
+	}
+}
+
+func TestRehome(t *testing.T) {
+	setTestHome(t)
+	home := filepath.FromSlash("/home/other")
+	config, cache, data := ".config", ".cache", ".local/share"
+	if runtime.GOOS == "darwin" {
+		config, cache, data = "Library/Application Support", "Library/Caches", "Library/Application Support"
+	}
+	in := func(rel string) string { return filepath.Join(home, filepath.FromSlash(rel)) }
+
+	src := backupSources{
+		Sets: []BackupSet{{Name: "main"}},
+		Folders: []Folder{
+			{Path: "~/.config/zed", Contents: []string{"settings.json"}, Excludes: []string{"*.bak"}},
+			{Path: "{cache}/Alice/messages"},
+			{Path: "/etc/ssh"},
+		},
+		FilesAdd: []FileAdd{
+			{Path: "~/.zshrc", Update: true, Companions: []string{"~/.zsh_aliases", "{config}/zsh"}},
+			{Path: "{data}/fonts/custom.ttf"},
+		},
+		FilesRemove: []string{"~/.cache/old", "{data}/stale", "/etc/stale.conf"},
+	}
+	orig := backupSources{
+		Sets:        slices.Clone(src.Sets),
+		Folders:     slices.Clone(src.Folders),
+		FilesAdd:    slices.Clone(src.FilesAdd),
+		FilesRemove: slices.Clone(src.FilesRemove),
+	}
+	orig.FilesAdd[0].Companions = slices.Clone(src.FilesAdd[0].Companions)
+
+	got := rehome(src, home)
+	want := backupSources{
+		Sets: []BackupSet{{Name: "main"}},
+		Folders: []Folder{
+			{Path: in(".config/zed"), Contents: []string{"settings.json"}, Excludes: []string{"*.bak"}},
+			{Path: in(cache + "/Alice/messages")},
+			{Path: "/etc/ssh"},
+		},
+		FilesAdd: []FileAdd{
+			{Path: in(".zshrc"), Update: true, Companions: []string{in(".zsh_aliases"), in(config + "/zsh")}},
+			{Path: in(data + "/fonts/custom.ttf"), Companions: []string{}},
+		},
+		FilesRemove: []string{in(".cache/old"), in(data + "/stale"), "/etc/stale.conf"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rehome =\n%+v\nwant\n%+v", got, want)
+	}
+	if !reflect.DeepEqual(src, orig) {
+		t.Errorf("rehome modified its argument: %+v", src)
+	}
+}
@@ -1,22 +1,37 @@
 package internal
 
 import (
-	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"setup/internal/auth"
 	"setup/internal/backup"
+	"setup/internal/clone"
+	"setup/internal/notify"
+	"setup/internal/profile"
+	"setup/internal/prompt"
+	"setup/shared/utils"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// exitNoChanges is the exit status of "create --only-new" when nothing changed.
+const exitNoChanges = 3
+
 // RunCLI executes the command line logic for backup, restore, and authentication.
 // Usage: setup create        -> creates backup in backups
 //
+//			setup init          -> creates the directory layout
+//			setup doctor        -> checks the prerequisites of create and apply
 //			setup apply         -> applies backup from backups to the OS
 //			setup refresh_token -> obtém refresh token do Google OAuth
 //			setup oauth_token   -> gera token OAuth completo a partir do refresh token
 //		 setup --help / -h  -> mostra ajuda
-//	  setup --list-steps -> lists available backup steps
+//	  setup --list-steps -> lists available backup steps (--json for scripts)
+//	  setup completion   -> prints a shell completion script
 func RunCLI() int {
 	var cmd string
 
@@ -34,23 +49,300 @@ func RunCLI() int {
 		return 0
 	}
 
+	if cmd == "init" {
+		return runInit()
+	}
+	// doctor runs before the layout is created so it can report it missing.
+	if cmd == "doctor" {
+		if err := applyCredentialsFrom(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		return runDoctor()
+	}
+	if _, err := backup.EnsureLayout(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v (run setup init to check the directory layout)\n", err)
+		return 1
+	}
+
+	if err := backup.LoadCustomBackupSets(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not load custom backup sets: %v\n", err)
+	}
+
 	switch cmd {
 	case "--list-steps":
-		steps := backup.GetBackupStepNames()
-		fmt.Println("Available backup steps:")
-		for _, s := range steps {
-			fmt.Printf("  %s\n", s)
+		return runListSteps(hasFlag(os.Args[2:], "--json"))
+	case "completion":
+		return runCompletion(os.Args[2:])
+	case "profiles":
+		return runProfiles()
+	case "list-sets":
+		return runListSets(hasFlag(os.Args[2:], "--json"))
+	case "drive-trash":
+		if err := activateProfile(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading profile: %v\n", err)
+			return 1
+		}
+		if err := applyCredentialsFrom(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if name, ok := flagValue(os.Args[2:], "--restore"); ok {
+			if err := backup.UntrashBackup(name); err != nil {
+				fmt.Fprintf(os.Stderr, "Error restoring backup from the trash: %v\n", err)
+				return 1
+			}
+			fmt.Printf("Restored %s from the Google Drive trash.\n", name)
+			return 0
+		}
+		return runDriveTrash()
+	case "rollback":
+		if hasFlag(os.Args[2:], "--list") {
+			return runRollbackList()
+		}
+		name := ""
+		if len(os.Args) > 2 && !strings.HasPrefix(os.Args[2], "-") {
+			name = os.Args[2]
+		}
+		return runRollback(name)
+	case "prune":
+		if err := activateProfile(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading profile: %v\n", err)
+			return 1
+		}
+		if err := applyCredentialsFrom(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		v, ok := flagValue(os.Args[2:], "--keep")
+		if !ok {
+			fmt.Fprintln(os.Stderr, "Error: prune requires --keep <count>.")
+			return 1
+		}
+		keep, err := strconv.Atoi(v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid --keep value %q\n", v)
+			return 1
+		}
+		return runPrune(keep)
+	case "list":
+		if err := activateProfile(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading profile: %v\n", err)
+			return 1
+		}
+		if err := applyCredentialsFrom(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		tag, _ := flagValue(os.Args[2:], "--tag")
+		return runList(tag)
+	case "export-sets":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, "Error: No file specified for export-sets command.")
+			return 1
+		}
+		if err := backup.ExportBackupSets(os.Args[2]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error exporting backup sets: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Backup sets exported to %s.\n", os.Args[2])
+		return 0
+	case "import-sets":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, "Error: No file specified for import-sets command.")
+			return 1
+		}
+		names, err := backup.ImportBackupSets(os.Args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error importing backup sets: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Imported backup sets: %s\n", strings.Join(names, ", "))
+		return 0
+	case "verify":
+		if err := activateProfile(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading profile: %v\n", err)
+			return 1
+		}
+		if err := applyCredentialsFrom(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if err := applyStoreTimeout(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if err := applyTransferLimit(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		name := ""
+		if len(os.Args) > 2 && !strings.HasPrefix(os.Args[2], "-") {
+			name = os.Args[2]
+		}
+		return runVerify(name)
+	case "inspect":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, "Error: No backup file specified for inspect command.")
+			return 1
+		}
+		if err := activateProfile(os.Args[3:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading profile: %v\n", err)
+			return 1
+		}
+		return runInspect(os.Args[2])
+	case "restore-file":
+		if len(os.Args) < 3 || strings.HasPrefix(os.Args[2], "-") {
+			fmt.Fprintln(os.Stderr, "Error: No path specified for restore-file command.")
+			fmt.Println("Usage: setup restore-file <path> [--from <backupfile>]")
+			return 1
+		}
+		if err := activateProfile(os.Args[3:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading profile: %v\n", err)
+			return 1
+		}
+		if err := applyCredentialsFrom(os.Args[3:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if err := applyStoreTimeout(os.Args[3:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if err := applyTransferLimit(os.Args[3:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		from, _ := flagValue(os.Args[3:], "--from")
+		if err := backup.RestoreSingleFile(from, os.Args[2]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error restoring file: %v\n", err)
+			return 1
 		}
 		return 0
+	case "diff":
+		if len(os.Args) < 3 || strings.HasPrefix(os.Args[2], "-") {
+			fmt.Fprintln(os.Stderr, "Error: No backup file specified for diff command.")
+			fmt.Println("Usage: setup diff <backupfile> [--steps \"before clone,after clone\"] [--name-only]")
+			return 1
+		}
+		if err := activateProfile(os.Args[3:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading profile: %v\n", err)
+			return 1
+		}
+		if err := applyCredentialsFrom(os.Args[3:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if err := applyStoreTimeout(os.Args[3:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		var steps []string
+		if v, ok := flagValue(os.Args[3:], "--steps"); ok {
+			steps = splitList(v)
+		}
+		return runDiff(os.Args[2], steps, hasFlag(os.Args[3:], "--name-only"))
 	case "create":
+		if err := activateProfile(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading profile: %v\n", err)
+			return 1
+		}
+		if err := applyTimeout(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if err := applyStoreTimeout(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if err := applyTransferLimit(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if err := applyCredentialsFrom(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
 		// Check for --alicebot flag
-		for i := 2; i < len(os.Args); i++ {
-			if os.Args[i] == "--alicebot" {
-				backup.UseBackupSet("alicebot")
-				break
+		if hasFlag(os.Args[2:], "--alicebot") {
+			if err := backup.UseBackupSet("alicebot"); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+		}
+		if v, ok := flagValue(os.Args[2:], "--set"); ok {
+			if err := backup.UseBackupSets(splitList(v)...); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+		}
+		if v, ok := flagValue(os.Args[2:], "--size-warn"); ok {
+			pct, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+			if err != nil || pct < 0 {
+				fmt.Fprintf(os.Stderr, "Error: invalid --size-warn value %q\n", v)
+				return 1
+			}
+			backup.SizeWarnPercent = pct
+		}
+		opts := backup.CreateBackupOpts{
+			SinceLast:          hasFlag(os.Args[2:], "--since-last") || hasFlag(os.Args[2:], "--incremental"),
+			LocalOnly:          hasFlag(os.Args[2:], "--local-only"),
+			DryRunUpload:       hasFlag(os.Args[2:], "--dry-run") && hasFlag(os.Args[2:], "--upload"),
+			KeepEmptyDirs:      hasFlag(os.Args[2:], "--keep-empty-dirs"),
+			RedactManifest:     hasFlag(os.Args[2:], "--redact-manifest"),
+			VerifyUpload:       hasFlag(os.Args[2:], "--verify-after-upload"),
+			VerifyUploadFull:   hasFlag(os.Args[2:], "--verify-full"),
+			OnlyNew:            hasFlag(os.Args[2:], "--only-new"),
+			Resume:             hasFlag(os.Args[2:], "--resume"),
+			ExcludeCredentials: hasFlag(os.Args[2:], "--exclude-credentials"),
+			SkipJunk:           hasFlag(os.Args[2:], "--skip-hidden"),
+			IgnoreMissing:      hasFlag(os.Args[2:], "--ignore-missing"),
+			UseSystemTar:       hasFlag(os.Args[2:], "--system-tar"),
+			Encrypt:            hasFlag(os.Args[2:], "--encrypt"),
+		}
+		if v, ok := flagValue(os.Args[2:], "--target-home"); ok {
+			opts.TargetHome = v
+		}
+		if v, ok := flagValue(os.Args[2:], "--exclude-set"); ok {
+			opts.ExcludeSets = splitList(v)
+		}
+		if v, ok := flagValue(os.Args[2:], "--tag"); ok {
+			opts.Tags = splitList(v)
+		}
+		if v, ok := flagValue(os.Args[2:], "--name"); ok {
+			opts.Name = v
+		}
+		if v, ok := flagValue(os.Args[2:], "--format"); ok {
+			opts.Format = v
+		}
+		if v, ok := flagValue(os.Args[2:], "--level"); ok {
+			level, err := strconv.Atoi(v)
+			if err != nil || level < 1 {
+				fmt.Fprintf(os.Stderr, "Error: invalid --level value %q\n", v)
+				return 1
+			}
+			opts.Level = level
+		}
+		if hasFlag(os.Args[2:], "--dry-run") && !opts.DryRunUpload {
+			plan, err := backup.PlanBackupWithOpts(opts)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error planning backup: %v\n", err)
+				return 1
 			}
+			plan.Print(os.Stdout)
+			return 0
+		}
+		if !hasFlag(os.Args[2:], "--no-progress") {
+			opts.Progress = newProgressBar(os.Stderr, "Uploading")
+		}
+		start := time.Now()
+		archive, err := backup.CreateBackupWithOpts(opts)
+		sendNotification(notify.NewEvent("create", start, archive, err))
+		if errors.Is(err, backup.ErrNoChanges) {
+			fmt.Printf("Backup skipped: %v\n", err)
+			return exitNoChanges
 		}
-		if err := backup.CreateBackup(); err != nil {
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error creating backup: %v\n", err)
 			return 1
 		}
@@ -59,42 +351,201 @@ func RunCLI() int {
 	case "apply":
 		if len(os.Args) < 3 {
 			fmt.Fprintln(os.Stderr, "Error: No backup file specified for apply command.")
-			fmt.Println("Usage: setup apply <backupfile> [--steps \"before clone,after clone\"]")
+			fmt.Println("Usage: setup apply <backupfile> [--steps \"before clone,after clone\"] [--validate] [--strict]")
 			return 1
 		}
 		backupFile := os.Args[2]
-		var steps []string
-		for i := 3; i < len(os.Args); i++ {
-			if os.Args[i] == "--steps" && i+1 < len(os.Args) {
-				stepsArg := os.Args[i+1]
-				for _, s := range strings.FieldsFunc(stepsArg, func(r rune) bool { return r == ',' }) {
-					trimmed := strings.TrimSpace(s)
-					if trimmed != "" {
-						steps = append(steps, trimmed)
-					}
-				}
-				break
+		if err := activateProfile(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading profile: %v\n", err)
+			return 1
+		}
+		if err := applyTimeout(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if err := applyStoreTimeout(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if err := applyTransferLimit(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if err := applyJobs(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if err := applyCredentialsFrom(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		var steps, excludeSteps []string
+		if v, ok := flagValue(os.Args[3:], "--steps"); ok {
+			steps = splitList(v)
+		}
+		if v, ok := flagValue(os.Args[3:], "--exclude-steps"); ok {
+			excludeSteps = splitList(v)
+		}
+		if len(steps) > 0 && len(excludeSteps) > 0 {
+			fmt.Fprintln(os.Stderr, "Error: --steps and --exclude-steps cannot be used together")
+			return 1
+		}
+		opts := backup.ApplyBackupOpts{
+			Steps:        steps,
+			ExcludeSteps: excludeSteps,
+			ValidateJSON: hasFlag(os.Args[3:], "--validate"),
+			Strict:       hasFlag(os.Args[3:], "--strict"),
+		}
+		opts.KeepArchivedHome = hasFlag(os.Args[3:], "--keep-archived-home")
+		opts.KeepRemoved = hasFlag(os.Args[3:], "--keep-removed")
+		opts.NoDownload = hasFlag(os.Args[3:], "--no-download")
+		opts.NoHooks = hasFlag(os.Args[3:], "--no-hooks")
+		if hasFlag(os.Args[3:], "--update-only") {
+			opts.Overwrite = utils.OverwriteIfNewer
+		}
+		if hasFlag(os.Args[3:], "--only-missing") {
+			// Never touch existing files, which includes the FilesRemove deletions.
+			opts.Overwrite = utils.OverwriteMissingOnly
+			opts.KeepRemoved = true
+		}
+		if v, ok := flagValue(os.Args[3:], "--overwrite"); ok {
+			policy, err := utils.ParseOverwritePolicy(v)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+			opts.Overwrite = policy
+		}
+		if hasFlag(os.Args[3:], "--confirm") && prompt.IsInteractive() {
+			// Without a terminal to answer on, --confirm leaves the policy as is.
+			opts.Overwrite = utils.OverwritePrompt
+			opts.Confirm = prompt.NewOverwriteConfirmer(prompt.Stdio()).Confirm
+		} else if opts.Overwrite == utils.OverwritePrompt {
+			p := prompt.Stdio()
+			opts.Confirm = func(target string) (bool, error) {
+				return p.Confirm(fmt.Sprintf("Overwrite %s?", target))
+			}
+		}
+		if set, ok := flagValue(os.Args[3:], "--backup-set"); ok {
+			if _, found := backup.GetBackupSet(set); !found {
+				fmt.Fprintf(os.Stderr, "Error: unknown backup set %q (available: %s)\n", set, strings.Join(backup.ListBackupSetNames(), ", "))
+				return 1
+			}
+			opts.BackupSet = set
+		}
+		if hasFlag(os.Args[3:], "--print-plan") {
+			plan, err := backup.PlanApply(backupFile, opts)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error planning apply: %v\n", err)
+				return 1
 			}
+			data, err := json.MarshalIndent(plan, "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error encoding plan: %v\n", err)
+				return 1
+			}
+			fmt.Println(string(data))
+			return 0
+		}
+		if hasFlag(os.Args[3:], "--dry-run") {
+			opts.DryRun = true
+			if err := backup.ApplyBackupWithOpts(backupFile, opts); err != nil {
+				fmt.Fprintf(os.Stderr, "Error during dry run: %v\n", err)
+				return 1
+			}
+			fmt.Println("Dry run complete; nothing was changed.")
+			return 0
 		}
-		if err := backup.ApplyBackupSelected(backupFile, steps); err != nil {
+		start := time.Now()
+		err := backup.ApplyBackupWithOpts(backupFile, opts)
+		sendNotification(notify.NewEvent("apply", start, backupFile, err))
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error applying backup: %v\n", err)
 			return 1
 		}
 		fmt.Println("Backup successfully applied to the system.")
 		return 0
 	case "refresh_token":
+		auth.ManualCode = hasFlag(os.Args[2:], "--manual")
+		auth.DeviceFlow = hasFlag(os.Args[2:], "--device")
 		if err := auth.RunRefreshTokenFlow(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error obtaining refresh token: %v\n", err)
 			return 1
 		}
 		return 0
 	case "clone":
+		if err := activateProfile(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading profile: %v\n", err)
+			return 1
+		}
+		if err := applyTimeout(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if err := applyStoreTimeout(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if err := applyTransferLimit(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if err := applyJobs(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if err := applyCredentialsFrom(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		clone.Opts.HTTPS = hasFlag(os.Args[2:], "--https")
+		if hasFlag(os.Args[2:], "--list") {
+			clone.PrintRepositories(os.Stdout, clone.ListRepositories())
+			return 0
+		}
+		clone.Update = hasFlag(os.Args[2:], "--update")
+		clone.Opts.FailFast = hasFlag(os.Args[2:], "--fail-fast")
+		if v, ok := flagValue(os.Args[2:], "--depth"); ok {
+			depth, err := strconv.Atoi(v)
+			if err != nil || depth < 1 {
+				fmt.Fprintf(os.Stderr, "Error: invalid --depth value %q\n", v)
+				return 1
+			}
+			clone.Opts.Depth = depth
+		}
 		// Run the "clone all" and "after clone" steps using the backup step runner
-		if err := backup.ApplyBackupSelected("", []string{"clone all", "after clone"}); err != nil {
+		opts := backup.ApplyBackupOpts{Steps: []string{"clone all", "after clone"}}
+		if hasFlag(os.Args[2:], "--json") {
+			opts.CloneReport = func(results []clone.CloneResult) {
+				data, _ := json.MarshalIndent(results, "", "  ")
+				fmt.Println(string(data))
+			}
+		}
+		if err := backup.ApplyBackupWithOpts("", opts); err != nil {
 			fmt.Fprintf(os.Stderr, "Error running clone all/after clone steps: %v\n", err)
 			return 1
 		}
 		return 0
+	case "set-token":
+		if err := activateProfile(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading profile: %v\n", err)
+			return 1
+		}
+		if err := applyCredentialsFrom(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		return runSetToken(os.Args[2:])
+	case "token-status":
+		if err := activateProfile(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading profile: %v\n", err)
+			return 1
+		}
+		if err := applyCredentialsFrom(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		return runTokenStatus()
 	case "oauth_token":
 		if err := auth.RunOAuthTokenFlow(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error generating OAuth token: %v\n", err)
@@ -107,29 +558,667 @@ func RunCLI() int {
 	}
 }
 
-func promptForCommand() string {
-	reader := bufio.NewReader(os.Stdin)
-	for {
-		fmt.Print("Enter desired command (create/apply/refresh_token/oauth_token): ")
-		input, _ := reader.ReadString('\n')
-		input = strings.TrimSpace(strings.ToLower(input))
-		if input == "create" || input == "apply" || input == "refresh_token" || input == "oauth_token" {
-			return input
-		}
-		fmt.Println("Invalid command.")
-	}
-}
-
 func printHelp() {
 	fmt.Println("Usage:")
-	fmt.Println("  setup create [--alicebot] # Create a backup of system files in backups")
+	fmt.Println("  setup create [--alicebot] [--since-last] [--size-warn <percent>] [--upload --dry-run]")
+	fmt.Println("                       # Create a backup of system files in backups")
 	fmt.Println("                       # Use --alicebot for minimal AliceBot-specific backup")
-	fmt.Println("  setup apply <file> [--steps \"before clone,after clone\"]")
+	fmt.Println("                       # Use --set <name,...> to back up the given backup sets (see setup list-sets)")
+	fmt.Println("                       # A progress bar is shown while uploading to Google Drive; --no-progress hides it")
+	fmt.Println("                       # Use --format zstd to create a faster .tar.zst archive instead of .tar.xz,")
+	fmt.Println("                       # and --level <n> to set the compression level (xz 1-9, zstd 1-22)")
+	fmt.Println("                       # Use --encrypt to encrypt the archive with age to the recipients in")
+	fmt.Println("                       # ~/.config/setup/age-recipients.txt (.age suffix; apply decrypts with AGE_IDENTITY)")
+	fmt.Println("                       # Use --system-tar to build the archive with the system tar/xz instead of the built-in archiver")
+	fmt.Println("                       # Use --exclude-set <name,...> to leave out the paths of other backup sets")
+	fmt.Println("                       # Use --since-last (or --incremental) for an incremental backup against the most recent one;")
+	fmt.Println("                       # apply fetches the unchanged files from the base backups")
+	fmt.Println("                       # The archive is uploaded to the backup store; --local-only keeps it in backups only")
+	fmt.Println("                       # Use --dry-run to list the paths that would be copied (flagging missing ones) and")
+	fmt.Println("                       # their total size, without writing anything")
+	fmt.Println("                       # Use --upload --dry-run to build the archive but only report where it would be uploaded")
+	fmt.Println("                       # Use --keep-empty-dirs to also archive empty directories of declared folders")
+	fmt.Println("                       # Use --redact-manifest to store only hashed paths in the uploaded manifest")
+	fmt.Println("                       # Use --target-home <dir> to back up another user's home (e.g. as root)")
+	fmt.Println("                       # Use --skip-hidden to leave junk files (.DS_Store, *.swp, *~) out of backed-up directories")
+	fmt.Println("                       # A declared path that could not be copied fails the backup; --ignore-missing only")
+	fmt.Println("                       # warns about paths that do not exist (e.g. /etc/prime-discrete without Nvidia)")
+	fmt.Println("                       # Use --exclude-credentials to leave the .env with the Google tokens out of the archive")
+	fmt.Println("                       # Use --resume to reuse the staging dir of an interrupted run, copying only what's missing")
+	fmt.Println("                       # Use --only-new to skip the backup (exit status 3) if nothing changed since the last one")
+	fmt.Println("                       # Use --verify-after-upload to check the Drive checksum of the upload (--verify-full re-downloads it)")
+	fmt.Println("                       # Use --tag <label,...> to label the backup (e.g. pre-upgrade); see setup list --tag")
+	fmt.Println("                       # Use --name <name> to replace home-<user>-backup-<timestamp>; the extension is added and")
+	fmt.Println("                       # a short random suffix too when a backup of that name already exists")
+	fmt.Println("                       # Warn if the archive size changed more than <percent> vs the previous one (default 50, 0 disables)")
+	fmt.Println("  setup doctor [--credentials-from <file>]")
+	fmt.Println("                       # Check the prerequisites (home, setup root, git, store credentials, tar with xz)")
+	fmt.Println("                       # and exit 1 if a required one fails")
+	fmt.Println("  setup init           # Create and check the directories setup uses (<root>/backups, state and config dirs)")
+	fmt.Println("  setup list [--tag <label>] # List the backups on Google Drive, optionally only those with a tag")
+	fmt.Println("  setup apply <file> [--steps \"before clone,after clone\"] [--validate] [--strict]")
 	fmt.Println("                       # Apply backup from the specified backup file to the system")
 	fmt.Println("                       # Optionally specify steps to apply (comma-separated, e.g. --steps \"before clone\")")
-	fmt.Println("  setup refresh_token  # Obtain Google OAuth refresh token")
+	fmt.Println("                       # or steps to skip (e.g. --exclude-steps \"clone all\"), but not both")
+	fmt.Println("                       # A <file> that exists locally is applied as is; other names are downloaded from the store")
+	fmt.Println("                       # --no-download never downloads: <file> must be local or already in the backups dir")
+	fmt.Println("                       # --validate checks restored JSON files parse; --strict fails if any don't")
+	fmt.Println("                       # --backup-set <name> restores only the paths declared by that set")
+	fmt.Println("                       # --print-plan prints every operation as JSON without applying anything")
+	fmt.Println("                       # --dry-run prints each file that would be created, overwritten or removed, writing nothing")
+	fmt.Println("                       # Files listed in the sets' FilesRemove are deleted (a copy is kept); --keep-removed skips that")
+	fmt.Println("                       # --print-plan lists them as \"remove\" actions")
+	fmt.Println("                       # --keep-archived-home restores under the archived home instead of the current user's")
+	fmt.Println("                       # --update-only restores a file only if the archived copy is newer than the local one")
+	fmt.Println("                       # --only-missing restores only files that don't exist locally, never touching present ones")
+	fmt.Println("                       # --overwrite <always|never|if-newer|if-differ|prompt|missing-only> decides what happens to existing files")
+	fmt.Println("                       # --confirm asks before overwriting each differing file (y/N/a(ll)/q(uit)) when run in a terminal")
+	fmt.Println("                       # Executables in <root>/hooks/pre-apply.d run first (one failing aborts the apply) and those in")
+	fmt.Println("                       # hooks/post-apply.d run last, in name order, with SETUP_TMP_DIR and SETUP_APPLIED_STEPS set;")
+	fmt.Println("                       # --no-hooks skips them")
+	fmt.Println("  setup restore-file <path> [--from <file>]")
+	fmt.Println("                       # Restore just <path> (e.g. ~/.zshrc) from the latest backup, or from <file>;")
+	fmt.Println("                       # the current file is kept for setup rollback")
+	fmt.Println("  setup rollback [<name>] [--list] # Put back the files replaced or removed by the last apply (or by <name>)")
+	fmt.Println("                       # apply saves them to <root>/backups/originals-<timestamp>; --list shows those rollback points")
+	fmt.Println("  setup prune --keep <n> # Move all but the n newest backups on Google Drive to the trash")
+	fmt.Println("  setup drive-trash [--restore <name>] # List backups in the Drive trash, or restore one")
+	fmt.Println("  setup export-sets <file> # Write the registered backup sets to a YAML file")
+	fmt.Println("  setup import-sets <file> # Register the backup sets of a YAML file (kept in ~/.config/setup/sets)")
+	fmt.Println("  setup verify [<file>] [--store-timeout <duration>]")
+	fmt.Println("                       # Extract a backup (the latest one by default) to a temp dir and check every")
+	fmt.Println("                       # file against the manifest's checksums, without applying anything")
+	fmt.Println("  setup diff <file> [--steps \"before clone,after clone\"] [--name-only]")
+	fmt.Println("                       # Compare a backup with the live system: counts of added, removed, changed and")
+	fmt.Println("                       # identical files; --name-only also lists the differing paths")
+	fmt.Println("  setup inspect <file> # Show a backup's manifest (read from its sidecar, without downloading the archive)")
+	fmt.Println("  setup refresh_token [--manual] [--device] # Obtain Google OAuth refresh token")
+	fmt.Println("                       # The browser redirect is caught on localhost; --manual pastes the code instead")
+	fmt.Println("                       # --device shows a code to enter on another device, for headless machines")
+	fmt.Println("                       # (needs a \"TV and Limited Input\" OAuth client and a scope it allows, e.g. drive.file)")
+	fmt.Println("  setup set-token [--refresh-token <token>] [--credentials <client.json>] [--token-file <file>]")
+	fmt.Println("                       # Store a new refresh token (also from $SETUP_REFRESH_TOKEN or stdin) after testing it")
 	fmt.Println("  setup oauth_token    # Generate complete OAuth token from refresh token")
-	fmt.Println("  setup clone          # Clone all configured repositories via SSH")
-	fmt.Println("  setup --list-steps   # List available backup steps")
+	fmt.Println("  setup token-status   # Show whether the Google token is valid and try refreshing it (no Drive access)")
+	fmt.Println("                       # The token commands use $GOOGLE_CREDENTIALS_FILE (by default the client_secret_*.json")
+	fmt.Println("                       # in the setup root) and $GOOGLE_SCOPES (comma-separated, by default the Drive scope)")
+	fmt.Println("  setup clone [--json] [--update] [--list] # Clone all configured repositories via SSH and print a summary (JSON with --json)")
+	fmt.Println("                       # --update fetches and fast-forwards repositories already cloned (dirty ones are skipped)")
+	fmt.Println("                       # --depth <n> makes shallow single-branch clones with the last n commits")
+	fmt.Println("                       # --jobs <n> clones n repositories at once (default: number of CPUs); apply accepts it too")
+	fmt.Println("                       # A failing repository does not stop the others; --fail-fast stops at the first failure")
+	fmt.Println("                       # When the SSH key is refused HTTPS is used instead; --https uses it from the start")
+	fmt.Println("                       # --list prints the repositories (base directory, URL, branch) without cloning")
+	fmt.Println("  setup list-sets [--json] # List the registered backup sets with their folder and file counts")
+	fmt.Println("  setup profiles       # List profiles from ~/.config/setup/profiles")
+	fmt.Println("                       # A profile's notify section (command/webhook/timeout) reports create/apply results")
+	fmt.Println("                       # create, apply and clone accept --profile <name> to use one")
+	fmt.Println("                       # and --timeout <duration> to bound each tar/git command (0 disables)")
+	fmt.Println("                       # and --store-timeout <duration> to abort a stalled upload/download (e.g. 10m)")
+	fmt.Println("                       # and --limit <size> to cap uploads/downloads per second (e.g. 2MiB)")
+	fmt.Println("                       # and --credentials-from <file> to read the Google credentials from another .env")
+	fmt.Println("  The setup root (backups, assets, .env) is $SETUP_ROOT, by default ~/setup")
+	fmt.Println("  Set BACKUP_STORE=s3 to keep backups in an S3-compatible bucket instead of Google Drive")
+	fmt.Println("    (BACKUP_S3_BUCKET, BACKUP_S3_ENDPOINT, BACKUP_S3_REGION, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY)")
+	fmt.Println("  Set SETUP_DEBUG=1 for diagnostic output (e.g. paths shared by several backup sets)")
+	fmt.Println("  setup completion <bash|zsh|fish> # Print a shell completion script (e.g. source <(setup completion bash))")
+	fmt.Println("  setup --list-steps [--json] # List available backup steps, as a JSON array with --json")
 	fmt.Println("  setup --help, -h     # Show this help message")
 }
+
+// newProgressBar returns a progress callback drawing a percentage bar on w,
+// redrawn only when the percentage changes.
+func newProgressBar(w io.Writer, label string) func(done, total int64) {
+	const width = 30
+	last := -1
+	return func(done, total int64) {
+		if total <= 0 {
+			return
+		}
+		pct := int(done * 100 / total)
+		if pct == last {
+			return
+		}
+		last = pct
+		filled := pct * width / 100
+		fmt.Fprintf(w, "\r%s [%s%s] %3d%% (%s / %s)", label, strings.Repeat("#", filled), strings.Repeat(" ", width-filled),
+			pct, utils.FormatBytes(done), utils.FormatBytes(total))
+		if done >= total {
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// sendNotification dispatches e to the configured notifiers, if any. A failing
+// notifier only produces a warning.
+func sendNotification(e notify.Event) {
+	if !notify.Enabled() {
+		return
+	}
+	if err := notify.Send(e); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+}
+
+// activateProfile loads and activates the profile named by --profile in args, if any.
+func activateProfile(args []string) error {
+	name, ok := flagValue(args, "--profile")
+	if !ok {
+		return nil
+	}
+	p, err := profile.Load(name)
+	if err != nil {
+		return err
+	}
+	return p.Activate()
+}
+
+// applyCredentialsFrom points the Drive client at the env file given by
+// --credentials-from <file> in args, if any. It takes precedence over profiles, so
+// a fresh machine can bootstrap with credentials supplied out-of-band.
+func applyCredentialsFrom(args []string) error {
+	v, ok := flagValue(args, "--credentials-from")
+	if !ok {
+		return nil
+	}
+	path, err := utils.ExpandHome(v)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("invalid --credentials-from: %w", err)
+	}
+	backup.CredentialsEnvFile = path
+	return nil
+}
+
+// applyStoreTimeout bounds each backup store transfer with --store-timeout
+// <duration> from args, if any.
+func applyStoreTimeout(args []string) error {
+	v, ok := flagValue(args, "--store-timeout")
+	if !ok {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("invalid --store-timeout value %q", v)
+	}
+	backup.StoreTimeout = d
+	return nil
+}
+
+// applyTransferLimit caps backup store transfers with --limit <size per second>
+// (e.g. 2MiB) from args, if any.
+func applyTransferLimit(args []string) error {
+	v, ok := flagValue(args, "--limit")
+	if !ok {
+		return nil
+	}
+	n, err := utils.ParseBytes(v)
+	if err != nil {
+		return fmt.Errorf("invalid --limit value %q", v)
+	}
+	backup.TransferLimit = n
+	return nil
+}
+
+// applyJobs sets how many repositories are cloned at once from --jobs <n> in
+// args, if any.
+func applyJobs(args []string) error {
+	v, ok := flagValue(args, "--jobs")
+	if !ok {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return fmt.Errorf("invalid --jobs value %q", v)
+	}
+	clone.Workers = n
+	return nil
+}
+
+// applyTimeout sets the tar and git timeouts from --timeout <duration> in args, if any.
+func applyTimeout(args []string) error {
+	v, ok := flagValue(args, "--timeout")
+	if !ok {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("invalid --timeout value %q", v)
+	}
+	backup.TarTimeout = d
+	clone.GitTimeout = d
+	return nil
+}
+
+// runTokenStatus prints the state of the Google token and whether it can be
+// refreshed, exiting 1 when it cannot.
+func runTokenStatus() int {
+	status, err := backup.CheckToken()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if status.Valid {
+		fmt.Println("Current access token: valid")
+	} else {
+		fmt.Println("Current access token: expired or missing")
+	}
+	fmt.Print(auth.DescribeToken(status.Current))
+	if status.RefreshErr != nil {
+		fmt.Fprintf(os.Stderr, "Error: refresh failed: %v\n", status.RefreshErr)
+		return 1
+	}
+	fmt.Println()
+	fmt.Println("Refresh: OK")
+	fmt.Print(auth.DescribeToken(status.Refreshed))
+	return 0
+}
+
+// runSetToken stores a new refresh token given by --refresh-token, the
+// SETUP_REFRESH_TOKEN variable or stdin, in that order.
+func runSetToken(args []string) int {
+	refreshToken, ok := flagValue(args, "--refresh-token")
+	if !ok || refreshToken == "-" {
+		refreshToken = os.Getenv("SETUP_REFRESH_TOKEN")
+	}
+	if refreshToken == "" {
+		line, err := prompt.Stdio().ReadLine("")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading refresh token from stdin: %v\n", err)
+			return 1
+		}
+		refreshToken = line
+	}
+
+	opts := auth.SetTokenOpts{
+		RefreshToken: refreshToken,
+		Scopes:       auth.Scopes(),
+		EnvFile:      ".env",
+	}
+	if v, ok := flagValue(args, "--credentials"); ok {
+		opts.CredentialsFile = v
+	} else {
+		path, err := auth.CredentialsFile()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		opts.CredentialsFile = path
+	}
+	if v, ok := flagValue(args, "--token-file"); ok {
+		opts.TokenFile = v
+	}
+	if backup.CredentialsEnvFile != "" {
+		opts.EnvFile = backup.CredentialsEnvFile
+	}
+
+	token, err := auth.SetToken(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting token: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Token verified against Google Drive and saved to %s (expires %s).\n", opts.EnvFile, token.Expiry.Format(time.RFC3339))
+	return 0
+}
+
+// runDriveTrash lists the backups in the Google Drive trash.
+func runDriveTrash() int {
+	files, err := backup.ListTrashedBackups()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing trashed backups: %v\n", err)
+		return 1
+	}
+	if len(files) == 0 {
+		fmt.Println("No trashed backups found.")
+		return 0
+	}
+	fmt.Println("Trashed backups (restore with: setup drive-trash --restore <name>):")
+	for _, f := range files {
+		fmt.Printf("  %s  %s  trashed %s\n", f.Name, utils.FormatBytes(f.Size), f.TrashedTime)
+	}
+	return 0
+}
+
+// runRollbackList prints the available rollback points, most recent first.
+func runRollbackList() int {
+	points, err := backup.ListRollbackPoints()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing rollback points: %v\n", err)
+		return 1
+	}
+	if len(points) == 0 {
+		fmt.Println("No rollback points found.")
+		return 0
+	}
+	fmt.Println("Rollback points (restore with: setup rollback <name>):")
+	for _, p := range points {
+		fmt.Printf("  %s  %d file(s)  %s\n", p.Name, p.Files, p.CreatedAt.Format(time.RFC3339))
+	}
+	return 0
+}
+
+// runRollback restores the files saved by the apply of the rollback point name,
+// the most recent one when empty.
+func runRollback(name string) int {
+	restored, err := backup.Rollback(name)
+	for _, target := range restored {
+		fmt.Printf("Restored %s\n", target)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error rolling back: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Rolled back %d file(s).\n", len(restored))
+	return 0
+}
+
+// runPrune trashes all but the keep newest backups on Google Drive.
+func runPrune(keep int) int {
+	deleted, err := backup.PruneDriveBackups(keep)
+	for _, name := range deleted {
+		fmt.Printf("Trashed %s\n", name)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error pruning backups: %v\n", err)
+		return 1
+	}
+	if len(deleted) == 0 {
+		fmt.Printf("Nothing to prune: %d or fewer backups on Google Drive.\n", keep)
+		return 0
+	}
+	fmt.Printf("Pruned %d backup(s); restore with: setup drive-trash --restore <name>\n", len(deleted))
+	return 0
+}
+
+// runInit creates the directory layout setup works in and reports it.
+func runInit() int {
+	created, err := backup.EnsureLayout()
+	for _, dir := range created {
+		fmt.Printf("Created %s\n", dir)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	dirs, err := backup.Layout()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Println("Directory layout is ready:")
+	for _, d := range dirs {
+		fmt.Printf("  %s\n", d.Path)
+	}
+	return 0
+}
+
+// runDoctor prints a checklist of backup.Doctor, failing when a hard check failed.
+func runDoctor() int {
+	status := 0
+	for _, c := range backup.Doctor() {
+		switch {
+		case c.Err == nil:
+			fmt.Printf("[ok]   %s\n", c.Name)
+		case c.Hard:
+			fmt.Printf("[FAIL] %s: %v\n", c.Name, c.Err)
+			status = 1
+		default:
+			fmt.Printf("[warn] %s: %v\n", c.Name, c.Err)
+		}
+	}
+	return status
+}
+
+// runList prints the backups on Google Drive, only those tagged tag when not empty.
+func runList(tag string) int {
+	files, err := backup.ListDriveBackups(tag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing backups: %v\n", err)
+		return 1
+	}
+	if len(files) == 0 {
+		if tag != "" {
+			fmt.Printf("No backups tagged %q found.\n", tag)
+		} else {
+			fmt.Println("No backups found.")
+		}
+		return 0
+	}
+	for _, f := range files {
+		line := fmt.Sprintf("  %s  %s  modified %s", f.Name, utils.FormatBytes(f.Size), f.ModifiedTime)
+		if tags := backup.DriveBackupTags(f); len(tags) > 0 {
+			line += "  [" + strings.Join(tags, ", ") + "]"
+		}
+		fmt.Println(line)
+	}
+	return 0
+}
+
+// runInspect prints the manifest of the backup archive called name.
+func runInspect(name string) int {
+	m, err := backup.LoadArchiveManifest(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading manifest: %v\n", err)
+		return 1
+	}
+	var total int64
+	for _, f := range m.Files {
+		total += f.Size
+	}
+	fmt.Printf("Archive:  %s\n", name)
+	fmt.Printf("Created:  %s\n", m.CreatedAt.Format(time.RFC3339))
+	if !m.Redacted {
+		fmt.Printf("Host:     %s@%s (home %s)\n", m.Username, m.Hostname, m.Home)
+	}
+	fmt.Printf("Sets:     %s\n", strings.Join(m.Sets, ", "))
+	if m.Parent != "" {
+		fmt.Printf("Parent:   %s\n", m.Parent)
+	}
+	if len(m.Tags) > 0 {
+		fmt.Printf("Tags:     %s\n", strings.Join(m.Tags, ", "))
+	}
+	fmt.Printf("Files:    %d (%s)\n", len(m.Files), utils.FormatBytes(total))
+	for _, f := range m.Files {
+		marker := ""
+		if f.FromParent {
+			marker = " (from parent)"
+		}
+		fmt.Printf("  %s  %s%s\n", utils.FormatBytes(f.Size), f.Path, marker)
+	}
+	if len(m.Remove) > 0 {
+		fmt.Printf("Removed on apply: %d\n", len(m.Remove))
+		for _, p := range m.Remove {
+			fmt.Printf("  %s\n", p)
+		}
+	}
+	return 0
+}
+
+// runDiff compares the backup name, restricted to steps, with the live system.
+func runDiff(name string, steps []string, nameOnly bool) int {
+	filter, err := backup.StepsFilter(steps)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	report, err := backup.DiffBackup(name, filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error comparing backup: %v\n", err)
+		return 1
+	}
+	report.Print(os.Stdout, nameOnly)
+	return 0
+}
+
+// runVerify checks the backup called name (the latest one when empty) and prints
+// the outcome.
+func runVerify(name string) int {
+	path, err := backup.FetchBackup(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	report, err := backup.VerifyBackup(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAIL: %v\n", err)
+		return 1
+	}
+	if !report.HasManifest {
+		fmt.Printf("PASS: %s extracts cleanly (no manifest to check files against).\n", report.Archive)
+		return 0
+	}
+	for _, p := range report.Problems {
+		fmt.Fprintf(os.Stderr, "  %v\n", p)
+	}
+	status := "PASS"
+	if !report.Passed() {
+		status = "FAIL"
+	}
+	fmt.Printf("%s: %s: %d ok, %d missing, %d corrupt", status, report.Archive, report.OK, report.Missing, report.Corrupt)
+	if report.Inherited > 0 {
+		fmt.Printf(", %d in base backups (not checked)", report.Inherited)
+	}
+	fmt.Println()
+	if !report.Passed() {
+		return 1
+	}
+	return 0
+}
+
+// runProfiles prints the available profiles with their sets and schedule.
+func runProfiles() int {
+	names, err := profile.List()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing profiles: %v\n", err)
+		return 1
+	}
+	if len(names) == 0 {
+		dir, _ := profile.Dir()
+		fmt.Printf("No profiles found in %s\n", dir)
+		return 0
+	}
+	fmt.Println("Available profiles:")
+	for _, name := range names {
+		p, err := profile.Load(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "  %s: %v\n", name, err)
+			continue
+		}
+		fmt.Printf("  %s  sets=%s", name, strings.Join(p.Sets, ","))
+		if p.Schedule != "" {
+			fmt.Printf("  schedule=%q", p.Schedule)
+		}
+		fmt.Println()
+	}
+	return 0
+}
+
+// stepSummary is the JSON form of a backup step printed by --list-steps.
+type stepSummary struct {
+	Name string `json:"name"`
+}
+
+// runListSteps prints the available backup steps, as a JSON array with asJSON.
+func runListSteps(asJSON bool) int {
+	names, err := backup.BackupStepNames()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing backup steps: %v\n", err)
+		return 1
+	}
+	if asJSON {
+		steps := make([]stepSummary, 0, len(names))
+		for _, name := range names {
+			steps = append(steps, stepSummary{Name: name})
+		}
+		data, err := json.Marshal(steps)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding backup steps: %v\n", err)
+			return 1
+		}
+		fmt.Println(string(data))
+		return 0
+	}
+	fmt.Println("Available backup steps:")
+	for _, name := range names {
+		fmt.Printf("  %s\n", name)
+	}
+	return 0
+}
+
+// setSummary is the JSON form of a backup set printed by list-sets.
+type setSummary struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Folders     int    `json:"folders"`
+	FilesAdd    int    `json:"filesAdd"`
+	FilesRemove int    `json:"filesRemove"`
+}
+
+// runListSets prints the registered backup sets with their path counts, as a
+// JSON array when asJSON is set.
+func runListSets(asJSON bool) int {
+	var sets []setSummary
+	for _, name := range backup.ListBackupSetNames() {
+		set, ok := backup.GetBackupSet(name)
+		if !ok {
+			continue
+		}
+		sets = append(sets, setSummary{
+			Name:        set.Name,
+			Description: set.Description,
+			Folders:     len(set.Folders),
+			FilesAdd:    len(set.FilesAdd),
+			FilesRemove: len(set.FilesRemove),
+		})
+	}
+	if asJSON {
+		data, err := json.MarshalIndent(sets, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding backup sets: %v\n", err)
+			return 1
+		}
+		fmt.Println(string(data))
+		return 0
+	}
+	fmt.Println("Available backup sets:")
+	for _, s := range sets {
+		fmt.Printf("  %s  folders=%d files_add=%d files_remove=%d\n", s.Name, s.Folders, s.FilesAdd, s.FilesRemove)
+		if s.Description != "" {
+			fmt.Printf("      %s\n", s.Description)
+		}
+	}
+	return 0
+}
+
+// hasFlag reports whether flag appears in args.
+func hasFlag(args []string, flag string) bool {
+	for _, a := range args {
+		if a == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// flagValue returns the argument following flag in args, if any.
+func flagValue(args []string, flag string) (string, bool) {
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == flag {
+			return args[i+1], true
+		}
+	}
+	return "", false
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	var out []string
+	for _, s := range strings.Split(value, ",") {
+		if trimmed := strings.TrimSpace(s); trimmed != "" {
+			out = append(out, trimmed)
+		}
+	}
+	return out
+}
@@ -1,28 +1,69 @@
 package clone
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"setup/shared/utils"
 )
 
-type repo struct {
-	User       string
-	Repository string
-	Branch     string
+// GitTimeout bounds every git invocation so a stalled clone cannot hang the run.
+var GitTimeout = 10 * time.Minute
+
+// Workers is the number of repositories CloneAll clones at once.
+var Workers = runtime.NumCPU()
+
+// CloneOpts tunes how repositories are cloned.
+type CloneOpts struct {
+	// Depth, when > 0, makes shallow single-branch clones with that many commits.
+	Depth int
+	// HTTPS clones over HTTPS from the start instead of only when SSH access
+	// is denied.
+	HTTPS bool
+	// FailFast stops starting clones after the first failure; the repositories
+	// not attempted are reported as skipped.
+	FailFast bool
 }
 
-var repositories = map[string][]repo{
-	"/home": {
+// Opts are the CloneOpts used by CloneAll.
+var Opts CloneOpts
+
+// Update makes CloneAll fast-forward repositories that are already cloned
+// instead of skipping them (see updateRepo).
+var Update = false
+
+// Repo identifies a GitHub repository and the branch to check out.
+type Repo struct {
+	User       string `yaml:"user" json:"user"`
+	Repository string `yaml:"repository" json:"repository"`
+	Branch     string `yaml:"branch" json:"branch"`
+}
+
+// repositories lists the repositories to clone keyed by base directory. A base
+// directory starting with ~ or relative is under the user's home (see resolveBaseDir).
+var repositories = map[string][]Repo{
+	"~": {
 		{"alice-bnuy", "tools", "main"},
 		{"alice-bnuy", "setup", "main"},
 		{"RedBearAK", "Toshy", "main"},
 	},
-	"/home/github.com/": {
+	"~/github.com": {
 		{"alice-bnuy", "alicebot", "main"},
 	},
-	"/home/Desktop/github.com": {
+	"~/Desktop/github.com": {
 		{"ekshmr", "simonewebsite", "main"},
 		{"alice-bnuy", "discordcore", "alice-main"},
 		{"alice-bnuy", "errutil", "alice-main"},
@@ -32,26 +73,205 @@ var repositories = map[string][]repo{
 	},
 }
 
-// CloneAll clones all repositories defined in the repositories map using SSH.
-func CloneAll() error {
+// SetRepositories replaces the repositories cloned by CloneAll, keyed by base directory.
+func SetRepositories(repos map[string][]Repo) {
+	repositories = repos
+}
+
+// RepoTarget is a configured repository as CloneAll would clone it.
+type RepoTarget struct {
+	// BaseDir is the base directory as configured (e.g. "~/github.com"); the
+	// clone goes to a subdirectory named after the repository.
+	BaseDir string
+	URL     string
+	Branch  string
+}
+
+// ListRepositories returns the configured repositories without cloning them,
+// sorted by base directory and then URL.
+func ListRepositories() []RepoTarget {
+	var targets []RepoTarget
+	for baseDir, repos := range repositories {
+		for _, r := range repos {
+			targets = append(targets, RepoTarget{BaseDir: baseDir, URL: Opts.cloneURL(r), Branch: r.Branch})
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].BaseDir != targets[j].BaseDir {
+			return targets[i].BaseDir < targets[j].BaseDir
+		}
+		return targets[i].URL < targets[j].URL
+	})
+	return targets
+}
+
+// PrintRepositories writes targets to w as an aligned table.
+func PrintRepositories(w io.Writer, targets []RepoTarget) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "BASE DIR\tURL\tBRANCH")
+	for _, t := range targets {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", t.BaseDir, t.URL, t.Branch)
+	}
+	tw.Flush()
+}
+
+// cloneURL returns the URL r is cloned from: SSH unless opts.HTTPS is set.
+func (opts CloneOpts) cloneURL(r Repo) string {
+	if opts.HTTPS {
+		return httpsURL(r)
+	}
+	return fmt.Sprintf("git@github.com:%s/%s.git", r.User, r.Repository)
+}
+
+// httpsURL returns the HTTPS URL of r, used when SSH access is denied.
+func httpsURL(r Repo) string {
+	return fmt.Sprintf("https://github.com/%s/%s.git", r.User, r.Repository)
+}
+
+// sshAuthFailed reports whether git's stderr says the SSH key was refused or
+// missing.
+func sshAuthFailed(stderr string) bool {
+	return strings.Contains(stderr, "Permission denied (publickey)")
+}
+
+// Actions reported in CloneResult.
+const (
+	ActionCloned        = "cloned"
+	ActionSkipped       = "skipped"
+	ActionBranchCreated = "branch-created"
+	ActionUpdated       = "updated"
+	ActionUpToDate      = "up-to-date"
+	ActionFailed        = "failed"
+)
+
+// CloneResult is the outcome of cloning one repository.
+type CloneResult struct {
+	Repo      Repo   `json:"repo"`
+	TargetDir string `json:"targetDir"`
+	// Action is one of ActionCloned, ActionSkipped, ActionBranchCreated,
+	// ActionUpdated, ActionUpToDate or ActionFailed.
+	Action string `json:"action"`
+	// Detail explains a skip or update (e.g. a dirty worktree or a branch switch).
+	Detail string `json:"detail,omitempty"`
+	// Err is set for ActionFailed.
+	Err error `json:"-"`
+	// Error is the message of Err, for JSON output.
+	Error string `json:"error,omitempty"`
+}
+
+// CloneAll clones all repositories defined in the repositories map using SSH,
+// Workers at a time (see CloneAllConcurrent).
+func CloneAll() ([]CloneResult, error) {
+	return CloneAllConcurrent(Workers)
+}
+
+// CloneAllConcurrent clones all repositories with a pool of workers. A failing
+// repository does not stop the others (unless Opts.FailFast is set): the
+// results cover every repository, ordered by base directory, and the error
+// reports all failures.
+func CloneAllConcurrent(workers int) ([]CloneResult, error) {
 	// Check if git is available
 	if err := checkGitAvailable(); err != nil {
-		return err
+		return nil, err
+	}
+	if workers < 1 {
+		workers = 1
 	}
 
-	for baseDir, repos := range repositories {
-		// Ensure base directory exists
+	type job struct {
+		index   int
+		baseDir string
+		repo    Repo
+	}
+	var jobs []job
+	baseDirs := make([]string, 0, len(repositories))
+	for baseDir := range repositories {
+		baseDirs = append(baseDirs, baseDir)
+	}
+	sort.Strings(baseDirs)
+	for _, key := range baseDirs {
+		baseDir, err := resolveBaseDir(key)
+		if err != nil {
+			return nil, err
+		}
+		// Create base directories up front so workers never race on them.
 		if err := ensureDir(baseDir); err != nil {
-			return fmt.Errorf("failed to create base directory %s: %w", baseDir, err)
+			return nil, fmt.Errorf("failed to create base directory %s: %w", baseDir, err)
+		}
+		for _, r := range repositories[key] {
+			jobs = append(jobs, job{index: len(jobs), baseDir: baseDir, repo: r})
 		}
+	}
 
-		for _, r := range repos {
-			if err := cloneRepo(baseDir, r); err != nil {
-				return err
+	results := make([]CloneResult, len(jobs))
+	queue := make(chan job)
+	var outMu sync.Mutex
+	var wg sync.WaitGroup
+	var failed atomic.Bool
+	for i := 0; i < min(workers, len(jobs)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				if Opts.FailFast && failed.Load() {
+					results[j.index] = CloneResult{Repo: j.repo, TargetDir: filepath.Join(j.baseDir, j.repo.Repository),
+						Action: ActionSkipped, Detail: "not attempted after a failure"}
+					continue
+				}
+				if workers == 1 {
+					results[j.index] = cloneRepo(j.baseDir, j.repo, Opts, os.Stdout)
+				} else {
+					// Buffer each repository's output so parallel clones don't interleave.
+					var out bytes.Buffer
+					results[j.index] = cloneRepo(j.baseDir, j.repo, Opts, &out)
+					outMu.Lock()
+					os.Stdout.Write(out.Bytes())
+					outMu.Unlock()
+				}
+				if results[j.index].Err != nil {
+					failed.Store(true)
+				}
 			}
+		}()
+	}
+	for _, j := range jobs {
+		queue <- j
+	}
+	close(queue)
+	wg.Wait()
+
+	var errs []error
+	for _, res := range results {
+		if res.Err != nil {
+			errs = append(errs, res.Err)
 		}
 	}
-	return nil
+	if len(errs) > 0 {
+		return results, fmt.Errorf("%d of %d repositories failed to clone: %w", len(errs), len(results), errors.Join(errs...))
+	}
+	return results, nil
+}
+
+// PrintResults writes results to w as an aligned table.
+func PrintResults(w io.Writer, results []CloneResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPOSITORY\tBRANCH\tACTION\tTARGET\tDETAIL")
+	for _, r := range results {
+		detail := r.Detail
+		if r.Error != "" {
+			detail = r.Error
+		}
+		fmt.Fprintf(tw, "%s/%s\t%s\t%s\t%s\t%s\n", r.Repo.User, r.Repo.Repository, r.Repo.Branch, r.Action, r.TargetDir, detail)
+	}
+	tw.Flush()
+
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.Action]++
+	}
+	fmt.Fprintf(w, "%d cloned, %d branch(es) created, %d updated, %d up to date, %d skipped, %d failed\n",
+		counts[ActionCloned], counts[ActionBranchCreated], counts[ActionUpdated], counts[ActionUpToDate],
+		counts[ActionSkipped], counts[ActionFailed])
 }
 
 func checkGitAvailable() error {
@@ -62,6 +282,23 @@ func checkGitAvailable() error {
 	return nil
 }
 
+// resolveBaseDir returns the absolute base directory for a repositories key: ~
+// is expanded and relative paths are taken from the user's home.
+func resolveBaseDir(dir string) (string, error) {
+	expanded, err := utils.ExpandHome(dir)
+	if err != nil {
+		return "", fmt.Errorf("could not expand base directory %s: %w", dir, err)
+	}
+	if !filepath.IsAbs(expanded) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		expanded = filepath.Join(home, expanded)
+	}
+	return filepath.Clean(expanded), nil
+}
+
 func ensureDir(dir string) error {
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		fmt.Printf("Creating directory: %s\n", dir)
@@ -70,52 +307,90 @@ func ensureDir(dir string) error {
 	return nil
 }
 
-func cloneRepo(baseDir string, r repo) error {
-	cloneURL := fmt.Sprintf("git@github.com:%s/%s.git", r.User, r.Repository)
+// cloneRepo clones r into baseDir as opts says, writing progress and git's
+// output to out.
+func cloneRepo(baseDir string, r Repo, opts CloneOpts, out io.Writer) CloneResult {
+	cloneURL := opts.cloneURL(r)
 	targetDir := filepath.Join(baseDir, r.Repository)
+	res := CloneResult{Repo: r, TargetDir: targetDir}
+	fail := func(err error) CloneResult {
+		res.Action, res.Err, res.Error = ActionFailed, err, err.Error()
+		return res
+	}
 
 	// Check if repository already exists
 	if _, err := os.Stat(targetDir); err == nil {
-		fmt.Printf("Directory %s already exists, skipping...\n", targetDir)
-		return nil
+		if Update {
+			return updateRepo(targetDir, r, opts, out)
+		}
+		fmt.Fprintf(out, "Directory %s already exists, skipping...\n", targetDir)
+		res.Action = ActionSkipped
+		return res
 	}
 
-	// Check if the remote branch exists
-	branchExists := remoteBranchExists(cloneURL, r.Branch)
+	// Check if the remote branch exists. This is the first contact with
+	// GitHub, so a refused SSH key shows up here and HTTPS is used instead.
+	branchExists, stderr := remoteBranchExists(cloneURL, r.Branch)
+	if !opts.HTTPS && sshAuthFailed(stderr) {
+		fmt.Fprintf(out, "SSH access to %s was denied, falling back to HTTPS\n", cloneURL)
+		cloneURL = httpsURL(r)
+		branchExists, _ = remoteBranchExists(cloneURL, r.Branch)
+	}
 
 	if branchExists {
-		fmt.Printf("Cloning %s (branch: %s) into %s\n", cloneURL, r.Branch, targetDir)
-		cmd := exec.Command("git", "clone", "--branch", r.Branch, cloneURL, targetDir)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to clone %s (branch: %s): %w", cloneURL, r.Branch, err)
+		fmt.Fprintf(out, "Cloning %s (branch: %s) into %s\n", cloneURL, r.Branch, targetDir)
+		cmd := gitCommand(append(append([]string{"clone", "--branch", r.Branch}, opts.depthArgs()...), cloneURL, targetDir)...)
+		cmd.Stdout = out
+		cmd.Stderr = out
+		if err := utils.RunCommand(cmd, GitTimeout); err != nil {
+			return fail(fmt.Errorf("failed to clone %s (branch: %s): %w", cloneURL, r.Branch, err))
 		}
-		fmt.Printf("Successfully cloned %s/%s (branch: %s)\n", r.User, r.Repository, r.Branch)
+		fmt.Fprintf(out, "Successfully cloned %s/%s (branch: %s)\n", r.User, r.Repository, r.Branch)
+		res.Action = ActionCloned
 	} else {
-		fmt.Printf("Remote branch %s does not exist for %s. Cloning default branch and creating local branch.\n", r.Branch, cloneURL)
-		cmd := exec.Command("git", "clone", cloneURL, targetDir)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to clone %s (default branch): %w", cloneURL, err)
+		fmt.Fprintf(out, "Remote branch %s does not exist for %s. Cloning default branch and creating local branch.\n", r.Branch, cloneURL)
+		cmd := gitCommand(append(append([]string{"clone"}, opts.depthArgs()...), cloneURL, targetDir)...)
+		cmd.Stdout = out
+		cmd.Stderr = out
+		if err := utils.RunCommand(cmd, GitTimeout); err != nil {
+			return fail(fmt.Errorf("failed to clone %s (default branch): %w", cloneURL, err))
 		}
 		// Create and switch to the desired branch
-		switchCmd := exec.Command("git", "switch", "-c", r.Branch)
+		switchCmd := gitCommand("switch", "-c", r.Branch)
 		switchCmd.Dir = targetDir
-		switchCmd.Stdout = os.Stdout
-		switchCmd.Stderr = os.Stderr
-		if err := switchCmd.Run(); err != nil {
-			return fmt.Errorf("failed to create and switch to branch %s in %s: %w", r.Branch, targetDir, err)
+		switchCmd.Stdout = out
+		switchCmd.Stderr = out
+		if err := utils.RunCommand(switchCmd, GitTimeout); err != nil {
+			return fail(fmt.Errorf("failed to create and switch to branch %s in %s: %w", r.Branch, targetDir, err))
 		}
-		fmt.Printf("Successfully created and switched to branch %s in %s\n", r.Branch, targetDir)
+		fmt.Fprintf(out, "Successfully created and switched to branch %s in %s\n", r.Branch, targetDir)
+		res.Action = ActionBranchCreated
 	}
-	return nil
+	return res
+}
+
+// depthArgs returns the git clone arguments for opts.Depth.
+func (opts CloneOpts) depthArgs() []string {
+	if opts.Depth <= 0 {
+		return nil
+	}
+	return []string{"--depth", strconv.Itoa(opts.Depth), "--single-branch"}
+}
+
+// remoteBranchExists checks if a branch exists on the remote repository. It
+// also returns git's stderr, so callers can tell why the check failed.
+func remoteBranchExists(cloneURL, branch string) (bool, string) {
+	var output, stderr bytes.Buffer
+	cmd := gitCommand("ls-remote", "--heads", cloneURL, branch)
+	cmd.Stdout = &output
+	cmd.Stderr = &stderr
+	err := utils.RunCommand(cmd, GitTimeout)
+	return err == nil && output.Len() > 0, stderr.String()
 }
 
-// remoteBranchExists checks if a branch exists on the remote repository.
-func remoteBranchExists(cloneURL, branch string) bool {
-	cmd := exec.Command("git", "ls-remote", "--heads", cloneURL, branch)
-	output, err := cmd.Output()
-	return err == nil && len(output) > 0
+// gitCommand returns a git command that fails instead of prompting for credentials.
+func gitCommand(args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	return cmd
 }
@@ -0,0 +1,106 @@
+package clone
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestResolveBaseDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	for dir, want := range map[string]string{
+		"~":                    home,
+		"~/github.com":         filepath.Join(home, "github.com"),
+		"Desktop/github.com/":  filepath.Join(home, "Desktop", "github.com"),
+		"/srv/src/../repos":    "/srv/repos",
+		"~/Desktop/github.com": filepath.Join(home, "Desktop", "github.com"),
+	} {
+		got, err := resolveBaseDir(dir)
+		if err != nil {
+			t.Fatalf("resolveBaseDir(%q): %v", dir, err)
+		}
+		if got != want {
+			t.Errorf("resolveBaseDir(%q) = %q, want %q", dir, got, want)
+		}
+	}
+}
+
+// fakeGit puts a git script on PATH that logs its arguments, one call per line,
+// refuses SSH URLs like GitHub does without a key and otherwise succeeds. It
+// returns the log path.
+func fakeGit(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake git is a shell script")
+	}
+	dir := t.TempDir()
+	log := filepath.Join(dir, "git.log")
+	script := `#!/bin/sh
+echo "$*" >> "` + log + `"
+for arg; do
+	case "$arg" in
+	git@*)
+		echo "git@github.com: Permission denied (publickey)." >&2
+		exit 128
+		;;
+	esac
+done
+case "$1" in
+ls-remote) echo "0123456789abcdef	refs/heads/$4" ;;
+clone) for arg; do last=$arg; done; mkdir -p "$last" ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "git"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return log
+}
+
+func TestCloneRepoFallsBackToHTTPS(t *testing.T) {
+	log := fakeGit(t)
+	r := Repo{User: "alice-bnuy", Repository: "tools", Branch: "main"}
+	var out bytes.Buffer
+	res := cloneRepo(t.TempDir(), r, CloneOpts{}, &out)
+	if res.Err != nil || res.Action != ActionCloned {
+		t.Fatalf("cloneRepo = %s, %v\n%s", res.Action, res.Err, out.String())
+	}
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := strings.Split(strings.TrimSpace(string(data)), "\n")
+	want := []string{
+		"ls-remote --heads git@github.com:alice-bnuy/tools.git main",
+		"ls-remote --heads https://github.com/alice-bnuy/tools.git main",
+		"clone --branch main https://github.com/alice-bnuy/tools.git " + res.TargetDir,
+	}
+	if !slices.Equal(calls, want) {
+		t.Errorf("git calls:\n%s\nwant:\n%s", strings.Join(calls, "\n"), strings.Join(want, "\n"))
+	}
+	if !strings.Contains(out.String(), "falling back to HTTPS") {
+		t.Errorf("fallback not reported:\n%s", out.String())
+	}
+}
+
+func TestCloneRepoHTTPS(t *testing.T) {
+	log := fakeGit(t)
+	r := Repo{User: "alice-bnuy", Repository: "tools", Branch: "main"}
+	if res := cloneRepo(t.TempDir(), r, CloneOpts{HTTPS: true}, io.Discard); res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "git@") {
+		t.Errorf("SSH tried with HTTPS set:\n%s", data)
+	}
+}
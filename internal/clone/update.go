@@ -0,0 +1,102 @@
+package clone
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"setup/shared/utils"
+)
+
+// updateRepo brings the existing clone in targetDir up to date with r.Branch on
+// origin: it fetches, switches to the branch if another one is checked out and
+// pulls with --ff-only, keeping shallow clones shallow when opts.Depth is set.
+// A worktree with uncommitted changes is left alone.
+func updateRepo(targetDir string, r Repo, opts CloneOpts, out io.Writer) CloneResult {
+	res := CloneResult{Repo: r, TargetDir: targetDir}
+	fail := func(err error) CloneResult {
+		res.Action, res.Err, res.Error = ActionFailed, err, err.Error()
+		return res
+	}
+
+	status, err := gitOutput(targetDir, "status", "--porcelain")
+	if err != nil {
+		return fail(fmt.Errorf("could not check %s for local changes: %w", targetDir, err))
+	}
+	if status != "" {
+		fmt.Fprintf(os.Stderr, "Warning: %s has uncommitted changes, not updating it\n", targetDir)
+		res.Action, res.Detail = ActionSkipped, "uncommitted changes"
+		return res
+	}
+
+	fmt.Fprintf(out, "Updating %s (branch: %s)\n", targetDir, r.Branch)
+	fetchArgs := []string{"fetch", "origin"}
+	if opts.Depth > 0 {
+		// Single-branch clones only fetch their default branch; name r.Branch,
+		// if origin has it.
+		heads, err := gitOutput(targetDir, "ls-remote", "--heads", "origin", r.Branch)
+		if err != nil {
+			return fail(fmt.Errorf("failed to list the branches of origin in %s: %w", targetDir, err))
+		}
+		if heads != "" {
+			fetchArgs = append(fetchArgs, "--depth", strconv.Itoa(opts.Depth), "+refs/heads/"+r.Branch+":refs/remotes/origin/"+r.Branch)
+		}
+	}
+	if err := runGit(targetDir, out, fetchArgs...); err != nil {
+		return fail(fmt.Errorf("failed to fetch in %s: %w", targetDir, err))
+	}
+	if _, err := gitOutput(targetDir, "rev-parse", "--verify", "--quiet", "refs/remotes/origin/"+r.Branch); err != nil {
+		res.Action, res.Detail = ActionSkipped, "branch "+r.Branch+" is not on origin"
+		fmt.Fprintf(out, "Branch %s does not exist on origin for %s, leaving it as is\n", r.Branch, targetDir)
+		return res
+	}
+
+	current, err := gitOutput(targetDir, "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return fail(fmt.Errorf("could not read the current branch of %s: %w", targetDir, err))
+	}
+	if current != r.Branch {
+		if err := runGit(targetDir, out, "switch", r.Branch); err != nil {
+			return fail(fmt.Errorf("failed to switch %s from %s to %s: %w", targetDir, current, r.Branch, err))
+		}
+		res.Detail = fmt.Sprintf("switched from %s", current)
+		fmt.Fprintf(out, "Switched %s from %s to %s\n", targetDir, current, r.Branch)
+	}
+
+	before, _ := gitOutput(targetDir, "rev-parse", "HEAD")
+	pullArgs := []string{"pull", "--ff-only"}
+	if opts.Depth > 0 {
+		pullArgs = append(pullArgs, "--depth", strconv.Itoa(opts.Depth))
+	}
+	if err := runGit(targetDir, out, append(pullArgs, "origin", r.Branch)...); err != nil {
+		return fail(fmt.Errorf("could not fast-forward %s to origin/%s (diverged from the remote? update it by hand): %w", targetDir, r.Branch, err))
+	}
+	after, _ := gitOutput(targetDir, "rev-parse", "HEAD")
+	if before == after && current == r.Branch {
+		res.Action = ActionUpToDate
+	} else {
+		res.Action = ActionUpdated
+		fmt.Fprintf(out, "Successfully updated %s/%s (branch: %s)\n", r.User, r.Repository, r.Branch)
+	}
+	return res
+}
+
+// runGit runs git with args in dir, writing its output to out.
+func runGit(dir string, out io.Writer, args ...string) error {
+	cmd := gitCommand(append([]string{"-C", dir}, args...)...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	return utils.RunCommand(cmd, GitTimeout)
+}
+
+// gitOutput runs git with args in dir and returns its trimmed standard output.
+func gitOutput(dir string, args ...string) (string, error) {
+	var stdout bytes.Buffer
+	cmd := gitCommand(append([]string{"-C", dir}, args...)...)
+	cmd.Stdout = &stdout
+	err := utils.RunCommand(cmd, GitTimeout)
+	return strings.TrimSpace(stdout.String()), err
+}
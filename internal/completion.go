@@ -0,0 +1,240 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"setup/internal/backup"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// completionCommand is a subcommand as the completion scripts know it.
+type completionCommand struct {
+	Name  string
+	Flags []string
+}
+
+// completionCommands lists the subcommands of RunCLI and the flags each accepts.
+var completionCommands = []completionCommand{
+	{"create", []string{"--profile", "--timeout", "--store-timeout", "--limit", "--credentials-from", "--alicebot", "--set",
+		"--size-warn", "--since-last", "--incremental", "--local-only", "--dry-run", "--upload", "--keep-empty-dirs",
+		"--redact-manifest", "--verify-after-upload", "--verify-full", "--only-new", "--resume", "--exclude-credentials",
+		"--skip-hidden", "--ignore-missing", "--system-tar", "--encrypt", "--target-home", "--exclude-set", "--tag",
+		"--name", "--format", "--level", "--no-progress"}},
+	{"apply", []string{"--profile", "--timeout", "--store-timeout", "--limit", "--jobs", "--credentials-from", "--steps",
+		"--exclude-steps", "--validate", "--strict", "--keep-archived-home", "--keep-removed", "--no-download",
+		"--no-hooks", "--update-only", "--only-missing", "--overwrite", "--confirm", "--backup-set", "--print-plan",
+		"--dry-run"}},
+	{"diff", []string{"--profile", "--credentials-from", "--store-timeout", "--steps", "--name-only"}},
+	{"verify", []string{"--profile", "--credentials-from", "--store-timeout", "--limit"}},
+	{"inspect", []string{"--profile"}},
+	{"list", []string{"--profile", "--credentials-from", "--tag"}},
+	{"prune", []string{"--profile", "--credentials-from", "--keep"}},
+	{"restore-file", []string{"--profile", "--credentials-from", "--store-timeout", "--limit", "--from"}},
+	{"rollback", []string{"--list"}},
+	{"drive-trash", []string{"--profile", "--credentials-from", "--restore"}},
+	{"clone", []string{"--profile", "--timeout", "--store-timeout", "--limit", "--jobs", "--credentials-from", "--https",
+		"--list", "--update", "--fail-fast", "--depth", "--json"}},
+	{"init", nil},
+	{"doctor", []string{"--credentials-from"}},
+	{"profiles", nil},
+	{"list-sets", []string{"--json"}},
+	{"export-sets", nil},
+	{"import-sets", nil},
+	{"refresh_token", []string{"--manual", "--device"}},
+	{"oauth_token", nil},
+	{"set-token", []string{"--profile", "--credentials-from", "--refresh-token", "--credentials", "--token-file"}},
+	{"token-status", []string{"--profile", "--credentials-from"}},
+	{"completion", nil},
+	{"--list-steps", []string{"--json"}},
+	{"--help", nil},
+}
+
+// Flags whose values the completion scripts ask "setup completion --values" for,
+// so custom backup sets are offered too.
+var (
+	stepFlags = []string{"--steps", "--exclude-steps"}
+	setFlags  = []string{"--set", "--exclude-set", "--backup-set"}
+)
+
+// completionValues are the fixed values of the flags that have some.
+var completionValues = map[string][]string{
+	"--format":    {backup.FormatXz, backup.FormatZstd},
+	"--overwrite": {"always", "never", "if-newer", "if-differ", "prompt", "missing-only"},
+}
+
+// completionShells are the shells completion scripts are generated for.
+var completionShells = map[string]string{
+	"bash": bashCompletion,
+	"zsh":  zshCompletion,
+	"fish": fishCompletion,
+}
+
+// runCompletion prints the completion script for the shell named by args[0] or,
+// with --values steps|sets, the backup step or set names one per line (used by
+// the scripts).
+func runCompletion(args []string) int {
+	if kind, ok := flagValue(args, "--values"); ok {
+		values, err := completionNames(kind)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		for _, v := range values {
+			fmt.Println(v)
+		}
+		return 0
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: No shell specified for completion command.")
+		fmt.Println("Usage: setup completion <bash|zsh|fish>")
+		return 1
+	}
+	if err := writeCompletion(os.Stdout, args[0]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// completionNames returns the backup step ("steps") or set ("sets") names.
+func completionNames(kind string) ([]string, error) {
+	switch kind {
+	case "steps":
+		return backup.BackupStepNames()
+	case "sets":
+		return backup.ListBackupSetNames(), nil
+	default:
+		return nil, fmt.Errorf("unknown completion values %q (use steps or sets)", kind)
+	}
+}
+
+// writeCompletion writes the completion script for shell to w.
+func writeCompletion(w io.Writer, shell string) error {
+	text, ok := completionShells[shell]
+	if !ok {
+		return fmt.Errorf("unsupported shell %q (use bash, zsh or fish)", shell)
+	}
+	tmpl, err := template.New(shell).Funcs(template.FuncMap{"join": strings.Join}).Parse(text)
+	if err != nil {
+		return err
+	}
+	var commands []string
+	for _, c := range completionCommands {
+		commands = append(commands, c.Name)
+	}
+	var valueFlags []string
+	for flag := range completionValues {
+		valueFlags = append(valueFlags, flag)
+	}
+	sort.Strings(valueFlags)
+	// fish declares the values of a flag along with the flag itself.
+	fishArgs := make(map[string]string)
+	for _, flag := range stepFlags {
+		fishArgs[flag] = " -x -a '(setup completion --values steps 2>/dev/null)'"
+	}
+	for _, flag := range setFlags {
+		fishArgs[flag] = " -x -a '(setup completion --values sets 2>/dev/null)'"
+	}
+	for flag, values := range completionValues {
+		fishArgs[flag] = " -x -a '" + strings.Join(values, " ") + "'"
+	}
+	return tmpl.Execute(w, map[string]any{
+		"Commands":    commands,
+		"Subcommands": completionCommands,
+		"StepFlags":   stepFlags,
+		"SetFlags":    setFlags,
+		"ValueFlags":  valueFlags,
+		"Values":      completionValues,
+		"FishArgs":    fishArgs,
+	})
+}
+
+const bashCompletion = `# bash completion for setup; load with: source <(setup completion bash)
+_setup() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+	local IFS=$'\n'
+	if [[ $COMP_CWORD -eq 1 ]]; then
+		COMPREPLY=($(compgen -W "{{join .Commands "\n"}}" -- "$cur"))
+		return
+	fi
+	case $prev in
+	{{join .StepFlags "|"}})
+		COMPREPLY=($(compgen -W "$(setup completion --values steps 2>/dev/null)" -- "$cur"))
+		COMPREPLY=($(printf '%q\n' "${COMPREPLY[@]}"))
+		return ;;
+	{{join .SetFlags "|"}})
+		COMPREPLY=($(compgen -W "$(setup completion --values sets 2>/dev/null)" -- "$cur"))
+		return ;;
+{{- range .ValueFlags}}
+	{{.}})
+		COMPREPLY=($(compgen -W "{{join (index $.Values .) "\n"}}" -- "$cur"))
+		return ;;
+{{- end}}
+	esac
+	if [[ $cur == -* ]]; then
+		case ${COMP_WORDS[1]} in
+{{- range .Subcommands}}{{if .Flags}}
+		{{.Name}}) COMPREPLY=($(compgen -W "{{join .Flags "\n"}}" -- "$cur")) ;;
+{{- end}}{{end}}
+		esac
+		return
+	fi
+	if [[ ${COMP_WORDS[1]} == completion ]]; then
+		COMPREPLY=($(compgen -W "bash"$'\n'"zsh"$'\n'"fish" -- "$cur"))
+	fi
+}
+complete -o default -F _setup setup
+`
+
+const zshCompletion = `#compdef setup
+# zsh completion for setup; load with: source <(setup completion zsh)
+_setup() {
+	local -a values
+	if (( CURRENT == 2 )); then
+		compadd -- {{join .Commands " "}}
+		return
+	fi
+	case ${words[CURRENT-1]} in
+	{{join .StepFlags "|"}})
+		values=("${(@f)$(setup completion --values steps 2>/dev/null)}")
+		compadd -a values
+		return ;;
+	{{join .SetFlags "|"}})
+		values=("${(@f)$(setup completion --values sets 2>/dev/null)}")
+		compadd -a values
+		return ;;
+{{- range .ValueFlags}}
+	{{.}})
+		compadd -- {{join (index $.Values .) " "}}
+		return ;;
+{{- end}}
+	esac
+	if [[ ${words[CURRENT]} == -* ]]; then
+		case ${words[2]} in
+{{- range .Subcommands}}{{if .Flags}}
+		{{.Name}}) compadd -- {{join .Flags " "}} ;;
+{{- end}}{{end}}
+		esac
+		return
+	fi
+	if [[ ${words[2]} == completion ]]; then
+		compadd -- bash zsh fish
+		return
+	fi
+	_files
+}
+compdef _setup setup
+`
+
+const fishCompletion = `# fish completion for setup; load with: setup completion fish | source
+complete -c setup -f
+complete -c setup -n __fish_use_subcommand -a '{{join .Commands " "}}'
+complete -c setup -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'
+complete -c setup -n '__fish_seen_subcommand_from apply diff verify inspect restore-file export-sets import-sets' -F
+{{- range .Subcommands}}{{$cmd := .Name}}{{range .Flags}}
+complete -c setup -n '__fish_seen_subcommand_from {{$cmd}}' -l {{slice . 2}}{{index $.FishArgs .}}
+{{- end}}{{end}}
+`
@@ -0,0 +1,123 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// Command, when set, is run through "sh -c" after create and apply complete. The
+// event is passed in SETUP_* environment variables (see Event.env).
+var Command string
+
+// WebhookURL, when set, receives the event as a JSON POST after create and apply
+// complete.
+var WebhookURL string
+
+// Timeout bounds each notification so a hung notifier does not block the run.
+var Timeout = 30 * time.Second
+
+// Event describes the outcome of a create or apply run.
+type Event struct {
+	Operation string        `json:"operation"`
+	Status    string        `json:"status"`
+	Archive   string        `json:"archive,omitempty"`
+	Size      int64         `json:"size,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// NewEvent builds the event for operation finishing with err after starting at start.
+func NewEvent(operation string, start time.Time, archive string, err error) Event {
+	e := Event{Operation: operation, Status: "success", Archive: archive, Duration: time.Since(start)}
+	if err != nil {
+		e.Status = "failure"
+		e.Error = err.Error()
+	}
+	if archive != "" {
+		if info, statErr := os.Stat(archive); statErr == nil {
+			e.Size = info.Size()
+		}
+	}
+	return e
+}
+
+// Enabled reports whether any notifier is configured.
+func Enabled() bool {
+	return Command != "" || WebhookURL != ""
+}
+
+// Send dispatches e to the configured command and webhook. It is a no-op when
+// neither is configured.
+func Send(e Event) error {
+	if Command != "" {
+		if err := runCommand(e); err != nil {
+			return err
+		}
+	}
+	if WebhookURL != "" {
+		if err := postWebhook(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runCommand runs Command with the event in its environment.
+func runCommand(e Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", Command)
+	cmd.Env = append(os.Environ(), e.env()...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("notification command timed out after %s", Timeout)
+		}
+		return fmt.Errorf("notification command failed: %w", err)
+	}
+	return nil
+}
+
+// postWebhook posts the event as JSON to WebhookURL.
+func postWebhook(e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid notification webhook: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("notification webhook failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notification webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// env returns the event as SETUP_* environment variables.
+func (e Event) env() []string {
+	return []string{
+		"SETUP_OPERATION=" + e.Operation,
+		"SETUP_STATUS=" + e.Status,
+		"SETUP_ARCHIVE=" + e.Archive,
+		"SETUP_SIZE=" + strconv.FormatInt(e.Size, 10),
+		"SETUP_DURATION=" + strconv.FormatFloat(e.Duration.Seconds(), 'f', 1, 64),
+		"SETUP_ERROR=" + e.Error,
+	}
+}
@@ -0,0 +1,142 @@
+package profile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"setup/internal/backup"
+	"setup/internal/clone"
+	"setup/internal/notify"
+	"setup/shared/utils"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile bundles a whole backup configuration: which sets to back up, which
+// repositories to clone, where backups are stored on Google Drive and which
+// credentials to use. Profiles are loaded from ~/.config/setup/profiles/<name>.yaml.
+type Profile struct {
+	Name string `yaml:"name"`
+	// Sets are the backup set names used by create.
+	Sets []string `yaml:"sets"`
+	// Repos replaces the built-in repository list, keyed by base directory.
+	Repos map[string][]clone.Repo `yaml:"repos"`
+	// DrivePath is the Google Drive folder backups are uploaded to (e.g. "linux/backups").
+	DrivePath string `yaml:"drive_path"`
+	// EnvFile is the env file holding the Google credentials for this profile.
+	EnvFile string `yaml:"env_file"`
+	// Schedule documents how often the profile should run (e.g. a cron expression).
+	// It is not acted upon by setup itself; external schedulers can read it.
+	Schedule string `yaml:"schedule"`
+	// Notify configures notifications sent when create or apply completes.
+	Notify Notify `yaml:"notify"`
+}
+
+// Notify configures the post-run notification hook. Both notifiers are off
+// unless set.
+type Notify struct {
+	// Command is run through "sh -c" with the result in SETUP_* env variables.
+	Command string `yaml:"command"`
+	// Webhook receives the result as a JSON POST.
+	Webhook string `yaml:"webhook"`
+	// Timeout bounds each notification (e.g. "30s").
+	Timeout string `yaml:"timeout"`
+}
+
+// Dir returns the directory profiles are loaded from.
+func Dir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "setup", "profiles"), nil
+}
+
+// Load reads the profile called name from Dir.
+func Load(name string) (*Profile, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	for _, ext := range []string{".yaml", ".yml"} {
+		data, err = os.ReadFile(filepath.Join(dir, name+ext))
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("profile %q not found in %s", name, dir)
+	}
+
+	var p Profile
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("could not parse profile %q: %w", name, err)
+	}
+	if p.Name == "" {
+		p.Name = name
+	}
+	return &p, nil
+}
+
+// List returns the names of all profiles in Dir, sorted.
+func List() ([]string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(e.Name(), ext))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Activate applies the profile to the backup and clone packages. Fields left
+// empty keep the built-in defaults.
+func (p *Profile) Activate() error {
+	if len(p.Sets) > 0 {
+		if err := backup.UseBackupSets(p.Sets...); err != nil {
+			return fmt.Errorf("profile %q: %w", p.Name, err)
+		}
+	}
+	if len(p.Repos) > 0 {
+		clone.SetRepositories(p.Repos)
+	}
+	if p.DrivePath != "" {
+		backup.DriveBackupDir = p.DrivePath
+	}
+	if p.EnvFile != "" {
+		envFile, err := utils.ExpandHome(p.EnvFile)
+		if err != nil {
+			return err
+		}
+		backup.CredentialsEnvFile = envFile
+	}
+	if p.Notify.Timeout != "" {
+		d, err := time.ParseDuration(p.Notify.Timeout)
+		if err != nil {
+			return fmt.Errorf("profile %q: invalid notify timeout: %w", p.Name, err)
+		}
+		notify.Timeout = d
+	}
+	notify.Command = p.Notify.Command
+	notify.WebhookURL = p.Notify.Webhook
+	return nil
+}
@@ -0,0 +1,50 @@
+package prompt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrQuit is returned by an OverwriteConfirmer answered with "q".
+var ErrQuit = errors.New("stopped at the user's request")
+
+// OverwriteConfirmer asks p before each file is overwritten:
+// "Overwrite <target>? [y/N/a(ll)/q(uit)]". Answering "a" overwrites the
+// remaining files without asking and "q" stops with ErrQuit; anything but
+// "y" keeps the file.
+type OverwriteConfirmer struct {
+	p   Prompter
+	all bool
+}
+
+// NewOverwriteConfirmer returns an OverwriteConfirmer asking p.
+func NewOverwriteConfirmer(p Prompter) *OverwriteConfirmer {
+	return &OverwriteConfirmer{p: p}
+}
+
+// Confirm reports whether target may be overwritten.
+func (c *OverwriteConfirmer) Confirm(target string) (bool, error) {
+	if c.all {
+		return true, nil
+	}
+	answer, err := c.p.ReadLine(fmt.Sprintf("Overwrite %s? [y/N/a(ll)/q(uit)] ", target))
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(answer) {
+	case "a", "all":
+		c.all = true
+		return true, nil
+	case "q", "quit":
+		return false, ErrQuit
+	}
+	return isYes(answer), nil
+}
+
+// IsInteractive reports whether stdin is a terminal, so prompts can be answered.
+func IsInteractive() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,48 @@
+package prompt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestOverwriteConfirmer(t *testing.T) {
+	s := &Scripted{Answers: []string{"y", "", "N", "a"}}
+	c := NewOverwriteConfirmer(s)
+	targets := []string{"/home/u/.zshrc", "/home/u/.gitconfig", "/home/u/.XCompose", "/home/u/.bashrc", "/home/u/.profile", "/home/u/.vimrc"}
+	want := []bool{true, false, false, true, true, true}
+	for i, target := range targets {
+		ok, err := c.Confirm(target)
+		if err != nil {
+			t.Fatalf("Confirm(%s): %v", target, err)
+		}
+		if ok != want[i] {
+			t.Errorf("Confirm(%s) = %v, want %v", target, ok, want[i])
+		}
+	}
+	// "all" stops the questions: only the first four targets were asked about.
+	if n := strings.Count(s.Output.String(), "Overwrite "); n != 4 {
+		t.Errorf("asked %d times, want 4:\n%s", n, s.Output.String())
+	}
+	if !strings.Contains(s.Output.String(), "Overwrite /home/u/.zshrc? [y/N/a(ll)/q(uit)] ") {
+		t.Errorf("unexpected prompt:\n%s", s.Output.String())
+	}
+}
+
+func TestOverwriteConfirmerQuit(t *testing.T) {
+	c := NewOverwriteConfirmer(&Scripted{Answers: []string{"q"}})
+	if ok, err := c.Confirm("/home/u/.zshrc"); ok || !errors.Is(err, ErrQuit) {
+		t.Errorf("Confirm = %v, %v; want ErrQuit", ok, err)
+	}
+}
+
+func TestOverwriteConfirmerReader(t *testing.T) {
+	var out strings.Builder
+	c := NewOverwriteConfirmer(New(strings.NewReader("yes\n"), &out))
+	if ok, err := c.Confirm("/home/u/.zshrc"); !ok || err != nil {
+		t.Errorf("Confirm = %v, %v; want true", ok, err)
+	}
+	if _, err := c.Confirm("/home/u/.bashrc"); err == nil {
+		t.Error("Confirm at end of input succeeded")
+	}
+}
@@ -0,0 +1,102 @@
+package prompt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Prompter abstracts interactive input and output so interactive flows can be
+// driven programmatically.
+type Prompter interface {
+	// ReadLine prints prompt and returns the next line of input, trimmed.
+	ReadLine(prompt string) (string, error)
+	// Confirm asks a yes/no question; only "y" or "yes" count as yes.
+	Confirm(prompt string) (bool, error)
+	// Println prints a line of output.
+	Println(a ...any)
+}
+
+// IO is a Prompter reading lines from a reader and writing to a writer.
+type IO struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+// New returns a Prompter reading from r and writing to w.
+func New(r io.Reader, w io.Writer) *IO {
+	return &IO{r: bufio.NewReader(r), w: w}
+}
+
+// Stdio returns a Prompter on the process' stdin and stdout.
+func Stdio() *IO {
+	return New(os.Stdin, os.Stdout)
+}
+
+// ReadLine implements Prompter.
+func (p *IO) ReadLine(prompt string) (string, error) {
+	fmt.Fprint(p.w, prompt)
+	line, err := p.r.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// Confirm implements Prompter.
+func (p *IO) Confirm(prompt string) (bool, error) {
+	answer, err := p.ReadLine(prompt + " [y/N] ")
+	if err != nil {
+		return false, err
+	}
+	return isYes(answer), nil
+}
+
+// Println implements Prompter.
+func (p *IO) Println(a ...any) {
+	fmt.Fprintln(p.w, a...)
+}
+
+// Scripted is a Prompter answering from a fixed list of lines and recording all
+// output, for driving interactive flows from code.
+type Scripted struct {
+	Answers []string
+	Output  strings.Builder
+}
+
+// ReadLine implements Prompter, returning io.EOF once the answers run out.
+func (s *Scripted) ReadLine(prompt string) (string, error) {
+	s.Output.WriteString(prompt)
+	if len(s.Answers) == 0 {
+		return "", io.EOF
+	}
+	answer := s.Answers[0]
+	s.Answers = s.Answers[1:]
+	return strings.TrimSpace(answer), nil
+}
+
+// Confirm implements Prompter.
+func (s *Scripted) Confirm(prompt string) (bool, error) {
+	answer, err := s.ReadLine(prompt + " [y/N] ")
+	if err != nil {
+		return false, err
+	}
+	return isYes(answer), nil
+}
+
+// Println implements Prompter.
+func (s *Scripted) Println(a ...any) {
+	fmt.Fprintln(&s.Output, a...)
+}
+
+// isYes reports whether answer is an affirmative reply.
+func isYes(answer string) bool {
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrCommandTimeout is returned by RunCommand when the command was killed for
+// exceeding its timeout.
+var ErrCommandTimeout = errors.New("command timed out")
+
+// waitDelay bounds how long RunCommand waits, once the command exited or was
+// killed, for processes it started (git's ssh, the compressor run by tar) that
+// still hold its output pipes open.
+var waitDelay = 5 * time.Second
+
+// RunCommand runs cmd like cmd.Run, killing it once timeout elapses. A timeout of
+// zero or less disables the limit. Killing only reaches cmd's own process, so the
+// pipes its children inherited are closed after waitDelay to bound Wait too.
+func RunCommand(cmd *exec.Cmd, timeout time.Duration) error {
+	if timeout <= 0 {
+		return cmd.Run()
+	}
+	if cmd.WaitDelay == 0 {
+		cmd.WaitDelay = waitDelay
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(timeout, func() {
+		timedOut.Store(true)
+		_ = cmd.Process.Kill()
+	})
+	err := cmd.Wait()
+	timer.Stop()
+	if timedOut.Load() {
+		return fmt.Errorf("%w after %s: %s", ErrCommandTimeout, timeout, strings.Join(cmd.Args, " "))
+	}
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// cmd succeeded; only a process it left behind still held its output.
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestRunCommandTimeoutWithChildHoldingOutput(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	old := waitDelay
+	waitDelay = 200 * time.Millisecond
+	t.Cleanup(func() { waitDelay = old })
+
+	// The background sleep inherits stdout and outlives the killed shell.
+	cmd := exec.Command("sh", "-c", "sleep 30 & sleep 30")
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	start := time.Now()
+	err := RunCommand(cmd, 100*time.Millisecond)
+	if !errors.Is(err, ErrCommandTimeout) {
+		t.Fatalf("err = %v, want ErrCommandTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("RunCommand returned after %s", elapsed)
+	}
+}
@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// UpdateEnvFile sets the variables in values in the .env at path, creating it if
+// needed. Lines assigning those variables are rewritten in place and the others,
+// comments included, are kept as they are; variables not yet in the file are
+// appended. The file is replaced atomically with a copy written with perm, so a
+// crash never leaves it half-written nor its secrets readable by others.
+func UpdateEnvFile(path string, values map[string]string, perm os.FileMode) (err error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var out bytes.Buffer
+	written := make(map[string]bool)
+	lines := strings.SplitAfter(string(data), "\n")
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		export, key := envLineKey(line)
+		value, ok := values[key]
+		if !ok {
+			out.WriteString(line)
+			continue
+		}
+		if export {
+			out.WriteString("export ")
+		}
+		out.WriteString(envLine(key, value) + "\n")
+		written[key] = true
+	}
+	if out.Len() > 0 && !bytes.HasSuffix(out.Bytes(), []byte("\n")) {
+		out.WriteString("\n")
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		if !written[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		out.WriteString(envLine(k, values[k]) + "\n")
+	}
+
+	// The temporary copy is private until it has its final permissions.
+	f, err := createTemp(path, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err = f.Write(out.Bytes()); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Chmod(perm); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// envLineKey returns the variable a .env line assigns, if any, and whether it is
+// written with an "export " prefix.
+func envLineKey(line string) (bool, string) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "#") {
+		return false, ""
+	}
+	export := false
+	if rest, ok := strings.CutPrefix(line, "export "); ok {
+		export, line = true, strings.TrimSpace(rest)
+	}
+	key, _, ok := strings.Cut(line, "=")
+	if !ok {
+		return false, ""
+	}
+	return export, strings.TrimSpace(key)
+}
+
+// envLine formats key=value the way godotenv writes it.
+func envLine(key, value string) string {
+	line, _ := godotenv.Marshal(map[string]string{key: value})
+	return line
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/joho/godotenv"
+)
+
+func TestUpdateEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".env")
+	orig := "# Google credentials\nGOOGLE_CLIENT_ID=id\n\nexport GOOGLE_ACCESS_TOKEN=\"old\"\nOTHER=keep # trailing\n"
+	if err := os.WriteFile(path, []byte(orig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := UpdateEnvFile(path, map[string]string{"GOOGLE_ACCESS_TOKEN": "new token", "GOOGLE_TOKEN_TYPE": "Bearer"}, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Google credentials\nGOOGLE_CLIENT_ID=id\n\nexport GOOGLE_ACCESS_TOKEN=\"new token\"\nOTHER=keep # trailing\nGOOGLE_TOKEN_TYPE=\"Bearer\"\n"
+	if string(data) != want {
+		t.Errorf("got\n%s\nwant\n%s", data, want)
+	}
+	env, err := godotenv.Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env["GOOGLE_ACCESS_TOKEN"] != "new token" || env["OTHER"] != "keep" {
+		t.Errorf("unexpected values %v", env)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
+
+func TestUpdateEnvFileCreates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := UpdateEnvFile(path, map[string]string{"B": "2", "A": "x"}, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "A=\"x\"\nB=2\n"; string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+}
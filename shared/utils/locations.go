@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Location placeholders a path may start with. They resolve to the platform's
+// per-user directory, so one path works on Linux and macOS:
+//
+//	{config}  ~/.config (or $XDG_CONFIG_HOME) on Linux, ~/Library/Application Support on macOS
+//	{cache}   ~/.cache (or $XDG_CACHE_HOME) on Linux, ~/Library/Caches on macOS
+//	{data}    ~/.local/share (or $XDG_DATA_HOME) on Linux, ~/Library/Application Support on macOS
+const (
+	LocationConfig = "{config}"
+	LocationCache  = "{cache}"
+	LocationData   = "{data}"
+)
+
+// expandLocation replaces a leading location placeholder in path.
+func expandLocation(path string) (string, error) {
+	for _, loc := range []string{LocationConfig, LocationCache, LocationData} {
+		if path != loc && !strings.HasPrefix(path, loc+"/") {
+			continue
+		}
+		dir, err := locationDir(loc)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, path[len(loc):]), nil
+	}
+	return path, nil
+}
+
+// ExpandHomeIn is like ExpandHome, but resolves "~" and the location placeholders
+// against home (for instance another user's home) using the platform defaults,
+// ignoring the XDG variables of the running user.
+func ExpandHomeIn(path, home string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(home, path[1:])
+	}
+	for _, loc := range []string{LocationConfig, LocationCache, LocationData} {
+		if path == loc || strings.HasPrefix(path, loc+"/") {
+			return filepath.Join(home, defaultLocations[runtime.GOOS == "darwin"][loc], path[len(loc):])
+		}
+	}
+	return path
+}
+
+// defaultLocations maps the placeholders to their default directory below the
+// home, indexed by whether the platform is macOS.
+var defaultLocations = map[bool]map[string]string{
+	false: {LocationConfig: ".config", LocationCache: ".cache", LocationData: ".local/share"},
+	true: {
+		LocationConfig: "Library/Application Support",
+		LocationCache:  "Library/Caches",
+		LocationData:   "Library/Application Support",
+	},
+}
+
+// locationDir returns the directory loc stands for on this platform.
+func locationDir(loc string) (string, error) {
+	switch loc {
+	case LocationConfig:
+		return os.UserConfigDir()
+	case LocationCache:
+		return os.UserCacheDir()
+	}
+	// {data} has no os.User*Dir counterpart.
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS == "darwin" {
+		return filepath.Join(home, "Library", "Application Support"), nil
+	}
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return dir, nil
+	}
+	return filepath.Join(home, ".local", "share"), nil
+}
+
+// StateDir returns the directory setup keeps its persistent state in
+// ($XDG_STATE_HOME/setup, by default ~/.local/state/setup).
+func StateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "setup"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "setup"), nil
+}
+
+// SetupRoot returns the directory of the setup repository, which holds the
+// backups, assets and .env: $SETUP_ROOT when set (it may start with "~"), by
+// default ~/setup.
+func SetupRoot() (string, error) {
+	if root := os.Getenv("SETUP_ROOT"); root != "" {
+		expanded, err := ExpandHome(root)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Abs(expanded)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "setup"), nil
+}
@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestExpandHomeIn(t *testing.T) {
+	// The XDG variables belong to the running user, not to home's owner.
+	t.Setenv("XDG_CONFIG_HOME", "/elsewhere/config")
+	t.Setenv("XDG_CACHE_HOME", "/elsewhere/cache")
+	t.Setenv("XDG_DATA_HOME", "/elsewhere/data")
+
+	home := filepath.FromSlash("/home/other")
+	config, cache, data := ".config", ".cache", ".local/share"
+	if runtime.GOOS == "darwin" {
+		config, cache, data = "Library/Application Support", "Library/Caches", "Library/Application Support"
+	}
+	in := func(rel string) string { return filepath.Join(home, filepath.FromSlash(rel)) }
+
+	for _, tt := range []struct{ path, want string }{
+		{"~", home},
+		{"~/.zshrc", in(".zshrc")},
+		{"~/.config/zed/", in(".config/zed")},
+		{"{config}", in(config)},
+		{"{config}/zed/settings.json", in(config + "/zed/settings.json")},
+		{"{cache}/Alice/messages", in(cache + "/Alice/messages")},
+		{"{data}/fonts", in(data + "/fonts")},
+		// Only a leading "~" or placeholder is expanded.
+		{"~other/.zshrc", "~other/.zshrc"},
+		{"{configs}/zed", "{configs}/zed"},
+		{"/etc/hosts", "/etc/hosts"},
+		{"relative/{config}", "relative/{config}"},
+	} {
+		if got := ExpandHomeIn(tt.path, home); got != tt.want {
+			t.Errorf("ExpandHomeIn(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// OverwritePolicy decides whether CopyFileWithOptions replaces an existing
+// destination file.
+type OverwritePolicy int
+
+const (
+	// OverwriteAlways replaces existing files (the default).
+	OverwriteAlways OverwritePolicy = iota
+	// OverwriteNever leaves existing files untouched.
+	OverwriteNever
+	// OverwriteIfNewer replaces a file only when the source is newer (by
+	// modification time) than the destination.
+	OverwriteIfNewer
+	// OverwriteIfDiffer replaces a file only when its contents differ.
+	OverwriteIfDiffer
+	// OverwritePrompt asks CopyOptions.Confirm before replacing a file whose
+	// contents differ.
+	OverwritePrompt
+	// OverwriteMissingOnly writes a file only when nothing exists at the
+	// destination. Unlike OverwriteNever it does not follow symlinks, so a
+	// dangling link counts as present and is left alone.
+	OverwriteMissingOnly
+)
+
+var overwritePolicyNames = map[OverwritePolicy]string{
+	OverwriteAlways:      "always",
+	OverwriteNever:       "never",
+	OverwriteIfNewer:     "if-newer",
+	OverwriteIfDiffer:    "if-differ",
+	OverwritePrompt:      "prompt",
+	OverwriteMissingOnly: "missing-only",
+}
+
+// String returns the flag name of p.
+func (p OverwritePolicy) String() string {
+	if name, ok := overwritePolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("OverwritePolicy(%d)", int(p))
+}
+
+// ParseOverwritePolicy returns the policy named s (always, never, if-newer,
+// if-differ, prompt or missing-only).
+func ParseOverwritePolicy(s string) (OverwritePolicy, error) {
+	for p, name := range overwritePolicyNames {
+		if strings.EqualFold(s, name) {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown overwrite policy %q (use always, never, if-newer, if-differ, prompt or missing-only)", s)
+}
+
+// CopyOptions tunes CopyFileWithOptions.
+type CopyOptions struct {
+	// Mode is the permission of the destination; zero keeps the default.
+	Mode os.FileMode
+	// Overwrite decides what happens when the destination already exists.
+	Overwrite OverwritePolicy
+	// SrcModTime overrides the source modification time compared by
+	// OverwriteIfNewer (e.g. the time recorded in a manifest).
+	SrcModTime time.Time
+	// Confirm is asked before overwriting dst under OverwritePrompt. When nil
+	// nothing is overwritten.
+	Confirm func(dst string) (bool, error)
+	// SkipTimes leaves dst with the time of the copy instead of the source's
+	// access and modification times.
+	SkipTimes bool
+}
+
+// ShouldOverwrite reports whether copying src to dst is allowed by opts. A
+// missing destination may always be written.
+func ShouldOverwrite(src, dst string, opts CopyOptions) (bool, error) {
+	if opts.Overwrite == OverwriteMissingOnly {
+		_, err := os.Lstat(dst)
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	current, err := os.Stat(dst)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	switch opts.Overwrite {
+	case OverwriteNever:
+		return false, nil
+	case OverwriteIfNewer:
+		srcTime := opts.SrcModTime
+		if srcTime.IsZero() {
+			info, err := os.Stat(src)
+			if err != nil {
+				return false, err
+			}
+			srcTime = info.ModTime()
+		}
+		return srcTime.After(current.ModTime()), nil
+	case OverwriteIfDiffer, OverwritePrompt:
+		same, err := FilesAreEqual(src, dst)
+		if err != nil {
+			return false, err
+		}
+		if same {
+			return false, nil
+		}
+		if opts.Overwrite == OverwriteIfDiffer {
+			return true, nil
+		}
+		if opts.Confirm == nil {
+			return false, nil
+		}
+		return opts.Confirm(dst)
+	}
+	return true, nil
+}
+
+// CopyFileWithOptions copies src to dst like CopyFile, honoring opts. It reports
+// whether the file was copied.
+func CopyFileWithOptions(src, dst string, opts CopyOptions) (bool, error) {
+	ok, err := ShouldOverwrite(src, dst, opts)
+	if err != nil || !ok {
+		return false, err
+	}
+	if opts.Mode != 0 {
+		return true, copyFile(src, dst, !opts.SkipTimes, opts.Mode)
+	}
+	return true, copyFile(src, dst, !opts.SkipTimes)
+}
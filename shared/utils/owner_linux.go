@@ -0,0 +1,16 @@
+//go:build linux
+
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+// keepOwner gives f the owner and group recorded in info, where permitted, so
+// replacing a file does not hand it over to the user running setup.
+func keepOwner(f *os.File, info os.FileInfo) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		_ = f.Chown(int(st.Uid), int(st.Gid))
+	}
+}
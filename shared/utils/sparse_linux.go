@@ -0,0 +1,53 @@
+//go:build linux
+
+package utils
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// copySparse copies in to out preserving holes, using SEEK_DATA/SEEK_HOLE to find
+// the data regions. It returns false without copying anything when in is not
+// sparse or the filesystem cannot report holes, so the caller falls back to a
+// plain copy.
+func copySparse(in, out *os.File) (bool, error) {
+	info, err := in.Stat()
+	if err != nil {
+		return false, err
+	}
+	size := info.Size()
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || size == 0 || st.Blocks*512 >= size {
+		return false, nil
+	}
+
+	fd := int(in.Fd())
+	var offset int64
+	for offset < size {
+		data, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			break // only a trailing hole is left
+		}
+		if err != nil {
+			if offset == 0 {
+				return false, nil // hole detection unsupported here
+			}
+			return false, err
+		}
+		hole, err := unix.Seek(fd, data, unix.SEEK_HOLE)
+		if err != nil {
+			return false, err
+		}
+		if _, err := io.Copy(io.NewOffsetWriter(out, data), io.NewSectionReader(in, data, hole-data)); err != nil {
+			return false, err
+		}
+		offset = hole
+	}
+	// Extend the destination to the full size so trailing holes are kept.
+	return true, out.Truncate(size)
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestCopyFileKeepsHoles(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "sparse")
+	const size = 64 << 20
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("head"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("tail"), size-4); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if allocated(t, src) >= size/2 {
+		t.Skip("the temp dir's filesystem does not keep holes")
+	}
+
+	dst := filepath.Join(dir, "copy")
+	if err := CopyFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if equal, err := FilesAreEqual(src, dst); err != nil || !equal {
+		t.Fatalf("copy differs from the source (err %v)", err)
+	}
+	if got := allocated(t, dst); got >= size/2 {
+		t.Errorf("copy allocates %d bytes of %d, want it to stay sparse", got, size)
+	}
+}
+
+// allocated returns the bytes of disk allocated to path.
+func allocated(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Sys().(*syscall.Stat_t).Blocks * 512
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package utils
+
+import "os"
+
+// copySparse is only implemented on Linux; elsewhere files are copied in full.
+func copySparse(in, out *os.File) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,17 @@
+//go:build linux
+
+package utils
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// accessTime returns the last access time recorded in info.
+func accessTime(info os.FileInfo) time.Time {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(st.Atim.Sec, st.Atim.Nsec)
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package utils
+
+import (
+	"os"
+	"time"
+)
+
+// accessTime is only read on Linux; elsewhere the modification time stands in.
+func accessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
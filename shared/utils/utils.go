@@ -1,14 +1,23 @@
 package utils
 
 import (
+	"bytes"
+	"fmt"
 	"io"
+	"math"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
-// ExpandHome expande o "~" para o diretório home do usuário.
+// ExpandHome expande o "~" para o diretório home do usuário, assim como os
+// marcadores de localização da plataforma ({config}, {cache}, {data}).
 func ExpandHome(path string) (string, error) {
+	if strings.HasPrefix(path, "{") {
+		return expandLocation(path)
+	}
 	if strings.HasPrefix(path, "~") {
 		home, err := os.UserHomeDir()
 		if err != nil {
@@ -21,32 +30,24 @@ func ExpandHome(path string) (string, error) {
 
 // CopyFile copies a file from src to dst, creating necessary directories.
 // If mode is provided, it sets the file permissions, otherwise uses default permissions.
-// It always overwrites the destination, but checks for differences and logs if different.
-// This is future-proof for interactive or conditional logic.
+// The access and modification times of src are carried over to dst.
+// It always overwrites the destination, atomically: readers see either the old
+// or the new contents, never a partial copy. Use CopyFileWithOptions to choose
+// an OverwritePolicy or to leave the times alone.
 func CopyFile(src, dst string, mode ...os.FileMode) error {
-	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
-		return err
-	}
-
-	// Check for differences if destination exists
-	dstExists := false
-	if fi, err := os.Stat(dst); err == nil && !fi.IsDir() {
-		dstExists = true
-	}
+	return copyFile(src, dst, true, mode...)
+}
 
-	filesDiffer := false
-	if dstExists {
-		same, err := filesAreEqual(src, dst)
-		if err == nil && !same {
-			filesDiffer = true
-		}
+// copyFile is CopyFile, preserving the times of src only when preserveTimes is set.
+// The copy is written to a temporary file next to dst, synced and renamed over
+// dst, so dst is never left half-written. A dst that is a symlink is replaced
+// through it.
+func copyFile(src, dst string, preserveTimes bool, mode ...os.FileMode) (err error) {
+	if resolved, err := filepath.EvalSymlinks(dst); err == nil {
+		dst = resolved
 	}
-
-	// Always overwrite, but log if different (future-proof for conditional logic)
-	if filesDiffer {
-		// In the future, prompt or handle differently if needed
-		// For now, just log (could be replaced with actual logger)
-		// fmt.Printf("Overwriting %s (differs from backup)\n", dst)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
 	}
 
 	in, err := os.Open(src)
@@ -55,30 +56,96 @@ func CopyFile(src, dst string, mode ...os.FileMode) error {
 	}
 	defer in.Close()
 
-	var out *os.File
+	// Without a mode a replaced file keeps its permissions and owner, as it
+	// would when truncated in place.
+	perm := os.FileMode(0666)
+	existing, statErr := os.Stat(dst)
 	if len(mode) > 0 {
-		out, err = os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode[0])
-	} else {
-		out, err = os.Create(dst)
+		perm = mode[0]
+	} else if statErr == nil {
+		perm = existing.Mode()
 	}
+	out, err := createTemp(dst, perm)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(out.Name())
+		}
+	}()
+	if statErr == nil {
+		keepOwner(out, existing)
+	}
+	if len(mode) == 0 && statErr == nil {
+		// The umask applied on create may have dropped bits dst had.
+		if err := out.Chmod(perm); err != nil {
+			return err
+		}
+	}
 
-	_, err = io.Copy(out, in)
+	// Preserve holes of sparse files (e.g. some databases) instead of expanding them.
+	sparse, err := copySparse(in, out)
 	if err != nil {
 		return err
 	}
+	if !sparse {
+		if _, err := io.Copy(out, in); err != nil {
+			return err
+		}
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if preserveTimes {
+		info, err := in.Stat()
+		if err != nil {
+			return err
+		}
+		if err := os.Chtimes(out.Name(), accessTime(info), info.ModTime()); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(out.Name(), dst); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(dst))
+	return nil
+}
 
-	if len(mode) == 0 {
-		return out.Sync()
+// createTemp creates a new file in the directory of dst to be renamed over it,
+// with perm (subject to the umask) like creating dst itself would.
+func createTemp(dst string, perm os.FileMode) (*os.File, error) {
+	dir, base := filepath.Split(dst)
+	for try := 0; ; try++ {
+		name := filepath.Join(dir, fmt.Sprintf(".%s.%d.tmp", base, rand.Uint32()))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) && try < 100 {
+			continue
+		}
+		return f, err
 	}
-	return err
 }
 
-// filesAreEqual compares two files for byte-for-byte equality.
-func filesAreEqual(path1, path2 string) (bool, error) {
+// syncDir flushes the directory entry of a renamed file to disk, where the
+// platform supports it.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	d.Close()
+}
+
+// FilesAreEqual compares two files for byte-for-byte equality. Files of
+// different sizes are told apart without being read, and two names of the same
+// file are equal.
+func FilesAreEqual(path1, path2 string) (bool, error) {
 	f1, err := os.Open(path1)
 	if err != nil {
 		return false, err
@@ -90,30 +157,73 @@ func filesAreEqual(path1, path2 string) (bool, error) {
 	}
 	defer f2.Close()
 
-	const chunkSize = 4096
+	info1, err := f1.Stat()
+	if err != nil {
+		return false, err
+	}
+	info2, err := f2.Stat()
+	if err != nil {
+		return false, err
+	}
+	if info1.Size() != info2.Size() {
+		return false, nil
+	}
+	if os.SameFile(info1, info2) {
+		return true, nil
+	}
+
+	// Large blocks keep the syscall count low on big files such as database
+	// WALs; comparing them directly stops at the first difference, which hashing
+	// both files could not.
+	const chunkSize = 256 << 10
 	b1 := make([]byte, chunkSize)
 	b2 := make([]byte, chunkSize)
 
 	for {
-		n1, err1 := f1.Read(b1)
-		n2, err2 := f2.Read(b2)
-		if n1 != n2 || (n1 > 0 && string(b1[:n1]) != string(b2[:n2])) {
+		n1, err1 := io.ReadFull(f1, b1)
+		n2, err2 := io.ReadFull(f2, b2)
+		if n1 != n2 || !bytes.Equal(b1[:n1], b2[:n2]) {
 			return false, nil
 		}
-		if err1 != nil || err2 != nil {
-			if err1 == io.EOF && err2 == io.EOF {
-				break
-			}
-			if err1 == io.EOF || err2 == io.EOF {
-				return false, nil
-			}
+		done1 := err1 == io.EOF || err1 == io.ErrUnexpectedEOF
+		done2 := err2 == io.EOF || err2 == io.ErrUnexpectedEOF
+		switch {
+		case err1 != nil && !done1:
 			return false, err1
+		case err2 != nil && !done2:
+			return false, err2
+		case done1 && done2:
+			return true, nil
+		case done1 || done2:
+			// A file changed size while being compared.
+			return false, nil
+		}
+	}
+}
+
+// CopySymlink recreates the symlink src at dst, pointing at the same (possibly
+// missing) target. A file or link already at dst is replaced; a directory is not.
+func CopySymlink(src, dst string) error {
+	link, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(dst); err == nil {
+		if info.IsDir() {
+			return fmt.Errorf("cannot replace directory %s with a symlink", dst)
+		}
+		if err := os.Remove(dst); err != nil {
+			return err
 		}
 	}
-	return true, nil
+	return os.Symlink(link, dst)
 }
 
-// CopyDir recursively copies a directory from src to dst.
+// CopyDir recursively copies a directory from src to dst. Symlinks are recreated
+// as links rather than copied through.
 func CopyDir(src, dst string) error {
 	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -127,6 +237,9 @@ func CopyDir(src, dst string) error {
 		if info.IsDir() {
 			return os.MkdirAll(target, info.Mode())
 		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return CopySymlink(path, target)
+		}
 		return CopyFile(path, target, info.Mode())
 	})
 }
@@ -138,3 +251,50 @@ func TrimLeadingSlash(path string) string {
 	}
 	return path
 }
+
+// FormatBytes renders a byte count in a human readable form (e.g. "1.5 MiB").
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// ParseBytes parses a byte count such as "2MiB", "512k", "1.5G" or "3MB". Single
+// letter and "iB" suffixes are binary (1024-based), "B" suffixes decimal; a
+// bare number is bytes.
+func ParseBytes(s string) (int64, error) {
+	v := strings.TrimSpace(s)
+	i := strings.IndexFunc(v, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	num, suffix := v, ""
+	if i >= 0 {
+		num, suffix = v[:i], strings.ToLower(strings.TrimSpace(v[i:]))
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	mult := 1.0
+	if suffix != "" && suffix != "b" {
+		exp := strings.IndexByte("kmgtpe", suffix[0]) + 1
+		base := 1024.0
+		switch suffix[1:] {
+		case "", "ib":
+		case "b":
+			base = 1000
+		default:
+			exp = 0
+		}
+		if exp == 0 {
+			return 0, fmt.Errorf("invalid size %q", s)
+		}
+		mult = math.Pow(base, float64(exp))
+	}
+	return int64(n * mult), nil
+}
@@ -0,0 +1,299 @@
+package utils
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCopyFileKeepsModTime(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.txt")
+	if err := os.WriteFile(src, []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local)
+	if err := os.Chtimes(src, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(dir, "dst.txt")
+	if err := CopyFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := info.ModTime().Sub(old).Abs(); d > time.Second {
+		t.Errorf("dst mtime = %v, want %v", info.ModTime(), old)
+	}
+
+	skipped := filepath.Join(dir, "skipped.txt")
+	if _, err := CopyFileWithOptions(src, skipped, CopyOptions{SkipTimes: true}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = os.Stat(skipped); err != nil {
+		t.Fatal(err)
+	}
+	if info.ModTime().Sub(old).Abs() <= time.Second {
+		t.Errorf("SkipTimes kept the source mtime %v", info.ModTime())
+	}
+}
+
+func TestCopyDirRecreatesSymlinks(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "sub", "file"), []byte("data\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"relative": "sub/file",
+		"absolute": filepath.Join(src, "sub", "file"),
+		"dir":      "sub",
+		"dangling": "missing",
+	}
+	for name, link := range links {
+		if err := os.Symlink(link, filepath.Join(src, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dst := filepath.Join(t.TempDir(), "dst")
+	if err := CopyDir(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	for name, link := range links {
+		path := filepath.Join(dst, name)
+		info, err := os.Lstat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("%s copied as %v, want a symlink", name, info.Mode())
+			continue
+		}
+		if got, _ := os.Readlink(path); got != link {
+			t.Errorf("%s points at %q, want %q", name, got, link)
+		}
+	}
+}
+
+func TestCopyFileFailureKeepsDestination(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "dst.txt")
+	if err := os.WriteFile(dst, []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Opening a directory succeeds but reading it fails mid-copy.
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CopyFile(src, dst); err == nil {
+		t.Fatal("copying a directory succeeded")
+	}
+	if data, err := os.ReadFile(dst); err != nil || string(data) != "old\n" {
+		t.Errorf("dst = %q, %v; want the old contents", data, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
+
+// lockstepFilesAreEqual is FilesAreEqual as it was before sizes were compared
+// first: both files read in lockstep 4 KiB at a time. It is kept as the
+// baseline of BenchmarkFilesAreEqual.
+func lockstepFilesAreEqual(path1, path2 string) (bool, error) {
+	f1, err := os.Open(path1)
+	if err != nil {
+		return false, err
+	}
+	defer f1.Close()
+	f2, err := os.Open(path2)
+	if err != nil {
+		return false, err
+	}
+	defer f2.Close()
+
+	const chunkSize = 4096
+	b1 := make([]byte, chunkSize)
+	b2 := make([]byte, chunkSize)
+	for {
+		n1, err1 := f1.Read(b1)
+		n2, err2 := f2.Read(b2)
+		if n1 != n2 || (n1 > 0 && string(b1[:n1]) != string(b2[:n2])) {
+			return false, nil
+		}
+		if err1 != nil || err2 != nil {
+			if err1 == io.EOF && err2 == io.EOF {
+				return true, nil
+			}
+			if err1 == io.EOF || err2 == io.EOF {
+				return false, nil
+			}
+			return false, err1
+		}
+	}
+}
+
+// writeBenchFiles writes two 50 MB files, identical except that the second has
+// its last byte changed when differ is set, or one extra byte when longer is.
+func writeBenchFiles(b *testing.B, differ, longer bool) (string, string) {
+	b.Helper()
+	dir := b.TempDir()
+	data := make([]byte, 50<<20)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	path1, path2 := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	if err := os.WriteFile(path1, data, 0o644); err != nil {
+		b.Fatal(err)
+	}
+	if differ {
+		data[len(data)-1]++
+	}
+	if longer {
+		data = append(data, 0)
+	}
+	if err := os.WriteFile(path2, data, 0o644); err != nil {
+		b.Fatal(err)
+	}
+	return path1, path2
+}
+
+func BenchmarkFilesAreEqual(b *testing.B) {
+	impls := []struct {
+		name  string
+		equal func(string, string) (bool, error)
+	}{
+		{"lockstep", lockstepFilesAreEqual},
+		{"current", FilesAreEqual},
+	}
+	cases := []struct {
+		name           string
+		differ, longer bool
+		want           bool
+	}{
+		{name: "equal", want: true},
+		{name: "differ-at-end", differ: true},
+		{name: "differ-in-size", longer: true},
+	}
+	for _, c := range cases {
+		path1, path2 := writeBenchFiles(b, c.differ, c.longer)
+		for _, impl := range impls {
+			b.Run(c.name+"/"+impl.name, func(b *testing.B) {
+				b.SetBytes(50 << 20)
+				for b.Loop() {
+					if same, err := impl.equal(path1, path2); err != nil || same != c.want {
+						b.Fatalf("got %v, %v; want %v", same, err, c.want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestCopyFileWithOptionsPolicies(t *testing.T) {
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	recent := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	yes := func(string) (bool, error) { return true, nil }
+	no := func(string) (bool, error) { return false, nil }
+
+	for _, tt := range []struct {
+		name     string
+		opts     CopyOptions
+		dst      string // existing destination contents; "" for none
+		srcTime  time.Time
+		dstTime  time.Time
+		dangling bool // dst is a dangling symlink
+		want     bool
+	}{
+		{name: "always", opts: CopyOptions{Overwrite: OverwriteAlways}, dst: "old\n", want: true},
+		{name: "never", opts: CopyOptions{Overwrite: OverwriteNever}, dst: "old\n", want: false},
+		{name: "never missing", opts: CopyOptions{Overwrite: OverwriteNever}, want: true},
+		{name: "if-newer newer", opts: CopyOptions{Overwrite: OverwriteIfNewer}, dst: "old\n", srcTime: recent, dstTime: old, want: true},
+		{name: "if-newer older", opts: CopyOptions{Overwrite: OverwriteIfNewer}, dst: "old\n", srcTime: old, dstTime: recent, want: false},
+		{name: "if-newer manifest time", opts: CopyOptions{Overwrite: OverwriteIfNewer, SrcModTime: recent.Add(time.Hour)}, dst: "old\n", srcTime: old, dstTime: recent, want: true},
+		{name: "if-newer stale manifest time", opts: CopyOptions{Overwrite: OverwriteIfNewer, SrcModTime: old}, dst: "old\n", srcTime: recent.Add(time.Hour), dstTime: recent, want: false},
+		{name: "if-differ same", opts: CopyOptions{Overwrite: OverwriteIfDiffer}, dst: "new\n", want: false},
+		{name: "if-differ differs", opts: CopyOptions{Overwrite: OverwriteIfDiffer}, dst: "old\n", want: true},
+		{name: "prompt nil confirm", opts: CopyOptions{Overwrite: OverwritePrompt}, dst: "old\n", want: false},
+		{name: "prompt yes", opts: CopyOptions{Overwrite: OverwritePrompt, Confirm: yes}, dst: "old\n", want: true},
+		{name: "prompt no", opts: CopyOptions{Overwrite: OverwritePrompt, Confirm: no}, dst: "old\n", want: false},
+		{name: "prompt same not asked", opts: CopyOptions{Overwrite: OverwritePrompt, Confirm: func(string) (bool, error) { t.Error("asked about an identical file"); return true, nil }}, dst: "new\n", want: false},
+		{name: "missing-only existing", opts: CopyOptions{Overwrite: OverwriteMissingOnly}, dst: "old\n", want: false},
+		{name: "missing-only dangling link", opts: CopyOptions{Overwrite: OverwriteMissingOnly}, dangling: true, want: false},
+		{name: "missing-only missing", opts: CopyOptions{Overwrite: OverwriteMissingOnly}, want: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+			if err := os.WriteFile(src, []byte("new\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.srcTime.IsZero() {
+				if err := os.Chtimes(src, tt.srcTime, tt.srcTime); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.dst != "" {
+				if err := os.WriteFile(dst, []byte(tt.dst), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				if !tt.dstTime.IsZero() {
+					if err := os.Chtimes(dst, tt.dstTime, tt.dstTime); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if tt.dangling {
+				if err := os.Symlink("missing", dst); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			copied, err := CopyFileWithOptions(src, dst, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if copied != tt.want {
+				t.Errorf("copied = %v, want %v", copied, tt.want)
+			}
+			if tt.dangling {
+				return
+			}
+			want := tt.dst
+			if copied || tt.dst == "" {
+				want = "new\n"
+			}
+			if data, _ := os.ReadFile(dst); string(data) != want {
+				t.Errorf("dst = %q, want %q", data, want)
+			}
+		})
+	}
+}
+
+func TestParseOverwritePolicy(t *testing.T) {
+	for _, p := range []OverwritePolicy{OverwriteAlways, OverwriteNever, OverwriteIfNewer, OverwriteIfDiffer, OverwritePrompt, OverwriteMissingOnly} {
+		got, err := ParseOverwritePolicy(p.String())
+		if err != nil || got != p {
+			t.Errorf("ParseOverwritePolicy(%q) = %v, %v; want %v", p.String(), got, err, p)
+		}
+	}
+	if got, err := ParseOverwritePolicy("If-Newer"); err != nil || got != OverwriteIfNewer {
+		t.Errorf("ParseOverwritePolicy is case sensitive: %v, %v", got, err)
+	}
+	if _, err := ParseOverwritePolicy("sometimes"); err == nil {
+		t.Error("ParseOverwritePolicy accepted an unknown policy")
+	}
+}